@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scope is an access to the resource requested by client, for example repository:samalba/app:pull,push
+type Scope struct {
+	Type    string
+	Name    string
+	Actions []string
+}
+
+// Decision is result of the access check, Rule is granted action allowed the access
+type Decision struct {
+	Scope   string `json:"scope"`
+	Allowed bool   `json:"allowed"`
+	Rule    string `json:"rule,omitempty"`
+}
+
+// ParseScope parses scope in the type:name:action[,action] form, resource name could contain colons
+func ParseScope(s string) (Scope, error) {
+	first, last := strings.Index(s, ":"), strings.LastIndex(s, ":")
+	if first <= 0 || last == first || last == len(s)-1 || first+1 == last {
+		return Scope{}, fmt.Errorf("Invalid scope format: '%s'", s)
+	}
+	return Scope{Type: s[:first], Name: s[first+1 : last], Actions: strings.Split(s[last+1:], ",")}, nil
+}
+
+// String returns scope in the type:name:action[,action] form
+func (s Scope) String() string {
+	return s.Type + ":" + s.Name + ":" + strings.Join(s.Actions, ",")
+}
+
+// Decide checks granted actions allow action on the resource of the given type. Zone actions are
+// written as name:action and apply to repositories, both parts could contain '*' and '?' wildcards
+// which don't cross slashes of repository names
+func Decide(granted []string, typ, name, action string) Decision {
+	d := Decision{Scope: typ + ":" + name + ":" + action}
+	if typ != "repository" {
+		return d
+	}
+
+	for _, g := range granted {
+		if ActionMatches(g, name+":"+action) {
+			d.Allowed, d.Rule = true, g
+			return d
+		}
+	}
+	return d
+}
+
+// Intersect returns requested scopes reduced to the granted actions, scopes nothing is granted for
+// are dropped
+func Intersect(granted []string, scopes []Scope) []Scope {
+	result := make([]Scope, 0, len(scopes))
+	for _, s := range scopes {
+		allowed := Scope{Type: s.Type, Name: s.Name, Actions: make([]string, 0, len(s.Actions))}
+		for _, a := range s.Actions {
+			if Decide(granted, s.Type, s.Name, a).Allowed {
+				allowed.Actions = append(allowed.Actions, a)
+			}
+		}
+
+		if len(allowed.Actions) > 0 {
+			result = append(result, allowed)
+		}
+	}
+	return result
+}
+
+// ActionMatches reports whether granted action covers the required one, both are in the
+// name:action form. Granted action could contain wildcards, for example *:pull or cerber:zone/*:admin.
+// Cerber own actions are covered only by granted actions of cerber resources, so repository wildcards
+// such as *:* never grant them
+func ActionMatches(granted, required string) bool {
+	if strings.HasPrefix(required, CerberResource) && !strings.HasPrefix(granted, CerberResource) {
+		return false
+	} else if granted == required {
+		return true
+	}
+
+	gi, ri := strings.LastIndex(granted, ":"), strings.LastIndex(required, ":")
+	if gi == -1 || ri == -1 {
+		return false
+	}
+	return wildcard(granted[:gi], required[:ri]) && wildcard(granted[gi+1:], required[ri+1:])
+}
+
+// CerberResource prefixes names of cerber own resources, such as cerber:zone/<name>
+const CerberResource = "cerber:"
+
+// IsCerberResource checks resource name refers to cerber itself rather than to a repository
+func IsCerberResource(name string) bool {
+	return strings.HasPrefix(name, CerberResource)
+}
+
+// wildcard matches string against pattern where '*' matches any sequence of characters within a
+// path segment and '?' matches any single character except slash
+func wildcard(pattern, s string) bool {
+	star, next := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, i
+			p++
+		case p < len(pattern) && s[i] != '/' && pattern[p] == '?', p < len(pattern) && pattern[p] == s[i]:
+			p++
+			i++
+		case star != -1 && s[next] != '/':
+			next++
+			p, i = star+1, next
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
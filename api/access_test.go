@@ -0,0 +1,139 @@
+package api
+
+import "testing"
+
+// TestDecide checks scopes are matched against granted actions with wildcards
+func TestDecide(t *testing.T) {
+	granted := []string{"xphoenix/*:pull", "xphoenix/cerber:push", "library/?ongo:*", "cerber:zone/*:admin"}
+
+	cases := []struct {
+		name, action string
+		rule         string
+	}{
+		{"xphoenix/cerber", "pull", "xphoenix/*:pull"},
+		{"xphoenix/tools/build", "pull", ""},
+		{"xphoenix/cerber", "push", "xphoenix/cerber:push"},
+		{"xphoenix/mongo", "push", ""},
+		{"library/mongo", "delete", "library/?ongo:*"},
+		{"library/mongodb", "pull", ""},
+		{"cerber:zone/registry", "admin", "cerber:zone/*:admin"},
+	}
+	for _, c := range cases {
+		d := Decide(granted, "repository", c.name, c.action)
+		if d.Allowed != (c.rule != "") || d.Rule != c.rule {
+			t.Fatalf("Unexpected decision for %s:%s: %+v", c.name, c.action, d)
+		}
+	}
+
+	for _, g := range []string{"*:*", "*:admin", "*", "cerber*:*"} {
+		if ActionMatches(g, "cerber:zone/registry:admin") || ActionMatches(g, "cerber:admin") {
+			t.Fatalf("Repository wildcard %s must not grant cerber actions", g)
+		}
+	}
+	if !ActionMatches("cerber:*:admin", "cerber:zone:admin") || ActionMatches("cerber:*:admin", "cerber:zone/registry:admin") {
+		t.Fatal("Wildcard of cerber action must not cross slashes")
+	}
+
+	if Decide(granted, "registry", "catalog", "pull").Allowed {
+		t.Fatal("Zone actions must apply to repositories only")
+	}
+}
+
+// TestIntersect checks requested scopes are reduced to the granted actions
+func TestIntersect(t *testing.T) {
+	s, err := ParseScope("repository:localhost:5000/app:pull,push")
+	if err != nil {
+		t.Fatal(err)
+	} else if s.Name != "localhost:5000/app" || len(s.Actions) != 2 {
+		t.Fatalf("Scope is parsed wrong: %+v", s)
+	}
+
+	for _, invalid := range []string{"", "repository", "repository:app", "repository::pull", "repository:app:"} {
+		if _, err := ParseScope(invalid); err == nil {
+			t.Fatalf("Expected scope %q to be invalid", invalid)
+		}
+	}
+
+	other, _ := ParseScope("repository:other:pull")
+	result := Intersect([]string{"localhost:5000/*:pull"}, []Scope{s, other})
+	if len(result) != 1 || result[0].String() != "repository:localhost:5000/app:pull" {
+		t.Fatalf("Unexpected scopes granted: %v", result)
+	}
+}
+
+// memberZone is a stub zone with users and groups
+type memberZone struct {
+	stubZone
+	users  map[string]*User
+	groups map[string]*Group
+}
+
+func (z *memberZone) FindUser(userID string) (*User, error) {
+	if u, ok := z.users[userID]; ok {
+		return u, nil
+	}
+	return nil, NewNotFoundError("user", userID)
+}
+
+func (z *memberZone) FindGroup(groupID string) (*Group, error) {
+	if g, ok := z.groups[groupID]; ok {
+		return g, nil
+	}
+	return nil, NewNotFoundError("group", groupID)
+}
+
+// TestExplain checks explanation traces patterns of every group and grants the same access as login
+func TestExplain(t *testing.T) {
+	z := &memberZone{
+		stubZone: stubZone{name: "registry"},
+		users: map[string]*User{
+			"ci":     {Name: "ci", Groups: []string{"read", "write"}},
+			"broken": {Name: "broken", Groups: []string{"read", "missing"}},
+		},
+		groups: map[string]*Group{
+			"read":  {Name: "read", Actions: []string{"*:pull"}},
+			"write": {Name: "write", Actions: []string{"app:push"}},
+		},
+	}
+
+	cerber, _ := New("test")
+	scopes := []Scope{{Type: "repository", Name: "app", Actions: []string{"pull", "push", "delete"}}}
+	e, err := cerber.Explain(z, "ci", scopes, AccessContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(e.Groups) != 2 || len(e.Checks) != 3 {
+		t.Fatalf("Unexpected explanation: %+v", e)
+	} else if !e.Checks[1].Allowed || e.Checks[1].Rule != "app:push" || len(e.Checks[1].Patterns) != 2 {
+		t.Fatalf("Push is not explained: %+v", e.Checks[1])
+	} else if e.Checks[2].Allowed {
+		t.Fatalf("Delete must be denied: %+v", e.Checks[2])
+	} else if len(e.Access) != 1 || e.Access[0].String() != "repository:app:pull,push" {
+		t.Fatalf("Unexpected access: %v", e.Access)
+	}
+
+	if e, err := cerber.Explain(z, "broken", scopes, AccessContext{}); err != nil || e.Error == "" || len(e.Groups) != 1 {
+		t.Fatalf("Expected failed login to be explained: %+v %v", e, err)
+	}
+}
+
+// TestAnonymous checks actions of the anonymous group are granted without credentials
+func TestAnonymous(t *testing.T) {
+	cerber, _ := New("test")
+	z := &memberZone{stubZone: stubZone{name: "registry"}, groups: map[string]*Group{}}
+	if _, err := cerber.Anonymous(z, AccessContext{}); !IsNotFound(err) {
+		t.Fatalf("Expected zone without anonymous group to require credentials, found: %v", err)
+	}
+
+	z.groups[AnonymousGroup] = &Group{Name: AnonymousGroup, Actions: []string{"library/*:pull"}}
+	actions, err := cerber.Anonymous(z, AccessContext{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	granted := Intersect(actions, []Scope{{Type: "repository", Name: "library/debian", Actions: []string{"pull", "push"}}})
+	if len(granted) != 1 || granted[0].String() != "repository:library/debian:pull" {
+		t.Fatalf("Unexpected anonymous access: %v", granted)
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// AuditEvent describes change of the zone requested through cerber API
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+
+	// Zone changed and the change itself
+	Zone      string `json:"zone"`
+	Operation string `json:"operation"`
+	Target    string `json:"target"`
+
+	// Who requested the change: user, zone of the user token and role user has in the changed zone
+	Actor     string `json:"actor"`
+	ActorZone string `json:"actor_zone"`
+	Role      string `json:"role"`
+
+	// Result is one of success, denied or failed, Reason explains the last two
+	Result string `json:"result"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Audit records event into the audit log, events are written to the main log if audit log is
+// not configured
+func (c *Cerber) Audit(e AuditEvent) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	if c.AuditLog == nil {
+		log.WithFields(log.Fields{
+			"audit":      true,
+			"zone":       e.Zone,
+			"target":     e.Target,
+			"actor":      e.Actor,
+			"actor_zone": e.ActorZone,
+			"role":       e.Role,
+			"result":     e.Result,
+			"reason":     e.Reason,
+		}).Info(e.Operation)
+		return
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.WithField("reason", err).Error("Failed to encode audit event")
+		return
+	}
+
+	c.auditLock.Lock()
+	defer c.auditLock.Unlock()
+	if _, err := c.AuditLog.Write(append(data, '\n')); err != nil {
+		log.WithField("reason", err).Error("Failed to write audit event")
+	}
+}
@@ -1,10 +1,12 @@
 package api
 
 import (
-	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
@@ -17,8 +19,35 @@ import (
 // e.t.c. Once logedin Cerber generates JWT token that could be used for authorization
 // in different actions
 type Cerber struct {
-	Realm     string
-	providers []Provider
+	Realm string
+
+	// Delay before failed provider is restarted, doubles on every failure up to RetryMax
+	RetryMin time.Duration
+	RetryMax time.Duration
+
+	// How often running providers are checked to be online
+	CheckInterval time.Duration
+
+	// Strict forbids the same zone to be served by several providers. If set zone lookup fails
+	// on conflict, otherwise zone of the provider with highest priority is used
+	Strict bool
+
+	// How long before zone certificate expiry warnings are logged
+	ExpiryWarning time.Duration
+
+	// Audit log of zone changes, one JSON event per line. Events are written to the main log if nil
+	AuditLog io.Writer
+
+	lock      sync.RWMutex
+	providers []*registration
+
+	conflictsLock sync.Mutex
+	conflicts     map[string]string
+
+	expiryLock   sync.Mutex
+	expiryWarned map[string]time.Time
+
+	auditLock sync.Mutex
 }
 
 // New creates a new instance of cerber checking that passed parameters are all makes sense
@@ -30,64 +59,398 @@ type Cerber struct {
 // refresh maxmum time during what token is allowed to be refreshed. If not set default value if 1h is used
 func New(realm string) (instance *Cerber, err error) {
 	return &Cerber{
-		Realm:     realm,
-		providers: make([]Provider, 0, 3),
+		Realm:         realm,
+		RetryMin:      time.Second,
+		RetryMax:      5 * time.Minute,
+		CheckInterval: 30 * time.Second,
+		ExpiryWarning: 30 * 24 * time.Hour,
+		providers:     make([]*registration, 0, 3),
+		conflicts:     make(map[string]string),
+		expiryWarned:  make(map[string]time.Time),
 	}, nil
 }
 
-// AddProvider Registers new Cerber Zone Provider which will be used to lookup Authentification
-// zones
-func (c *Cerber) AddProvider(p Provider) {
-	for _, ep := range c.providers {
-		if ep == p {
-			return
+// AddProvider Registers new Cerber Zone Provider with default priority 0, see AddProviderWithPriority
+func (c *Cerber) AddProvider(p Provider) error {
+	return c.AddProviderWithPriority(p, 0)
+}
+
+// AddProviderWithPriority Registers new Cerber Zone Provider which will be used to lookup Authentification
+// zones and starts it. Providers with higher priority are queried first, providers with the same priority
+// are queried in registration order. If provider fails to start, error of the first attempt returns, but
+// provider stays registered and Cerber keeps restarting it with backoff in background
+func (c *Cerber) AddProviderWithPriority(p Provider, priority int) error {
+	c.lock.Lock()
+	for _, r := range c.providers {
+		if r.provider == p {
+			c.lock.Unlock()
+			return nil
 		}
 	}
 
-	c.providers = append(c.providers, p)
+	// Keep providers ordered by priority, slice is replaced so readers could use old one safely
+	r := newRegistration(p, priority)
+	pos := len(c.providers)
+	for i, e := range c.providers {
+		if e.priority < priority {
+			pos = i
+			break
+		}
+	}
+
+	providers := make([]*registration, 0, len(c.providers)+1)
+	providers = append(providers, c.providers[:pos]...)
+	providers = append(providers, r)
+	c.providers = append(providers, c.providers[pos:]...)
+	c.lock.Unlock()
+
+	err := r.start()
+	go r.supervise(c)
+	return err
+}
+
+// RemoveProvider stops given provider and excludes it from zone lookup
+func (c *Cerber) RemoveProvider(p Provider) error {
+	c.lock.Lock()
+	var found *registration
+	for i, r := range c.providers {
+		if r.provider == p {
+			found = r
+			c.providers = append(c.providers[:i:i], c.providers[i+1:]...)
+			break
+		}
+	}
+	c.lock.Unlock()
+
+	if found == nil {
+		return fmt.Errorf("Provider is not registered: %s", redactURL(p))
+	}
+	return found.shutdown()
+}
+
+// Providers returns all registered providers
+func (c *Cerber) Providers() []Provider {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	result := make([]Provider, len(c.providers))
+	for i, r := range c.providers {
+		result[i] = r.provider
+	}
+	return result
 }
 
-// FindZone looks up zone with given name across all provider registered in the Cerber instance. If there is no
-// provider with Zone has given name error returns
+// Status returns health of all registered providers
+func (c *Cerber) Status() []ProviderStatus {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	result := make([]ProviderStatus, len(c.providers))
+	for i, r := range c.providers {
+		result[i] = r.status()
+	}
+	return result
+}
+
+// Stop stops all registered providers
+func (c *Cerber) Stop() {
+	for _, p := range c.Providers() {
+		if err := c.RemoveProvider(p); err != nil {
+			log.Warnf("Failed to stop zone provider '%s': %s", redactURL(p), err)
+		}
+	}
+}
+
+// FindZone looks up zone with given name across all online providers registered in the Cerber instance.
+// Providers are queried in priority order and the first found zone returns. If provider failed to answer
+// UnavailableError returns, as zone could be shadowed by the failed provider. If no provider has the zone
+// NotFoundError returns. In strict mode all providers are queried and ConflictError returns if zone is
+// found more than once
 func (c *Cerber) FindZone(name string) (Zone, error) {
-	for _, p := range c.providers {
+	c.lock.RLock()
+	providers := c.providers
+	c.lock.RUnlock()
+
+	var found Zone
+	var sources []string
+	for _, r := range providers {
+		if !r.online() {
+			continue
+		}
+
+		p := r.provider
 		z, err := p.FindZone(name)
 		if err != nil {
-			// TODO: Log only errors, do not log 'not found'
-			log.Debugf("Error query provider %s[%s]: %s\n", p.URL().String(), name, err)
+			if IsNotFound(err) {
+				continue
+			}
+
+			log.Warnf("Error query provider %s[%s]: %s", redactURL(p), name, err)
+			if found != nil {
+				// Zone is already resolved, failed provider has lower priority
+				continue
+			}
+			if IsUnavailable(err) {
+				return nil, err
+			}
+			return nil, &UnavailableError{Source: redactURL(p), Err: err}
 		}
 
-		if z != nil {
-			return z, nil
+		if found == nil {
+			found = z
+		}
+		sources = append(sources, redactURL(p))
+
+		if !c.Strict {
+			break
 		}
 	}
-	return nil, fmt.Errorf("There is no zone with name: %s", name)
+
+	if found == nil {
+		return nil, NewNotFoundError("zone", name)
+	} else if len(sources) > 1 {
+		return nil, &ConflictError{Zone: name, Providers: sources}
+	}
+	return found, nil
+}
+
+// Conflicts returns zones served by more than one online provider along with URLs of that providers
+// in priority order
+func (c *Cerber) Conflicts() map[string][]string {
+	c.lock.RLock()
+	providers := c.providers
+	c.lock.RUnlock()
+
+	names := make(map[string]string)
+	served := make(map[string][]string)
+	for _, r := range providers {
+		if !r.online() {
+			continue
+		}
+		for _, z := range r.provider.Zones() {
+			key := strings.ToUpper(z)
+			if _, ok := names[key]; !ok {
+				names[key] = z
+			}
+			served[key] = append(served[key], redactURL(r.provider))
+		}
+	}
+
+	result := make(map[string][]string)
+	for key, urls := range served {
+		if len(urls) > 1 {
+			result[names[key]] = urls
+		}
+	}
+	return result
+}
+
+// checkConflicts logs zones which became served by several providers since the last check
+func (c *Cerber) checkConflicts() {
+	conflicts := c.Conflicts()
+
+	c.conflictsLock.Lock()
+	defer c.conflictsLock.Unlock()
+
+	current := make(map[string]string, len(conflicts))
+	for zone, urls := range conflicts {
+		desc := strings.Join(urls, ", ")
+		current[zone] = desc
+		if c.conflicts[zone] == desc {
+			continue
+		}
+
+		if c.Strict {
+			log.Errorf("Zone %s is served by several providers and is not available in strict mode: %s", zone, desc)
+		} else {
+			log.Warnf("Zone %s is served by several providers, using the first one: %s", zone, desc)
+		}
+	}
+	c.conflicts = current
 }
 
 // Authorize given user in the given zone
-// Provided password must be encrypted by zone specific method
-func (c *Cerber) Authorize(z Zone, user, passwd string) ([]string, error) {
-	// TODO: check password, calculate & resolve actions
-	usr, err := z.FindUser(user)
+// Provided password must be encrypted by zone specific method. UnavailableError returns as is, so
+// callers could tell failed backend apart from wrong credentials. UserStateError returns if user
+// is disabled, expired or must change password. Conditional groups are checked against the request
+// context
+func (c *Cerber) Authorize(z Zone, user, passwd string, ctx AccessContext) ([]string, error) {
+	usr, err := authenticate(z, user, passwd)
 	if err != nil {
+		return nil, err
+	}
+
+	// State is checked after credentials, so it is not disclosed to whoever guesses user names
+	if err := usr.CheckState(ctx.Time); err != nil {
+		return nil, err
+	}
+	return c.grant(z, usr, ctx)
+}
+
+// authenticate checks credentials of the user, password must be encrypted by zone specific method
+func authenticate(z Zone, user, passwd string) (*User, error) {
+	if a, ok := Underlying(z).(Authenticator); ok {
+		// Zone verifies credentials by itself
+		u, err := a.Authenticate(user, passwd)
+		if IsUnavailable(err) {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("Failed to authenticate user: %s", err)
+		}
+		return u, nil
+	}
+
+	u, err := z.FindUser(user)
+	if IsUnavailable(err) {
+		return nil, err
+	} else if err != nil {
 		return nil, fmt.Errorf("Failed to obtain user info: %s", err)
 	}
 
-	if usr.Passwd != passwd {
+	if u.Passwd != passwd {
 		return nil, errors.New("Wrong password")
 	}
+	return u, nil
+}
+
+// ChangePassword replaces password of the user after the current one is verified, both passwords are
+// given in plain text. Users which password expired or must be changed are allowed to change it,
+// disabled and expired ones are not. New password must satisfy zone password policy,
+// PasswordPolicyError returns otherwise. Change time recorded by the zone revokes tokens issued before
+func (c *Cerber) ChangePassword(z Zone, user, current, passwd string) error {
+	w, ok := Writable(z)
+	if !ok {
+		return &ReadOnlyError{Zone: z.Name(), Reason: "zone source doesn't support password changes"}
+	}
+
+	hash, err := z.HashPassword(current)
+	if err != nil {
+		return err
+	}
 
-	// Resolve user groups
+	usr, err := authenticate(z, user, hash)
+	if err != nil {
+		return err
+	} else if err, ok := usr.CheckState(time.Now()).(*UserStateError); ok && (err.Code == UserDisabled || err.Code == UserExpired) {
+		return err
+	}
+
+	if passwd == "" {
+		return &PasswordPolicyError{Reason: "password is required"}
+	} else if hash, err = z.HashPassword(passwd); err != nil {
+		return err
+	} else if err := ZonePasswordPolicy(z).Check(usr, passwd, hash); err != nil {
+		return err
+	}
+	return w.SetPassword(usr.Name, hash)
+}
+
+// AnonymousGroup is a zone group which actions are granted to token requests without credentials
+const AnonymousGroup = "anonymous"
+
+// Anonymous returns actions the zone grants to token requests without credentials. NotFoundError
+// returns if zone has no anonymous group, so such requests must be authenticated
+func (c *Cerber) Anonymous(z Zone, ctx AccessContext) ([]string, error) {
+	if _, err := z.FindGroup(AnonymousGroup); err != nil {
+		return nil, err
+	}
+	return c.grant(z, &User{Groups: []string{AnonymousGroup}}, ctx)
+}
+
+// UserActions returns actions granted to the user of the zone in the context without checking
+// credentials. UserStateError returns if user state doesn't allow access
+func (c *Cerber) UserActions(z Zone, user string, ctx AccessContext) ([]string, error) {
+	usr, err := z.FindUser(user)
+	if IsUnavailable(err) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to obtain user info: %s", err)
+	} else if err := usr.CheckState(ctx.Time); err != nil {
+		return nil, err
+	}
+
+	grants, err := userGroups(z, usr, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return applyPolicies(z, usr, grants, ctx)
+}
+
+// grant resolves actions granted to the user in the context by groups and zone policies. Groups
+// withheld by their conditions are audited, conditions which hold are logged at debug level only
+func (c *Cerber) grant(z Zone, usr *User, ctx AccessContext) ([]string, error) {
+	grants, err := userGroups(z, usr, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	denied := make([]string, 0)
+	for _, g := range grants {
+		if g.When == nil {
+			continue
+		} else if g.Granted {
+			log.WithFields(log.Fields{"zone": z.Name(), "user": usr.Name, "group": g.Name}).Debug("Conditional group granted")
+		} else {
+			denied = append(denied, fmt.Sprintf("%s denied, %s", g.Name, g.Reason))
+		}
+	}
+
+	if len(denied) > 0 {
+		e := AuditEvent{
+			Time:      ctx.Time,
+			Zone:      z.Name(),
+			Operation: "conditional_grant",
+			Target:    usr.Name,
+			Actor:     usr.Name,
+			ActorZone: z.Name(),
+			Result:    "denied",
+			Reason:    strings.Join(denied, "; "),
+		}
+		if ctx.ClientIP != nil {
+			e.Reason = fmt.Sprintf("client %s: %s", ctx.ClientIP, e.Reason)
+		}
+		c.Audit(e)
+	}
+	return applyPolicies(z, usr, grants, ctx)
+}
+
+// grantedActions returns actions of groups which conditions hold
+func grantedActions(grants []GroupGrant) []string {
 	actions := make([]string, 0, 3)
+	for _, g := range grants {
+		if g.Granted {
+			actions = append(actions, g.Actions...)
+		}
+	}
+	return actions
+}
+
+// userGroups resolves user groups and checks their conditions in the context, expired memberships
+// are not granted. Groups resolved before failure are returned along with the error
+func userGroups(z Zone, usr *User, ctx AccessContext) ([]GroupGrant, error) {
+	if ctx.Time.IsZero() {
+		ctx.Time = time.Now()
+	}
+
+	result := make([]GroupGrant, 0, len(usr.Groups))
 	for _, g := range usr.Groups {
+		until := usr.Until[g]
+		if usr.Expired(g, ctx.Time) {
+			result = append(result, GroupGrant{Name: g, Until: until, Reason: "membership expired at " + until})
+			continue
+		}
+
 		grp, err := z.FindGroup(g)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to get group info: %s", g)
+		if IsUnavailable(err) {
+			return result, err
+		} else if err != nil {
+			return result, fmt.Errorf("Failed to get group info: %s", g)
 		}
-		actions = append(actions, grp.Actions...)
-	}
 
-	return actions, nil
+		granted, reason := grp.When.Check(ctx)
+		result = append(result, GroupGrant{Name: grp.Name, Actions: grp.Actions, When: grp.When, Until: until, Granted: granted, Reason: reason})
+	}
+	return result, nil
 }
 
 // GenerateToken creates new token for the given user
@@ -98,8 +461,13 @@ func (c *Cerber) GenerateToken(service, userName, account, scope string, claims
 		return nil, fmt.Errorf("Unknown zone: %s", service)
 	}
 
+	method, err := zoneSigningMethod(z)
+	if err != nil {
+		return nil, err
+	}
+
 	// Copy claims first, later stages will override system claims with neccessary values
-	token := jwt.New(jwt.GetSigningMethod("RS256"))
+	token := jwt.New(method)
 	for k, v := range claims {
 		token.Claims[k] = v
 	}
@@ -109,7 +477,7 @@ func (c *Cerber) GenerateToken(service, userName, account, scope string, claims
 	token.Claims["id"] = userName
 	token.Claims["aud"] = z.Name()
 	token.Claims["exp"] = time.Now().Add(z.Timeout()).Unix()
-	token.Claims["orig_iat"] = time.Now().Unix()
+	token.Claims["orig_iat"] = unixTime(time.Now())
 
 	return c.signToken(z, token)
 }
@@ -133,28 +501,17 @@ func (c *Cerber) ParseToken(tokenInput string) (*jwt.Token, error) {
 		}
 
 		// Verify used algorithm is the one zone expects to have
-		if token.Header["alg"] != "RS256" {
+		method, err := zoneSigningMethod(zone)
+		if err != nil {
+			return nil, err
+		} else if token.Header["alg"] != method.Alg() {
 			return nil, fmt.Errorf("Unexpected signing algorithm: %s", token.Header["alg"])
-		} else if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+		} else if token.Method.Alg() != method.Alg() {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
 
-		// Use zone private key for validation
-		cert, err := zone.Certificate()
-		if err != nil {
-			return nil, fmt.Errorf("Failed to request zone key for validation: %s", zone.Name())
-		}
-
-		if cert.Leaf == nil {
-			// Use zone public key. Leaft certificate is the first in the chain
-			c, err := x509.ParseCertificate(cert.Certificate[0])
-			if err != nil {
-				return nil, fmt.Errorf("Failed to parse zone certificate: %s", err)
-			}
-			cert.Leaf = c
-		}
-
-		return cert.Leaf.PublicKey, nil
+		// Use zone key token was signed with for validation, it could be already rotated
+		return verificationKey(zone, token)
 	})
 
 	if err != nil {
@@ -165,8 +522,8 @@ func (c *Cerber) ParseToken(tokenInput string) (*jwt.Token, error) {
 }
 
 // RefreshToken extends token life for zone Timeout starting from the call time. If Zone#MaxRefresh passed since token
-// was issued then refresh is not possible and error returns. In case if token was refreshed fully signed token string
-// returns
+// was issued or user state doesn't allow access anymore then refresh is not possible and error returns. In case if
+// token was refreshed fully signed token string returns
 func (c *Cerber) RefreshToken(token *jwt.Token) (*string, error) {
 	name := token.Claims["aud"].(string)
 	if name == "" {
@@ -179,12 +536,21 @@ func (c *Cerber) RefreshToken(token *jwt.Token) (*string, error) {
 		return nil, fmt.Errorf("Failed to find zone: %s", name)
 	}
 
-	origIat := int64(token.Claims["orig_iat"].(float64))
-	if zone.MaxRefresh() > 0 && origIat < time.Now().Add(-zone.MaxRefresh()).Unix() {
+	if err := tokenUser(zone, token); err != nil {
+		return nil, err
+	}
+
+	origIat := token.Claims["orig_iat"].(float64)
+	if zone.MaxRefresh() > 0 && origIat < unixTime(time.Now().Add(-zone.MaxRefresh())) {
 		return nil, fmt.Errorf("Token excited maximum lifetime configured for the zone, login again: %s", name)
 	}
 
-	newToken := jwt.New(jwt.GetSigningMethod("RS256"))
+	method, err := zoneSigningMethod(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	newToken := jwt.New(method)
 	for key := range token.Claims {
 		newToken.Claims[key] = token.Claims[key]
 	}
@@ -195,24 +561,79 @@ func (c *Cerber) RefreshToken(token *jwt.Token) (*string, error) {
 	return c.signToken(zone, newToken)
 }
 
+// ValidateToken checks user of the parsed token still exists and its state allows access, so tokens of
+// disabled users are rejected before they expire
+func (c *Cerber) ValidateToken(token *jwt.Token) error {
+	name, _ := token.Claims["aud"].(string)
+	zone, err := c.FindZone(name)
+	if err != nil {
+		return fmt.Errorf("Failed to find zone: %s", name)
+	}
+	return tokenUser(zone, token)
+}
+
+// tokenUser checks state of the token subject and that token was issued after the last password
+// change. Tokens issued to anonymous requests have no subject
+func tokenUser(z Zone, token *jwt.Token) error {
+	sub, _ := token.Claims["sub"].(string)
+	if sub == "" {
+		return nil
+	}
+
+	usr, err := z.FindUser(sub)
+	if IsUnavailable(err) {
+		return err
+	} else if err != nil {
+		return fmt.Errorf("Failed to obtain token user info: %s", err)
+	} else if err := usr.CheckState(time.Now()); err != nil {
+		return err
+	}
+
+	// Password change revokes tokens issued before it, both times have sub-second precision
+	origIat, _ := token.Claims["orig_iat"].(float64)
+	if changed, err := time.Parse(time.RFC3339, usr.PasswordChangedAt); err == nil && origIat < unixTime(changed) {
+		return &UserStateError{Code: TokenRevoked, User: usr.Name, Reason: "changed password after token was issued"}
+	}
+	return nil
+}
+
+// unixTime returns seconds since epoch with the fraction of second
+func unixTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
 func (c *Cerber) signToken(z Zone, token *jwt.Token) (*string, error) {
 	// Create token
 	cert, err := z.Certificate()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to get certificate for the zone '%s': %s", z.Name(), err)
+	} else if err := c.validCertificate(z, cert); err != nil {
+		return nil, err
+	}
+
+	// Copy certificates will be used to validate signature, kid identifies key among rotated ones and
+	// is the only reference for bare keys
+	if size := len(cert.Certificate); size > 0 {
+		array := make([]string, size, size)
+		for i, cert := range cert.Certificate {
+			array[i] = base64.StdEncoding.EncodeToString(cert)
+		}
+		token.Header["x5c"] = array
+	}
+
+	pub, err := PublicKey(cert)
+	if err != nil {
+		return nil, err
 	}
 
-	// Copy certificates will be used to validate signature
-	// TODO: support other signing methods
-	size := len(cert.Certificate)
-	array := make([]string, size, size)
-	for i, cert := range cert.Certificate {
-		array[i] = base64.StdEncoding.EncodeToString(cert)
+	kid, err := KeyID(pub)
+	if err != nil {
+		return nil, err
 	}
-	token.Header["x5c"] = array
+	token.Header["kid"] = kid
 
 	//Sign token
-	tokenString, err := token.SignedString(cert.PrivateKey)
+	tokenString, err := signWith(z.Name(), token, cert.PrivateKey)
 	if err != nil {
 		return nil, err
 	}
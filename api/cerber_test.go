@@ -0,0 +1,215 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"expvar"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubZone is a zone with name only
+type stubZone struct {
+	name string
+	cert *tls.Certificate
+}
+
+func (z *stubZone) Name() string              { return z.name }
+func (z *stubZone) Description() string       { return "" }
+func (z *stubZone) Timeout() time.Duration    { return time.Minute }
+func (z *stubZone) MaxRefresh() time.Duration { return 0 }
+func (z *stubZone) SigningMethod() string     { return "RS256" }
+func (z *stubZone) Certificate() (*tls.Certificate, error) {
+	if z.cert == nil {
+		return nil, errors.New("No certificate")
+	}
+	return z.cert, nil
+}
+func (z *stubZone) HashPassword(passwd string) (string, error) { return passwd, nil }
+func (z *stubZone) FindUser(userID string) (*User, error) {
+	return nil, NewNotFoundError("user", userID)
+}
+func (z *stubZone) FindGroup(groupID string) (*Group, error) {
+	return nil, NewNotFoundError("group", groupID)
+}
+
+// staticProvider serves fixed set of zones or fails every lookup
+type staticProvider struct {
+	host  string
+	zones []string
+	err   error
+}
+
+func (p *staticProvider) URL() *url.URL           { return &url.URL{Scheme: "test", Host: p.host} }
+func (p *staticProvider) Zones() []string         { return p.zones }
+func (p *staticProvider) IsOnline() (bool, error) { return true, nil }
+func (p *staticProvider) Start() error            { return nil }
+func (p *staticProvider) Stop() error             { return nil }
+
+func (p *staticProvider) FindZone(name string) (Zone, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	for _, z := range p.zones {
+		if strings.ToUpper(z) == strings.ToUpper(name) {
+			return &stubZone{name: p.host + "/" + z}, nil
+		}
+	}
+	return nil, NewNotFoundError("zone", name)
+}
+
+// TestFindZonePriority checks providers are queried in priority order and errors are classified
+func TestFindZonePriority(t *testing.T) {
+	c, _ := New("test")
+	defer c.Stop()
+
+	c.AddProvider(&staticProvider{host: "low", zones: []string{"registry", "ci"}})
+	c.AddProviderWithPriority(&staticProvider{host: "high", zones: []string{"registry"}}, 10)
+
+	if z, err := c.FindZone("registry"); err != nil || z.Name() != "high/registry" {
+		t.Fatalf("Expected zone of the high priority provider, found: %v %v", z, err)
+	}
+	if z, err := c.FindZone("ci"); err != nil || z.Name() != "low/ci" {
+		t.Fatalf("Expected zone of the low priority provider, found: %v %v", z, err)
+	}
+	if _, err := c.FindZone("unknown"); !IsNotFound(err) {
+		t.Fatalf("Expected not found error, found: %v", err)
+	}
+
+	conflicts := c.Conflicts()
+	if len(conflicts) != 1 || len(conflicts["registry"]) != 2 {
+		t.Fatalf("Expected registry zone conflict, found: %v", conflicts)
+	}
+
+	c.Strict = true
+	if _, err := c.FindZone("registry"); err == nil {
+		t.Fatal("Expected conflict in strict mode")
+	} else if _, ok := err.(*ConflictError); !ok {
+		t.Fatalf("Expected conflict error, found: %s", err)
+	}
+
+	// Failed provider with higher priority could shadow zone
+	c.AddProviderWithPriority(&staticProvider{host: "broken", err: errors.New("Connection refused")}, 20)
+	if _, err := c.FindZone("ci"); !IsUnavailable(err) {
+		t.Fatalf("Expected unavailable error, found: %v", err)
+	}
+}
+
+// zoneProvider serves single zone
+type zoneProvider struct {
+	zone Zone
+}
+
+func (p *zoneProvider) URL() *url.URL           { return &url.URL{Scheme: "test", Host: "zone"} }
+func (p *zoneProvider) Zones() []string         { return []string{p.zone.Name()} }
+func (p *zoneProvider) IsOnline() (bool, error) { return true, nil }
+func (p *zoneProvider) Start() error            { return nil }
+func (p *zoneProvider) Stop() error             { return nil }
+
+func (p *zoneProvider) FindZone(name string) (Zone, error) {
+	if strings.ToUpper(name) != strings.ToUpper(p.zone.Name()) {
+		return nil, NewNotFoundError("zone", name)
+	}
+	return p.zone, nil
+}
+
+// TestBareKeyToken checks token signed with bare key has kid instead of certificate chain
+func TestBareKeyToken(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	c, _ := New("test")
+	defer c.Stop()
+	c.AddProvider(&zoneProvider{zone: &stubZone{name: "registry", cert: &tls.Certificate{PrivateKey: key}}})
+
+	signed, err := c.GenerateToken("registry", "admin", "", "", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %s", err)
+	}
+
+	token, err := c.ParseToken(*signed)
+	if err != nil {
+		t.Fatalf("Failed to parse token: %s", err)
+	}
+
+	kid, _ := KeyID(key.Public())
+	if token.Header["kid"] != kid || token.Header["x5c"] != nil {
+		t.Fatalf("Expected kid header only: %v", token.Header)
+	}
+	if len(kid) != 59 || strings.Count(kid, ":") != 11 {
+		t.Fatalf("Unexpected key id format: %s", kid)
+	}
+}
+
+// opaqueSigner hides private key type, so it could be used through crypto.Signer only
+type opaqueSigner struct {
+	crypto.Signer
+}
+
+// TestSignerKey checks token is signed with crypto.Signer and signing is counted in metrics
+func TestSignerKey(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	c, _ := New("test")
+	defer c.Stop()
+	c.AddProvider(&zoneProvider{zone: &stubZone{name: "signer", cert: &tls.Certificate{PrivateKey: opaqueSigner{key}}}})
+
+	// Metrics are global, so only changes made by the test are checked
+	counter := func(name string) int {
+		if stats, ok := signingStats.Get("signer").(*expvar.Map); ok && stats.Get(name) != nil {
+			v, _ := strconv.Atoi(stats.Get(name).String())
+			return v
+		}
+		return 0
+	}
+	count, failures := counter("count"), counter("errors")
+
+	signed, err := c.GenerateToken("signer", "admin", "", "", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %s", err)
+	}
+	if _, err := c.ParseToken(*signed); err != nil {
+		t.Fatalf("Failed to parse token: %s", err)
+	}
+
+	if counter("count") != count+1 || counter("errors") != failures {
+		t.Fatalf("Unexpected signing metrics: %s", signingStats.Get("signer"))
+	}
+}
+
+// TestExpiredCertificate checks tokens are not signed with expired certificate and expiry is reported
+func TestExpiredCertificate(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "expired"},
+		NotBefore:    time.Now().Add(-2 * time.Hour),
+		NotAfter:     time.Now().Add(-time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := New("test")
+	defer c.Stop()
+	c.AddProvider(&zoneProvider{zone: &stubZone{name: "expired", cert: &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}}})
+
+	if _, err := c.GenerateToken("expired", "admin", "", "", map[string]interface{}{}); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("Expected expired certificate error, found: %v", err)
+	}
+
+	certs := c.Certificates()
+	if len(certs) != 1 || !certs[0].Expired || certs[0].State != KeyActive || certs[0].Subject != "expired" {
+		t.Fatalf("Unexpected certificates status: %+v", certs)
+	}
+	if certificateStats.Get("expired").String() != strconv.FormatInt(tmpl.NotAfter.Unix(), 10) {
+		t.Fatalf("Unexpected certificate metrics: %s", certificateStats)
+	}
+}
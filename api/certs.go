@@ -0,0 +1,131 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// CertificateStatus describes validity of the zone signing certificate
+type CertificateStatus struct {
+	Zone     string    `json:"zone"`
+	KeyID    string    `json:"kid"`
+	State    string    `json:"state"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+	Expired  bool      `json:"expired"`
+}
+
+// Certificates returns validity of certificates of all zones served by registered providers. Bare keys
+// have no certificates and are not reported
+func (c *Cerber) Certificates() []CertificateStatus {
+	result := make([]CertificateStatus, 0)
+	for _, p := range c.Providers() {
+		result = append(result, c.providerCertificates(p)...)
+	}
+	return result
+}
+
+// providerCertificates returns validity of the certificates of zones served by the provider. Expiry of
+// active certificates is exported to metrics and warned about when approaches
+func (c *Cerber) providerCertificates(p Provider) []CertificateStatus {
+	result := make([]CertificateStatus, 0)
+	now := time.Now()
+	for _, name := range p.Zones() {
+		z, err := p.FindZone(name)
+		if err != nil {
+			continue
+		}
+
+		keys, err := ZoneKeys(z)
+		if err != nil {
+			log.WithField("zone", name).Warnf("Failed to get zone signing keys: %s", err)
+			continue
+		}
+
+		for _, k := range keys {
+			if len(k.Chain) == 0 {
+				continue
+			}
+
+			leaf, err := x509.ParseCertificate(k.Chain[0])
+			if err != nil {
+				continue
+			}
+
+			status := CertificateStatus{
+				Zone:     z.Name(),
+				KeyID:    k.ID,
+				State:    k.State,
+				Subject:  leaf.Subject.CommonName,
+				NotAfter: leaf.NotAfter,
+				Expired:  now.After(leaf.NotAfter),
+			}
+			if k.State == KeyActive {
+				observeCertificate(status.Zone, leaf.NotAfter)
+				c.warnExpiry(status.Zone, leaf, now)
+			}
+			result = append(result, status)
+		}
+	}
+	return result
+}
+
+// checkCertificates logs certificates of the provider zones which are about to expire
+func (c *Cerber) checkCertificates(p Provider) {
+	c.providerCertificates(p)
+}
+
+// validCertificate refuses certificate which is expired or not valid yet, so no token is signed with it
+func (c *Cerber) validCertificate(z Zone, cert *tls.Certificate) error {
+	if len(cert.Certificate) == 0 {
+		return nil
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("Failed to parse certificate of the zone '%s': %s", z.Name(), err)
+		}
+		leaf = parsed
+	}
+
+	now := time.Now()
+	if now.After(leaf.NotAfter) {
+		return fmt.Errorf("Certificate of the zone '%s' expired at %s", z.Name(), leaf.NotAfter.Format(time.RFC3339))
+	} else if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("Certificate of the zone '%s' is not valid before %s", z.Name(), leaf.NotBefore.Format(time.RFC3339))
+	}
+
+	observeCertificate(z.Name(), leaf.NotAfter)
+	c.warnExpiry(z.Name(), leaf, now)
+	return nil
+}
+
+// warnExpiry logs warning if certificate expires within ExpiryWarning, once a day for every certificate
+func (c *Cerber) warnExpiry(zone string, leaf *x509.Certificate, now time.Time) {
+	left := leaf.NotAfter.Sub(now)
+	if left > c.ExpiryWarning {
+		return
+	}
+
+	key := zone + "/" + leaf.SerialNumber.String()
+	c.expiryLock.Lock()
+	last, ok := c.expiryWarned[key]
+	if ok && now.Sub(last) < 24*time.Hour {
+		c.expiryLock.Unlock()
+		return
+	}
+	c.expiryWarned[key] = now
+	c.expiryLock.Unlock()
+
+	log.WithFields(log.Fields{
+		"zone":      zone,
+		"subject":   leaf.Subject.CommonName,
+		"not_after": leaf.NotAfter.Format(time.RFC3339),
+	}).Warnf("Zone signing certificate expires in %s", left.Truncate(time.Minute))
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AccessContext describes token request group conditions and zone policies are checked against
+type AccessContext struct {
+	// Address of the client requested token, nil if unknown
+	ClientIP net.IP
+
+	// Moment token is issued at
+	Time time.Time
+
+	// Request headers and requested scopes, policies of the zone see them
+	Header http.Header
+	Scopes []Scope
+}
+
+// Conditions restrict when actions of the group are granted, all set conditions must hold
+type Conditions struct {
+	// Client address must belong to one of the ranges, for example 10.1.0.0/16
+	CIDR []string `yaml:"cidr,omitempty" json:"cidr,omitempty"`
+
+	// Time window within a day, for example 08:00-20:00. Window could wrap midnight
+	Hours string `yaml:"hours,omitempty" json:"hours,omitempty"`
+
+	// Days of week actions are granted at: mon, tue, wed, thu, fri, sat or sun
+	Weekdays []string `yaml:"weekdays,omitempty" json:"weekdays,omitempty"`
+
+	// Location hours and weekdays are checked in, such as Europe/Berlin. UTC is used if not set
+	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+
+	// Actions are not granted after the moment, RFC3339 time or date
+	Until string `yaml:"until,omitempty" json:"until,omitempty"`
+
+	// Conditions parsed by Validate, so timezone isn't loaded on every check
+	parsed *parsedConditions
+}
+
+// parsedConditions are conditions ready to be checked
+type parsedConditions struct {
+	networks []*net.IPNet
+	from, to int
+	loc      *time.Location
+	until    time.Time
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Validate checks all conditions are well formed, parsed conditions are kept for checks. Zones are
+// expected to validate conditions once they are loaded
+func (c *Conditions) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	p, err := c.parse()
+	if err != nil {
+		return err
+	}
+	c.parsed = p
+	return nil
+}
+
+// parse parses all conditions
+func (c *Conditions) parse() (*parsedConditions, error) {
+	p := &parsedConditions{loc: time.UTC}
+	for _, r := range c.CIDR {
+		_, n, err := net.ParseCIDR(r)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid CIDR range: %s", r)
+		}
+		p.networks = append(p.networks, n)
+	}
+
+	var err error
+	if c.Hours != "" {
+		if p.from, p.to, err = parseHours(c.Hours); err != nil {
+			return nil, err
+		}
+	}
+	for _, d := range c.Weekdays {
+		if _, ok := weekdays[strings.ToLower(d)]; !ok {
+			return nil, fmt.Errorf("Invalid weekday: %s", d)
+		}
+	}
+	if c.Timezone != "" {
+		if p.loc, err = time.LoadLocation(c.Timezone); err != nil {
+			return nil, fmt.Errorf("Invalid timezone: %s", c.Timezone)
+		}
+	}
+	if c.Until != "" {
+		if p.until, err = parseUntil(c.Until); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Check tests conditions in the given context, reason explains the first failed condition.
+// Conditions which weren't validated are parsed on every call
+func (c *Conditions) Check(ctx AccessContext) (ok bool, reason string) {
+	if c == nil {
+		return true, ""
+	}
+
+	p := c.parsed
+	if p == nil {
+		var err error
+		if p, err = c.parse(); err != nil {
+			return false, err.Error()
+		}
+	}
+
+	if len(p.networks) > 0 {
+		if ctx.ClientIP == nil {
+			return false, "client address is unknown"
+		} else if !p.inRange(ctx.ClientIP) {
+			return false, fmt.Sprintf("client %s is outside of %s", ctx.ClientIP, strings.Join(c.CIDR, ", "))
+		}
+	}
+
+	if c.Until != "" && !ctx.Time.Before(p.until) {
+		return false, fmt.Sprintf("grant expired at %s", p.until.Format(time.RFC3339))
+	}
+
+	local := ctx.Time.In(p.loc)
+	if len(c.Weekdays) > 0 && !c.onWeekday(local.Weekday()) {
+		return false, fmt.Sprintf("%s is not one of %s", local.Weekday(), strings.Join(c.Weekdays, ", "))
+	}
+
+	if c.Hours != "" {
+		now := local.Hour()*60 + local.Minute()
+		inside := now >= p.from && now < p.to
+		if p.from > p.to {
+			inside = now >= p.from || now < p.to
+		}
+		if !inside {
+			return false, fmt.Sprintf("%s is outside of %s %s", local.Format("15:04"), c.Hours, p.loc)
+		}
+	}
+	return true, ""
+}
+
+// String describes conditions in a short form
+func (c *Conditions) String() string {
+	parts := make([]string, 0, 4)
+	if len(c.CIDR) > 0 {
+		parts = append(parts, "from "+strings.Join(c.CIDR, ", "))
+	}
+	if len(c.Weekdays) > 0 {
+		parts = append(parts, "on "+strings.Join(c.Weekdays, ", "))
+	}
+	if c.Hours != "" {
+		parts = append(parts, "at "+c.Hours)
+	}
+	if c.Until != "" {
+		parts = append(parts, "until "+c.Until)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (p *parsedConditions) inRange(ip net.IP) bool {
+	for _, n := range p.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Conditions) onWeekday(day time.Weekday) bool {
+	for _, d := range c.Weekdays {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHours parses HH:MM-HH:MM window into minutes of the day
+func parseHours(s string) (from, to int, err error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid hours window: %s", s)
+	}
+
+	minutes := make([]int, 2)
+	for i, p := range parts {
+		t, err := time.Parse("15:04", strings.TrimSpace(p))
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid hours window: %s", s)
+		}
+		minutes[i] = t.Hour()*60 + t.Minute()
+	}
+
+	if minutes[0] == minutes[1] {
+		return 0, 0, fmt.Errorf("Empty hours window: %s", s)
+	}
+	return minutes[0], minutes[1], nil
+}
+
+// parseUntil accepts RFC3339 time or date, date means the beginning of the day in UTC
+func parseUntil(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	} else if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid until time, RFC3339 time or date is expected: %s", s)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestConditions checks client ranges, time windows and expiry of conditional groups
+func TestConditions(t *testing.T) {
+	when := &Conditions{
+		CIDR:     []string{"10.1.0.0/16"},
+		Hours:    "20:00-06:00",
+		Weekdays: []string{"Mon", "tue"},
+		Until:    "2026-03-01",
+	}
+	if err := when.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	monday := time.Date(2026, 2, 2, 22, 30, 0, 0, time.UTC)
+	cases := []struct {
+		ip      string
+		at      time.Time
+		granted bool
+	}{
+		{"10.1.2.3", monday, true},
+		{"10.1.2.3", monday.Add(7 * time.Hour), true},
+		{"10.2.2.3", monday, false},
+		{"", monday, false},
+		{"10.1.2.3", monday.Add(-10 * time.Hour), false},
+		{"10.1.2.3", monday.Add(-48 * time.Hour), false},
+		{"10.1.2.3", monday.Add(28 * 24 * time.Hour), false},
+	}
+	for _, c := range cases {
+		ok, reason := when.Check(AccessContext{ClientIP: net.ParseIP(c.ip), Time: c.at})
+		if ok != c.granted {
+			t.Fatalf("Expected %s at %s granted to be %v: %s", c.ip, c.at, c.granted, reason)
+		} else if !ok && reason == "" {
+			t.Fatal("Failed condition must be explained")
+		}
+	}
+
+	// Timezone is loaded once by Validate
+	berlin := &Conditions{Hours: "08:00-20:00", Timezone: "Europe/Berlin"}
+	if err := berlin.Validate(); err != nil {
+		t.Fatal(err)
+	} else if berlin.parsed == nil || berlin.parsed.loc.String() != "Europe/Berlin" {
+		t.Fatalf("Expected validated conditions to keep location: %+v", berlin.parsed)
+	}
+	if ok, reason := berlin.Check(AccessContext{Time: time.Date(2026, 2, 2, 19, 30, 0, 0, time.UTC)}); ok {
+		t.Fatalf("Expected hours to be checked in the zone timezone: %s", reason)
+	}
+
+	for _, invalid := range []Conditions{{Timezone: "Mars/Olympus"}, {CIDR: []string{"10.1.0.0"}}, {Hours: "8-20"}, {Weekdays: []string{"monday"}}, {Until: "tomorrow"}} {
+		if err := invalid.Validate(); err == nil {
+			t.Fatalf("Expected conditions to be invalid: %+v", invalid)
+		}
+	}
+}
+
+// TestConditionalGroups checks actions of failed group are not granted and are traced by explain
+func TestConditionalGroups(t *testing.T) {
+	z := &memberZone{
+		stubZone: stubZone{name: "registry"},
+		users:    map[string]*User{"ci": {Name: "ci", Groups: []string{"read", "push"}}},
+		groups: map[string]*Group{
+			"read": {Name: "read", Actions: []string{"*:pull"}},
+			"push": {Name: "push", Actions: []string{"*:push"}, When: &Conditions{CIDR: []string{"10.1.0.0/16"}}},
+		},
+	}
+
+	cerber, _ := New("test")
+	out := &bytes.Buffer{}
+	cerber.AuditLog = out
+
+	// Only groups denied by conditions are audited
+	if _, err := cerber.Authorize(z, "ci", "", AccessContext{ClientIP: net.ParseIP("10.1.0.5")}); err != nil || out.Len() != 0 {
+		t.Fatalf("Granted conditional group must not be audited: %q %v", out.String(), err)
+	}
+	if _, err := cerber.Authorize(z, "ci", "", AccessContext{ClientIP: net.ParseIP("192.168.1.1")}); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(out.String(), `"result":"denied"`) || !strings.Contains(out.String(), "push denied") {
+		t.Fatalf("Denied conditional group must be audited: %q", out.String())
+	}
+
+	actions, err := cerber.UserActions(z, "ci", AccessContext{ClientIP: net.ParseIP("192.168.1.1")})
+	if err != nil || len(actions) != 1 || actions[0] != "*:pull" {
+		t.Fatalf("Push must not be granted outside of CI network: %v %v", actions, err)
+	}
+
+	scopes := []Scope{{Type: "repository", Name: "app", Actions: []string{"push"}}}
+	e, err := cerber.Explain(z, "ci", scopes, AccessContext{ClientIP: net.ParseIP("192.168.1.1")})
+	if err != nil {
+		t.Fatal(err)
+	} else if e.Groups[1].Granted || e.Groups[1].Reason == "" {
+		t.Fatalf("Failed condition is not explained: %+v", e.Groups[1])
+	} else if p := e.Checks[0].Patterns[1]; !p.Matched || p.Granted || e.Checks[0].Allowed {
+		t.Fatalf("Matched pattern of failed group must not be granted: %+v", e.Checks[0])
+	}
+
+	e, _ = cerber.Explain(z, "ci", scopes, AccessContext{ClientIP: net.ParseIP("10.1.0.5")})
+	if !e.Checks[0].Allowed {
+		t.Fatalf("Push must be granted from CI network: %+v", e.Checks[0])
+	}
+}
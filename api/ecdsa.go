@@ -0,0 +1,104 @@
+package api
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	_ "crypto/sha256" // Register hashes used by ES methods
+	_ "crypto/sha512"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// signingMethodECDSA implements ES256, ES384 and ES512 methods, jwt library has no ECDSA support.
+// Signature is a concatenation of R and S values padded to the curve size
+type signingMethodECDSA struct {
+	name    string
+	hash    crypto.Hash
+	keySize int
+}
+
+// ecdsaSignature is ASN.1 signature crypto.Signer returns for ECDSA keys
+type ecdsaSignature struct {
+	R, S *big.Int
+}
+
+func init() {
+	for _, m := range []*signingMethodECDSA{
+		{"ES256", crypto.SHA256, 32},
+		{"ES384", crypto.SHA384, 48},
+		{"ES512", crypto.SHA512, 66},
+	} {
+		method := m
+		RegisterSigningMethod(method.Alg(), func() jwt.SigningMethod { return method })
+	}
+}
+
+// Alg returns method name
+func (m *signingMethodECDSA) Alg() string {
+	return m.name
+}
+
+// Verify checks signature with *ecdsa.PublicKey
+func (m *signingMethodECDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKey
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	} else if len(sig) != 2*m.keySize {
+		return errors.New("Invalid ECDSA signature size")
+	}
+
+	r := new(big.Int).SetBytes(sig[:m.keySize])
+	s := new(big.Int).SetBytes(sig[m.keySize:])
+	if !ecdsa.Verify(pub, m.digest(signingString), r, s) {
+		return errors.New("ECDSA signature verification failed")
+	}
+	return nil
+}
+
+// Sign signs with *ecdsa.PrivateKey
+func (m *signingMethodECDSA) Sign(signingString string, key interface{}) (string, error) {
+	k, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKey
+	}
+	return m.SignWith(signingString, k)
+}
+
+// SignWith signs with any crypto.Signer backed by ECDSA key of the method curve
+func (m *signingMethodECDSA) SignWith(signingString string, signer crypto.Signer) (string, error) {
+	pub, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok || (pub.Curve.Params().BitSize+7)/8 != m.keySize {
+		return "", jwt.ErrInvalidKey
+	}
+
+	der, err := signer.Sign(rand.Reader, m.digest(signingString), m.hash)
+	if err != nil {
+		return "", err
+	}
+
+	var parsed ecdsaSignature
+	if _, err := asn1.Unmarshal(der, &parsed); err != nil {
+		return "", errors.New("Signer returned malformed ECDSA signature")
+	}
+
+	sig := make([]byte, 2*m.keySize)
+	r, s := parsed.R.Bytes(), parsed.S.Bytes()
+	copy(sig[m.keySize-len(r):m.keySize], r)
+	copy(sig[2*m.keySize-len(s):], s)
+	return jwt.EncodeSegment(sig), nil
+}
+
+func (m *signingMethodECDSA) digest(signingString string) []byte {
+	hasher := m.hash.New()
+	hasher.Write([]byte(signingString))
+	return hasher.Sum(nil)
+}
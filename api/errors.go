@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NotFoundError returns by providers and zones when requested zone, user or group doesn't exist. It
+// allows to tell missing entity apart from the failed backend
+type NotFoundError struct {
+	// Kind of the entity: zone, user or group
+	Kind string
+	Name string
+}
+
+// NewNotFoundError creates error for the missing entity of the given kind
+func NewNotFoundError(kind, name string) error {
+	return &NotFoundError{Kind: kind, Name: name}
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("Unknown %s: %s", e.Kind, e.Name)
+}
+
+// IsNotFound checks if error reports missing entity
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// UnavailableError returns when backend failed to answer, so it is unknown if requested
+// entity exists or not
+type UnavailableError struct {
+	// Source is a backend failed to answer, for example provider URL
+	Source string
+	Err    error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s is unavailable: %s", e.Source, e.Err)
+}
+
+// IsUnavailable checks if error reports failed backend
+func IsUnavailable(err error) bool {
+	_, ok := err.(*UnavailableError)
+	return ok
+}
+
+// ConflictError returns in strict mode when zone is served by more than one provider
+type ConflictError struct {
+	Zone      string
+	Providers []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("Zone %s is served by several providers: %v", e.Zone, e.Providers)
+}
+
+// ExistsError returns by writable zones when created user or group already exists
+type ExistsError struct {
+	// Kind of the entity: user or group
+	Kind string
+	Name string
+}
+
+// NewExistsError creates error for the duplicated entity of the given kind
+func NewExistsError(kind, name string) error {
+	return &ExistsError{Kind: kind, Name: name}
+}
+
+func (e *ExistsError) Error() string {
+	return fmt.Sprintf("%s already exists: %s", strings.Title(e.Kind), e.Name)
+}
+
+// IsExists checks if error reports duplicated entity
+func IsExists(err error) bool {
+	_, ok := err.(*ExistsError)
+	return ok
+}
+
+// ReadOnlyError returns when change couldn't be stored by the zone, for example entity is defined
+// in the file zone source doesn't allow to modify
+type ReadOnlyError struct {
+	Zone   string
+	Reason string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("Zone %s couldn't be modified: %s", e.Zone, e.Reason)
+}
+
+// IsReadOnly checks if error reports unmodifiable zone or entity
+func IsReadOnly(err error) bool {
+	_, ok := err.(*ReadOnlyError)
+	return ok
+}
+
+// User state codes reported by UserStateError
+const (
+	UserDisabled           = "user_disabled"
+	UserExpired            = "user_expired"
+	PasswordExpired        = "password_expired"
+	PasswordChangeRequired = "password_change_required"
+	TokenRevoked           = "token_revoked"
+)
+
+// UserStateError returns when user state doesn't allow to login or use issued tokens, Code tells
+// the reason to clients
+type UserStateError struct {
+	// Code is one of UserDisabled, UserExpired, PasswordExpired, PasswordChangeRequired or TokenRevoked
+	Code   string
+	User   string
+	Reason string
+}
+
+func (e *UserStateError) Error() string {
+	return fmt.Sprintf("User %s %s", e.User, e.Reason)
+}
+
+// IsUserState checks if error reports user state which doesn't allow access
+func IsUserState(err error) bool {
+	_, ok := err.(*UserStateError)
+	return ok
+}
@@ -0,0 +1,95 @@
+package api
+
+// GroupGrant is a group of the user along with its actions. Actions of the group are granted only if
+// its conditions hold and membership is not expired, Reason explains why group is not granted otherwise
+type GroupGrant struct {
+	Name    string      `json:"name"`
+	Actions []string    `json:"actions"`
+	When    *Conditions `json:"when,omitempty"`
+	Until   string      `json:"until,omitempty"`
+	Granted bool        `json:"granted"`
+	Reason  string      `json:"reason,omitempty"`
+}
+
+// PatternTrace shows whether action of the group matched the requested one, action of the group which
+// conditions failed is not granted even if it matches
+type PatternTrace struct {
+	Group   string `json:"group"`
+	Pattern string `json:"pattern"`
+	Matched bool   `json:"matched"`
+	Granted bool   `json:"granted"`
+}
+
+// ActionTrace is a decision on the requested action along with all patterns checked
+type ActionTrace struct {
+	Decision
+	Patterns []PatternTrace `json:"patterns"`
+	Policies []PolicyTrace  `json:"policies,omitempty"`
+}
+
+// Explanation traces how user access in the zone is resolved: user groups, actions they grant and
+// how requested scopes are matched. Error is set if login of the user would fail
+type Explanation struct {
+	Zone    string        `json:"zone"`
+	User    string        `json:"user"`
+	Groups  []GroupGrant  `json:"groups"`
+	Checks  []ActionTrace `json:"checks"`
+	Granted []string      `json:"-"`
+	Access  []Scope       `json:"-"`
+	Error   string        `json:"error,omitempty"`
+
+	// State code of the user which is not allowed to login, see UserStateError
+	State string `json:"state,omitempty"`
+}
+
+// Explain resolves user access the same way as login does in the given context, but traces every
+// step including zone policies. Credentials are not checked. Requested scopes are intersected with
+// granted actions into Access, all actions are granted if no scopes are requested
+func (c *Cerber) Explain(z Zone, user string, scopes []Scope, ctx AccessContext) (*Explanation, error) {
+	usr, err := z.FindUser(user)
+	if err != nil {
+		return nil, err
+	}
+	return c.ExplainUser(z, usr, scopes, ctx)
+}
+
+// ExplainUser explains access of the given user, user could be missing in the zone, for example to
+// test policies with made up users
+func (c *Cerber) ExplainUser(z Zone, usr *User, scopes []Scope, ctx AccessContext) (*Explanation, error) {
+	var err error
+	e := &Explanation{Zone: z.Name(), User: usr.Name, Checks: make([]ActionTrace, 0)}
+	e.Groups, err = userGroups(z, usr, ctx)
+	if IsUnavailable(err) {
+		return nil, err
+	} else if err != nil {
+		e.Error = err.Error()
+		return e, nil
+	} else if err := usr.CheckState(ctx.Time); err != nil {
+		e.Error, e.State = err.Error(), err.(*UserStateError).Code
+		return e, nil
+	}
+
+	ctx.Scopes = scopes
+	if e.Granted, err = applyPolicies(z, usr, e.Groups, ctx); err != nil {
+		e.Error = err.Error()
+	}
+
+	policies := ZonePolicies(z)
+	for _, s := range scopes {
+		for _, a := range s.Actions {
+			trace := ActionTrace{Patterns: make([]PatternTrace, 0)}
+			trace.Decision, trace.Policies = decide(policies, usr, e.Groups, ctx, s.Type, s.Name, a)
+			if s.Type == "repository" {
+				for _, g := range e.Groups {
+					for _, p := range g.Actions {
+						trace.Patterns = append(trace.Patterns, PatternTrace{Group: g.Name, Pattern: p, Matched: ActionMatches(p, s.Name+":"+a), Granted: g.Granted})
+					}
+				}
+			}
+			e.Checks = append(e.Checks, trace)
+		}
+	}
+
+	e.Access = Intersect(e.Granted, scopes)
+	return e, nil
+}
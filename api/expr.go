@@ -0,0 +1,555 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+)
+
+// Expression is a compiled policy rule. Rules are boolean expressions over variables given at
+// evaluation:
+//
+//	scope.action == "push" && startsWith(scope.name, user.name + "/")
+//	"admins" in groups || inNetwork(request.ip, "10.1.0.0/16")
+//
+// Supported are string and boolean literals, lists, field access with '.', map index with [],
+// operators ==, !=, in, +, &&, || and !, and functions startsWith, endsWith, contains, matches,
+// lower and inNetwork
+type Expression struct {
+	source string
+	root   node
+}
+
+type node interface {
+	eval(env map[string]interface{}) (interface{}, error)
+}
+
+// functions are callable from expressions, arguments are checked by the function itself
+var functions = map[string]func(args []interface{}) (interface{}, error){
+	"startsWith": stringsFunc(strings.HasPrefix),
+	"endsWith":   stringsFunc(strings.HasSuffix),
+	"contains":   stringsFunc(strings.Contains),
+	"matches":    stringsFunc(func(s, pattern string) bool { return wildcard(pattern, s) }),
+	"inNetwork": stringsFunc(func(ip, cidr string) bool {
+		_, n, err := net.ParseCIDR(cidr)
+		addr := net.ParseIP(ip)
+		return err == nil && addr != nil && n.Contains(addr)
+	}),
+	"lower": func(args []interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("lower expects 1 argument, found %d", len(args))
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("lower expects string, found %s", typeName(args[0]))
+		}
+		return strings.ToLower(s), nil
+	},
+}
+
+// CompileExpression parses policy rule
+func CompileExpression(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	} else if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("Unexpected '%s' at %d", t.text, t.pos)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// Eval evaluates expression with the given variables, result must be boolean
+func (e *Expression) Eval(env map[string]interface{}) (bool, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+
+	result, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("Rule result must be boolean, found %s", typeName(v))
+	}
+	return result, nil
+}
+
+// String returns expression source
+func (e *Expression) String() string {
+	return e.source
+}
+
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind int
+	text string
+	pos  int
+}
+
+func tokenize(s string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case isIdent(c, true):
+			j := i
+			for j < len(s) && isIdent(s[j], false) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, s[i:j], i})
+			i = j
+		case c == '"' || c == '\'':
+			value := make([]byte, 0)
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				value = append(value, s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("Unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{tokString, string(value), i})
+			i = j + 1
+		case i+1 < len(s) && (s[i:i+2] == "==" || s[i:i+2] == "!=" || s[i:i+2] == "&&" || s[i:i+2] == "||"):
+			tokens = append(tokens, token{tokOp, s[i : i+2], i})
+			i += 2
+		case strings.IndexByte("!+()[],.", c) >= 0:
+			tokens = append(tokens, token{tokOp, string(c), i})
+			i++
+		default:
+			return nil, fmt.Errorf("Unexpected character '%c' at %d", c, i)
+		}
+	}
+	return append(tokens, token{tokEOF, "end of rule", len(s)}), nil
+}
+
+func isIdent(c byte, first bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+// parser is a recursive descent parser, from the lowest precedence: ||, &&, !, comparison, +, postfix
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+// accept consumes the next token if it is the given operator or keyword
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); (t.kind == tokOp || t.kind == tokIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return fmt.Errorf("Expected '%s' at %d, found '%s'", text, t.pos, t.text)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseBinary(p.parseNot, "&&")
+}
+
+func (p *parser) parseBinary(operand func() (node, error), ops ...string) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := ""
+		for _, o := range ops {
+			if p.accept(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range []string{"==", "!=", "in"} {
+		if p.accept(op) {
+			right, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			return &binaryNode{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parseSum() (node, error) {
+	return p.parseBinary(p.parsePostfix, "+")
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.accept("."):
+			t := p.peek()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("Expected field name at %d, found '%s'", t.pos, t.text)
+			}
+			p.pos++
+			n = &fieldNode{n, t.text}
+		case p.accept("["):
+			key, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			} else if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{n, key}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokString:
+		p.pos++
+		return &literalNode{t.text}, nil
+	case t.kind == tokIdent && (t.text == "true" || t.text == "false"):
+		p.pos++
+		return &literalNode{t.text == "true"}, nil
+	case t.kind == tokIdent && t.text != "in":
+		p.pos++
+		if !p.accept("(") {
+			return &variableNode{t.text}, nil
+		}
+
+		fn, ok := functions[t.text]
+		if !ok {
+			return nil, fmt.Errorf("Unknown function '%s' at %d", t.text, t.pos)
+		}
+		args, err := p.parseList(")")
+		if err != nil {
+			return nil, err
+		}
+		return &callNode{t.text, fn, args}, nil
+	case p.accept("("):
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case p.accept("["):
+		items, err := p.parseList("]")
+		if err != nil {
+			return nil, err
+		}
+		return &listNode{items}, nil
+	}
+	return nil, fmt.Errorf("Unexpected '%s' at %d", t.text, t.pos)
+}
+
+// parseList parses comma separated expressions up to the closing token
+func (p *parser) parseList(closing string) ([]node, error) {
+	items := make([]node, 0)
+	if p.accept(closing) {
+		return items, nil
+	}
+
+	for {
+		item, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		if p.accept(closing) {
+			return items, nil
+		} else if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(env map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type variableNode struct {
+	name string
+}
+
+func (n *variableNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, ok := env[n.name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown variable '%s'", n.name)
+	}
+	return v, nil
+}
+
+type fieldNode struct {
+	object node
+	name   string
+}
+
+func (n *fieldNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.object.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Field '%s' of %s is requested", n.name, typeName(v))
+	}
+	field, ok := m[n.name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown field '%s'", n.name)
+	}
+	return field, nil
+}
+
+// indexNode looks up map key, missing key is an empty string
+type indexNode struct {
+	object node
+	key    node
+}
+
+func (n *indexNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.object.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	k, err := n.key.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := v.(map[string]interface{})
+	key, isString := k.(string)
+	if !ok || !isString {
+		return nil, fmt.Errorf("%s couldn't be indexed with %s", typeName(v), typeName(k))
+	} else if value, ok := m[key]; ok {
+		return value, nil
+	}
+	return "", nil
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(env map[string]interface{}) (interface{}, error) {
+	result := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+type callNode struct {
+	name string
+	fn   func(args []interface{}) (interface{}, error)
+	args []node
+}
+
+func (n *callNode) eval(env map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	v, err := n.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", n.name, err)
+	}
+	return v, nil
+}
+
+type notNode struct {
+	operand node
+}
+
+func (n *notNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("Operator ! expects boolean, found %s", typeName(v))
+	}
+	return !b, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(env map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logical operators are short circuit
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("Operator %s expects boolean, found %s", n.op, typeName(left))
+		} else if l == (n.op == "||") {
+			return l, nil
+		}
+
+		right, err := n.right.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("Operator %s expects boolean, found %s", n.op, typeName(right))
+		}
+		return r, nil
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+	case "in":
+		switch r := right.(type) {
+		case []interface{}:
+			for _, item := range r {
+				if reflect.DeepEqual(left, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			key, ok := left.(string)
+			_, found := r[key]
+			return ok && found, nil
+		}
+		return nil, fmt.Errorf("Operator in expects list or map, found %s", typeName(right))
+	case "+":
+		if l, ok := left.(string); ok {
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		} else if l, ok := left.([]interface{}); ok {
+			if r, ok := right.([]interface{}); ok {
+				return append(append([]interface{}{}, l...), r...), nil
+			}
+		}
+		return nil, fmt.Errorf("Operator + couldn't be applied to %s and %s", typeName(left), typeName(right))
+	}
+	return nil, fmt.Errorf("Unknown operator %s", n.op)
+}
+
+// stringsFunc makes expression function of two string arguments
+func stringsFunc(fn func(a, b string) bool) func(args []interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("2 arguments expected, found %d", len(args))
+		}
+
+		a, ok := args[0].(string)
+		b, ok2 := args[1].(string)
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("string arguments expected, found %s and %s", typeName(args[0]), typeName(args[1]))
+		}
+		return fn(a, b), nil
+	}
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
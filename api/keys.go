@@ -0,0 +1,131 @@
+package api
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// KeyID returns identifier of the public key in the form docker distribution and libtrust use:
+// first 240 bits of SHA256 of the DER encoded key, base32 encoded and split into groups of 4
+// characters
+func KeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("Failed to encode public key: %s", err)
+	}
+
+	sum := sha256.Sum256(der)
+	encoded := base32.StdEncoding.EncodeToString(sum[:30])
+
+	groups := make([]string, 0, len(encoded)/4)
+	for i := 0; i < len(encoded); i += 4 {
+		groups = append(groups, encoded[i:i+4])
+	}
+	return strings.Join(groups, ":"), nil
+}
+
+// PublicKey returns key tokens signed with the given certificate are verified with. Certificate
+// could have no chain if zone uses bare key
+func PublicKey(cert *tls.Certificate) (crypto.PublicKey, error) {
+	if len(cert.Certificate) > 0 {
+		// Parsed leaf is a cache, it must not replace the certificate tokens carry in x5c
+		if cert.Leaf != nil {
+			if !bytes.Equal(cert.Leaf.Raw, cert.Certificate[0]) {
+				return nil, errors.New("Zone certificate doesn't match its parsed leaf")
+			}
+			return cert.Leaf.PublicKey, nil
+		}
+
+		// Leaf certificate is the first in the chain
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse zone certificate: %s", err)
+		}
+		return leaf.PublicKey, nil
+	}
+
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("Zone has neither certificate nor public key")
+	}
+	return signer.Public(), nil
+}
+
+// Signing key rotation states
+const (
+	// KeyPending is published for verification ahead of rotation but doesn't sign tokens yet
+	KeyPending = "pending"
+
+	// KeyActive signs new tokens
+	KeyActive = "active"
+
+	// KeyRetired doesn't sign tokens any more, but verifies ones issued before rotation until they expire
+	KeyRetired = "retired"
+)
+
+// ZoneKey is a signing key of the zone along with its rotation state
+type ZoneKey struct {
+	ID     string
+	State  string
+	Public crypto.PublicKey
+
+	// DER encoded certificate chain, empty for bare keys
+	Chain [][]byte
+}
+
+// KeyRing is implemented by zones rotating their signing keys. Tokens signed by any key of the ring are
+// accepted, so tokens issued before rotation stay valid. Zone Certificate returns the active key
+type KeyRing interface {
+	// Keys returns all pending, active and retired keys of the zone
+	Keys() ([]ZoneKey, error)
+}
+
+// ZoneKeys returns keys tokens of the zone could be verified with. Zone without KeyRing has the only
+// active key it signs tokens with
+func ZoneKeys(z Zone) ([]ZoneKey, error) {
+	if ring, ok := Underlying(z).(KeyRing); ok {
+		return ring.Keys()
+	}
+
+	cert, err := z.Certificate()
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := PublicKey(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := KeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+	return []ZoneKey{{ID: id, State: KeyActive, Public: pub, Chain: cert.Certificate}}, nil
+}
+
+// verificationKey selects key of the zone token was signed with by the kid header. Tokens without kid
+// are verified with the active key
+func verificationKey(z Zone, token *jwt.Token) (crypto.PublicKey, error) {
+	keys, err := ZoneKeys(z)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	for _, k := range keys {
+		if (kid != "" && k.ID == kid) || (kid == "" && k.State == KeyActive) {
+			return k.Public, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown signing key of the zone '%s': %s", z.Name(), kid)
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// ParseUntil parses expiry of the membership, RFC3339 time or date is expected
+func ParseUntil(s string) (time.Time, error) {
+	return parseUntil(s)
+}
+
+// MemberUntil returns expiry of the user membership in the group, zero time means membership is
+// permanent
+func (u *User) MemberUntil(group string) (time.Time, error) {
+	value, ok := u.Until[group]
+	if !ok {
+		return time.Time{}, nil
+	}
+	return parseUntil(value)
+}
+
+// Expired checks user membership in the group is expired at the given moment, membership with
+// malformed expiry is expired
+func (u *User) Expired(group string, now time.Time) bool {
+	until, err := u.MemberUntil(group)
+	return err != nil || (!until.IsZero() && !now.Before(until))
+}
+
+// ValidateMemberships checks expiry of every temporary membership is well formed and refers to the
+// group of the user
+func (u *User) ValidateMemberships() error {
+	for group := range u.Until {
+		found := false
+		for _, g := range u.Groups {
+			found = found || g == group
+		}
+
+		if !found {
+			return fmt.Errorf("User %s has expiry of membership in %s, but is not its member", u.Name, group)
+		} else if _, err := u.MemberUntil(group); err != nil {
+			return fmt.Errorf("User %s membership in %s: %s", u.Name, group, err)
+		}
+	}
+	return nil
+}
+
+// sweepMemberships removes expired memberships from writable zones of the provider, every removal
+// is audited. Memberships which couldn't be removed are still ignored by login
+func (c *Cerber) sweepMemberships(p Provider) {
+	now := time.Now()
+	for _, name := range p.Zones() {
+		z, err := p.FindZone(name)
+		if err != nil {
+			continue
+		}
+
+		w, ok := Writable(z)
+		if !ok {
+			continue
+		}
+
+		users, err := w.Users()
+		if err != nil {
+			log.WithField("zone", name).Warnf("Failed to list users for membership expiry: %s", err)
+			continue
+		}
+
+		for _, u := range users {
+			for _, g := range u.Groups {
+				if !u.Expired(g, now) {
+					continue
+				}
+
+				if err := w.RemoveMember(u.Name, g); IsReadOnly(err) {
+					log.WithField("zone", name).Debugf("Expired membership of %s in %s is read only: %s", u.Name, g, err)
+					continue
+				} else if err != nil {
+					log.WithField("zone", name).Warnf("Failed to remove expired membership of %s in %s: %s", u.Name, g, err)
+					continue
+				}
+
+				c.Audit(AuditEvent{
+					Zone:      z.Name(),
+					Operation: "membership_expired",
+					Target:    u.Name + " from " + g,
+					Actor:     "cerber",
+					Result:    "success",
+					Reason:    "membership expired at " + u.Until[g],
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMembershipExpiry checks expired memberships grant nothing and expiries are validated
+func TestMembershipExpiry(t *testing.T) {
+	z := &memberZone{
+		stubZone: stubZone{name: "registry"},
+		users: map[string]*User{
+			"oncall": {Name: "oncall", Groups: []string{"read", "write"}, Until: map[string]string{"write": "2020-06-01T12:00:00Z"}},
+		},
+		groups: map[string]*Group{
+			"read":  {Name: "read", Actions: []string{"*:pull"}},
+			"write": {Name: "write", Actions: []string{"app:push"}},
+		},
+	}
+
+	cerber, _ := New("test")
+	before := AccessContext{Time: time.Date(2020, 6, 1, 11, 0, 0, 0, time.UTC)}
+	if actions, err := cerber.UserActions(z, "oncall", before); err != nil || strings.Join(actions, ",") != "*:pull,app:push" {
+		t.Fatalf("Membership must be granted before expiry: %v %v", actions, err)
+	}
+
+	after := AccessContext{Time: time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)}
+	if actions, err := cerber.UserActions(z, "oncall", after); err != nil || strings.Join(actions, ",") != "*:pull" {
+		t.Fatalf("Expired membership must be ignored: %v %v", actions, err)
+	}
+
+	for _, u := range []User{
+		{Name: "a", Groups: []string{"read"}, Until: map[string]string{"write": "2020-06-01"}},
+		{Name: "b", Groups: []string{"read"}, Until: map[string]string{"read": "tomorrow"}},
+	} {
+		if err := u.ValidateMemberships(); err == nil {
+			t.Fatalf("Expected invalid expiry of %s to be rejected", u.Name)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// signingStats exports per zone token signing counters as "signing" expvar variable:
+//
+//	{"registry": {"count": 10, "errors": 1, "latency_ms": 0.8, "latency_ms_total": 9.2}}
+var (
+	statsLock    sync.Mutex
+	signingStats = expvar.NewMap("signing")
+)
+
+// certificateStats exports unix time active signing certificate of every zone expires at as
+// "certificates" expvar variable
+var certificateStats = expvar.NewMap("certificates")
+
+// observeSigning records single signing attempt of the zone
+func observeSigning(zone string, elapsed time.Duration, err error) {
+	statsLock.Lock()
+	stats, ok := signingStats.Get(zone).(*expvar.Map)
+	if !ok {
+		stats = new(expvar.Map).Init()
+		signingStats.Set(zone, stats)
+	}
+	statsLock.Unlock()
+
+	ms := float64(elapsed) / float64(time.Millisecond)
+	latency := new(expvar.Float)
+	latency.Set(ms)
+
+	stats.Add("count", 1)
+	if err != nil {
+		stats.Add("errors", 1)
+	}
+	stats.Set("latency_ms", latency)
+	stats.AddFloat("latency_ms_total", ms)
+}
+
+// observeCertificate records expiry time of the active zone certificate
+func observeCertificate(zone string, notAfter time.Time) {
+	expires := new(expvar.Int)
+	expires.Set(notAfter.Unix())
+	certificateStats.Set(zone, expires)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"fmt"
+	"unicode"
+)
+
+// PasswordPolicy restricts passwords users choose for themselves, zero fields impose no limits
+type PasswordPolicy struct {
+	// Minimum number of characters
+	MinLength int `yaml:"min_length,omitempty" json:"min_length,omitempty"`
+
+	// Minimum number of character classes used: lower and upper case letters, digits and others
+	Classes int `yaml:"classes,omitempty" json:"classes,omitempty"`
+
+	// Number of previous passwords which couldn't be reused, current password is never accepted
+	History int `yaml:"history,omitempty" json:"history,omitempty"`
+}
+
+// PasswordPolicyZone is implemented by zones restricting passwords users set with password change
+type PasswordPolicyZone interface {
+	// PasswordPolicy returns password policy of the zone, nil if passwords are not restricted
+	PasswordPolicy() *PasswordPolicy
+}
+
+// ZonePasswordPolicy returns password policy of the zone, policy without limits if zone has none
+func ZonePasswordPolicy(z Zone) PasswordPolicy {
+	if p, ok := Underlying(z).(PasswordPolicyZone); ok && p.PasswordPolicy() != nil {
+		return *p.PasswordPolicy()
+	}
+	return PasswordPolicy{}
+}
+
+// PasswordPolicyError returns when new password doesn't satisfy zone password policy
+type PasswordPolicyError struct {
+	Reason string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "Password is rejected: " + e.Reason
+}
+
+// Validate checks policy limits are not negative
+func (p PasswordPolicy) Validate() error {
+	if p.MinLength < 0 || p.History < 0 {
+		return fmt.Errorf("Password policy limits must not be negative")
+	} else if p.Classes < 0 || p.Classes > 4 {
+		return fmt.Errorf("Password policy classes must be between 0 and 4, found %d", p.Classes)
+	}
+	return nil
+}
+
+// Check verifies password satisfies the policy, hash is the password hashed with the zone hasher
+// and is compared with the current and previous passwords of the user
+func (p PasswordPolicy) Check(usr *User, passwd, hash string) error {
+	if n := len([]rune(passwd)); n < p.MinLength {
+		return &PasswordPolicyError{Reason: fmt.Sprintf("at least %d characters are required", p.MinLength)}
+	}
+
+	var lower, upper, digit, other bool
+	for _, r := range passwd {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	classes := 0
+	for _, used := range []bool{lower, upper, digit, other} {
+		if used {
+			classes++
+		}
+	}
+	if classes < p.Classes {
+		return &PasswordPolicyError{Reason: fmt.Sprintf("at least %d of lower case, upper case, digits and other characters are required", p.Classes)}
+	}
+
+	if hash == usr.Passwd {
+		return &PasswordPolicyError{Reason: "new password must differ from the current one"}
+	}
+	for i, old := range usr.PasswordHistory {
+		if i < p.History && hash == old {
+			return &PasswordPolicyError{Reason: fmt.Sprintf("last %d passwords couldn't be reused", p.History)}
+		}
+	}
+	return nil
+}
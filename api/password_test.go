@@ -0,0 +1,66 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// TestPasswordPolicy checks length, character classes and history of the new password
+func TestPasswordPolicy(t *testing.T) {
+	policy := PasswordPolicy{MinLength: 8, Classes: 3, History: 1}
+	usr := &User{Name: "ci", Passwd: "Current-1", PasswordHistory: []string{"Previous-1", "Ancient-1"}}
+
+	cases := map[string]bool{
+		"Short-1":    false,
+		"lowercase1": false,
+		"Current-1":  false,
+		"Previous-1": false,
+		"Ancient-1":  true,
+		"Renewed-1":  true,
+	}
+	for passwd, valid := range cases {
+		if err := policy.Check(usr, passwd, passwd); valid != (err == nil) {
+			t.Fatalf("Unexpected check of %s: %v", passwd, err)
+		}
+	}
+
+	if err := (PasswordPolicy{Classes: 5}).Validate(); err == nil {
+		t.Fatal("Expected invalid classes to be rejected")
+	}
+}
+
+// TestTokenRevoked checks password change revokes tokens issued before it
+func TestTokenRevoked(t *testing.T) {
+	changed := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	z := &memberZone{
+		stubZone: stubZone{name: "registry"},
+		users:    map[string]*User{"ci": {Name: "ci", PasswordChangedAt: changed.Format(time.RFC3339)}},
+	}
+
+	token := jwt.New(jwt.SigningMethodRS256)
+	token.Claims["sub"] = "ci"
+	token.Claims["orig_iat"] = float64(changed.Add(-time.Minute).Unix())
+	if err, ok := tokenUser(z, token).(*UserStateError); !ok || err.Code != TokenRevoked {
+		t.Fatalf("Expected token issued before password change to be revoked, found: %v", err)
+	}
+
+	token.Claims["orig_iat"] = float64(changed.Unix())
+	if err := tokenUser(z, token); err != nil {
+		t.Fatalf("Token issued after password change must be valid: %s", err)
+	}
+
+	// Token and password change fall in the same second
+	changed = changed.Add(600 * time.Millisecond)
+	z.users["ci"].PasswordChangedAt = changed.Format(time.RFC3339Nano)
+	token.Claims["orig_iat"] = unixTime(changed.Add(-200 * time.Millisecond))
+	if err, ok := tokenUser(z, token).(*UserStateError); !ok || err.Code != TokenRevoked {
+		t.Fatalf("Expected token issued earlier in the same second to be revoked, found: %v", err)
+	}
+
+	token.Claims["orig_iat"] = unixTime(changed.Add(200 * time.Millisecond))
+	if err := tokenUser(z, token); err != nil {
+		t.Fatalf("Token issued later in the same second must be valid: %s", err)
+	}
+}
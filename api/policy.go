@@ -0,0 +1,205 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// PolicyAllow grants requested action if policy rule holds
+	PolicyAllow = "allow"
+
+	// PolicyDeny refuses requested action if policy rule holds, even if it is granted by groups
+	PolicyDeny = "deny"
+)
+
+// Policy is an expression rule of the zone deciding on the requested access. Rule sees variables:
+//
+//	user     name and groups of the user without expired ones: user.name, user.groups
+//	groups   names of the user groups which conditions hold
+//	request  client address and lower case headers: request.ip, request.headers["user-agent"]
+//	scope    requested access: scope.type, scope.name, scope.action
+//
+// Token request without scope is checked with empty scope fields and then with every group action
+// as the scope, see PolicyZone
+type Policy struct {
+	Name   string `yaml:"name" json:"name"`
+	Effect string `yaml:"effect" json:"effect"`
+	Rule   string `yaml:"rule" json:"rule"`
+
+	// Rule compiled by Validate, so it isn't parsed on every request
+	compiled *Expression
+}
+
+// PolicyZone is implemented by zones having expression policies. Policies are applied on top of the
+// group actions: any matched deny policy refuses the action, otherwise matched allow policy grants it.
+// Rule failed to evaluate is treated as matched deny policy and as not matched allow one. Token
+// request without scope is refused if deny policy matches empty scope, otherwise it gets group
+// actions which are not refused by deny policies, action patterns are seen by rules as scope names
+type PolicyZone interface {
+	// Policies returns policies of the zone in evaluation order
+	Policies() []Policy
+}
+
+// PolicyTrace is a result of the policy evaluation, Error is set if rule failed to evaluate
+type PolicyTrace struct {
+	Policy  string `json:"policy"`
+	Effect  string `json:"effect"`
+	Matched bool   `json:"matched"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Validate checks policy effect and rule syntax, compiled rule is kept for evaluation. Zones are
+// expected to validate policies once they are loaded
+func (p *Policy) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("Policy name is required")
+	} else if p.Effect != PolicyAllow && p.Effect != PolicyDeny {
+		return fmt.Errorf("Policy %s effect must be allow or deny, found '%s'", p.Name, p.Effect)
+	}
+
+	expr, err := CompileExpression(p.Rule)
+	if err != nil {
+		return fmt.Errorf("Policy %s has invalid rule: %s", p.Name, err)
+	}
+	p.compiled = expr
+	return nil
+}
+
+// expression returns compiled rule, policy which wasn't validated is compiled on every call
+func (p Policy) expression() (*Expression, error) {
+	if p.compiled != nil {
+		return p.compiled, nil
+	}
+	return CompileExpression(p.Rule)
+}
+
+// ZonePolicies returns policies of the zone, nil if zone has none
+func ZonePolicies(z Zone) []Policy {
+	if p, ok := Underlying(z).(PolicyZone); ok {
+		return p.Policies()
+	}
+	return nil
+}
+
+// decide makes the final decision on the scope action: groups actions first, then zone policies
+func decide(policies []Policy, usr *User, grants []GroupGrant, ctx AccessContext, typ, name, action string) (Decision, []PolicyTrace) {
+	d := Decide(grantedActions(grants), typ, name, action)
+	if len(policies) == 0 || (typ != "repository" && typ != "") {
+		// Like group actions policies apply to repositories only
+		return d, nil
+	}
+
+	env := policyEnv(usr, grants, ctx, typ, name, action)
+	traces := evaluatePolicies(policies, env)
+
+	allowed := ""
+	for _, t := range traces {
+		if t.Effect == PolicyDeny && (t.Matched || t.Error != "") {
+			return Decision{Scope: d.Scope, Rule: "policy:" + t.Policy}, traces
+		} else if t.Effect == PolicyAllow && t.Matched && allowed == "" {
+			allowed = t.Policy
+		}
+	}
+
+	// Cerber resources are granted by groups only, so allow policy couldn't grant administration
+	if !d.Allowed && allowed != "" && !IsCerberResource(name+":") {
+		d.Allowed, d.Rule = true, "policy:"+allowed
+	}
+	return d, traces
+}
+
+// applyPolicies returns actions granted to the user by groups and zone policies. If scopes are
+// requested concrete name:action of every granted scope action returns
+func applyPolicies(z Zone, usr *User, grants []GroupGrant, ctx AccessContext) ([]string, error) {
+	policies := ZonePolicies(z)
+	if len(policies) == 0 {
+		return grantedActions(grants), nil
+	}
+
+	if len(ctx.Scopes) == 0 {
+		d, _ := decide(policies, usr, grants, ctx, "", "", "")
+		if isPolicyDenial(d) {
+			return nil, fmt.Errorf("Denied by %s", d.Rule)
+		}
+
+		// Every group action is checked as the scope, so deny policies drop actions they match
+		actions := make([]string, 0)
+		for _, a := range grantedActions(grants) {
+			idx := strings.LastIndex(a, ":")
+			if idx == -1 {
+				continue
+			}
+			if d, _ := decide(policies, usr, grants, ctx, "repository", a[:idx], a[idx+1:]); !isPolicyDenial(d) {
+				actions = append(actions, a)
+			}
+		}
+		return actions, nil
+	}
+
+	actions := make([]string, 0)
+	for _, s := range ctx.Scopes {
+		for _, a := range s.Actions {
+			if d, _ := decide(policies, usr, grants, ctx, s.Type, s.Name, a); d.Allowed {
+				actions = append(actions, s.Name+":"+a)
+			}
+		}
+	}
+	return actions, nil
+}
+
+// isPolicyDenial returns true if action was refused by the deny policy rather than not granted
+func isPolicyDenial(d Decision) bool {
+	return !d.Allowed && strings.HasPrefix(d.Rule, "policy:")
+}
+
+// evaluatePolicies evaluates all policy rules in the given environment
+func evaluatePolicies(policies []Policy, env map[string]interface{}) []PolicyTrace {
+	result := make([]PolicyTrace, len(policies))
+	for i, p := range policies {
+		result[i] = PolicyTrace{Policy: p.Name, Effect: p.Effect}
+
+		expr, err := p.expression()
+		if err == nil {
+			result[i].Matched, err = expr.Eval(env)
+		}
+		if err != nil {
+			result[i].Error = err.Error()
+		}
+	}
+	return result
+}
+
+// policyEnv builds variables policy rules see
+func policyEnv(usr *User, grants []GroupGrant, ctx AccessContext, typ, name, action string) map[string]interface{} {
+	assigned := make([]interface{}, 0, len(usr.Groups))
+	for _, g := range usr.Groups {
+		if !usr.Expired(g, ctx.Time) {
+			assigned = append(assigned, g)
+		}
+	}
+
+	granted := make([]interface{}, 0, len(grants))
+	for _, g := range grants {
+		if g.Granted {
+			granted = append(granted, g.Name)
+		}
+	}
+
+	headers := make(map[string]interface{}, len(ctx.Header))
+	for k, v := range ctx.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+
+	ip := ""
+	if ctx.ClientIP != nil {
+		ip = ctx.ClientIP.String()
+	}
+
+	return map[string]interface{}{
+		"user":    map[string]interface{}{"name": usr.Name, "groups": assigned},
+		"groups":  granted,
+		"request": map[string]interface{}{"ip": ip, "headers": headers},
+		"scope":   map[string]interface{}{"type": typ, "name": name, "action": action},
+	}
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestExpression checks rule syntax, operators and functions
+func TestExpression(t *testing.T) {
+	env := map[string]interface{}{
+		"user":    map[string]interface{}{"name": "alice", "groups": []interface{}{"dev", "ops"}},
+		"groups":  []interface{}{"dev"},
+		"request": map[string]interface{}{"ip": "10.1.2.3", "headers": map[string]interface{}{"user-agent": "docker/20.10"}},
+		"scope":   map[string]interface{}{"type": "repository", "name": "alice/app", "action": "push"},
+	}
+
+	cases := map[string]bool{
+		`scope.action == "push" && startsWith(scope.name, user.name + "/")`:    true,
+		`"ops" in user.groups && !("ops" in groups)`:                           true,
+		`inNetwork(request.ip, "10.1.0.0/16") || false`:                        true,
+		`matches(scope.name, "*/app") && scope.action in ['pull', 'push']`:     true,
+		`startsWith(lower(request.headers["User-Agent"]), "docker")`:           false,
+		`startsWith(request.headers["user-agent"], "docker")`:                  true,
+		`request.headers["x-missing"] == ""`:                                   true,
+		`(scope.type != "repository" || endsWith(scope.name, "/lib")) && true`: false,
+	}
+	for source, expected := range cases {
+		expr, err := CompileExpression(source)
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %s", source, err)
+		}
+		if result, err := expr.Eval(env); err != nil || result != expected {
+			t.Fatalf("Expected %s to be %v, found %v %v", source, expected, result, err)
+		}
+	}
+
+	for _, invalid := range []string{`scope.name ==`, `"unterminated`, `unknown(scope)`, `a && (b`, `scope.name # 1`} {
+		if _, err := CompileExpression(invalid); err == nil {
+			t.Fatalf("Expected %s to be invalid", invalid)
+		}
+	}
+	for _, failing := range []string{`scope.name`, `missing == ""`, `user.missing == ""`, `startsWith(user.groups, "d")`} {
+		expr, _ := CompileExpression(failing)
+		if _, err := expr.Eval(env); err == nil {
+			t.Fatalf("Expected %s to fail", failing)
+		}
+	}
+}
+
+// policyZone is a stub zone with expression policies
+type policyZone struct {
+	memberZone
+	policies []Policy
+}
+
+func (z *policyZone) Policies() []Policy { return z.policies }
+
+// TestPolicies checks policies are applied on top of group actions by login and explain
+func TestPolicies(t *testing.T) {
+	z := &policyZone{
+		memberZone: memberZone{
+			stubZone: stubZone{name: "registry"},
+			users:    map[string]*User{"alice": {Name: "alice", Groups: []string{"dev"}}},
+			groups:   map[string]*Group{"dev": {Name: "dev", Actions: []string{"*/*:pull", "*/*:push"}}},
+		},
+		policies: []Policy{
+			{Name: "own-namespace", Effect: PolicyAllow, Rule: `scope.action == "delete" && startsWith(scope.name, user.name + "/")`},
+			{Name: "release-from-ci", Effect: PolicyDeny, Rule: `startsWith(scope.name, "release/") && scope.action == "push" && !inNetwork(request.ip, "10.1.0.0/16")`},
+		},
+	}
+	for i := range z.policies {
+		if err := z.policies[i].Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cerber, _ := New("test")
+	scopes := []Scope{
+		{Type: "repository", Name: "alice/app", Actions: []string{"delete"}},
+		{Type: "repository", Name: "bob/app", Actions: []string{"delete"}},
+		{Type: "repository", Name: "release/app", Actions: []string{"pull", "push"}},
+	}
+	ctx := AccessContext{ClientIP: net.ParseIP("192.168.1.1"), Header: http.Header{}, Scopes: scopes}
+
+	actions, err := cerber.Authorize(z, "alice", "", ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	granted := Intersect(actions, scopes)
+	if len(granted) != 2 || granted[0].String() != "repository:alice/app:delete" || granted[1].String() != "repository:release/app:pull" {
+		t.Fatalf("Unexpected access: %v", granted)
+	}
+
+	e, err := cerber.Explain(z, "alice", scopes, ctx)
+	if err != nil {
+		t.Fatal(err)
+	} else if c := e.Checks[0]; !c.Allowed || c.Rule != "policy:own-namespace" {
+		t.Fatalf("Allow policy is not explained: %+v", c)
+	} else if c := e.Checks[3]; c.Allowed || c.Rule != "policy:release-from-ci" || !c.Policies[1].Matched {
+		t.Fatalf("Deny policy is not explained: %+v", c)
+	}
+
+	// Without scope group actions are checked one by one, deny policy which doesn't apply keeps them
+	z.groups["dev"].Actions = append(z.groups["dev"].Actions, "cerber:zone/registry:admin")
+	actions, err = cerber.Authorize(z, "alice", "", AccessContext{})
+	if err != nil || strings.Join(actions, ",") != "*/*:pull,*/*:push,cerber:zone/registry:admin" {
+		t.Fatalf("Expected group actions without scope: %v %v", actions, err)
+	}
+
+	push := Policy{Name: "no-push", Effect: PolicyDeny, Rule: `scope.action == "push"`}
+	if err := push.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	z.policies = append(z.policies, push)
+	actions, err = cerber.Authorize(z, "alice", "", AccessContext{})
+	if err != nil || strings.Join(actions, ",") != "*/*:pull,cerber:zone/registry:admin" {
+		t.Fatalf("Expected denied action to be dropped without scope: %v %v", actions, err)
+	}
+
+	// Allow policy couldn't grant cerber resources
+	z.groups["dev"].Actions = []string{"*/*:pull", "*/*:push"}
+	z.policies = []Policy{{Name: "anything", Effect: PolicyAllow, Rule: `true`}}
+	if err := z.policies[0].Validate(); err != nil {
+		t.Fatal(err)
+	}
+	admin := []Scope{
+		{Type: "repository", Name: "cerber:zone/registry", Actions: []string{"admin"}},
+		{Type: "repository", Name: "cerber", Actions: []string{"admin"}},
+		{Type: "repository", Name: "bob/app", Actions: []string{"delete"}},
+	}
+	actions, err = cerber.Authorize(z, "alice", "", AccessContext{Scopes: admin})
+	if err != nil || len(actions) != 1 || actions[0] != "bob/app:delete" {
+		t.Fatalf("Expected policy to grant repository action only: %v %v", actions, err)
+	}
+
+	// Matched allow policy doesn't refuse request without scope
+	actions, err = cerber.Authorize(z, "alice", "", AccessContext{})
+	if err != nil || strings.Join(actions, ",") != "*/*:pull,*/*:push" {
+		t.Fatalf("Expected allow policy to keep group actions without scope: %v %v", actions, err)
+	}
+}
@@ -1,12 +1,52 @@
 package api
 
-import "net/url"
+import (
+	"net/url"
+	"time"
+)
 
 // Provider represents source of zone descriptions, such as filesystem or database
 type Provider interface {
 	URL() *url.URL
 	FindZone(zone string) (Zone, error)
+
+	// Zones returns names of all zones currently served by the provider
+	Zones() []string
+
+	// IsOnline returns true if provider serves zones. Error is the last problem provider faced, it
+	// could be set for online provider as well, for example if background refresh failed
 	IsOnline() (bool, error)
 	Start() error
 	Stop() error
 }
+
+// Watcher is implemented by providers which reload zones in background and are able to notify
+// about changes, for example to invalidate caches
+type Watcher interface {
+	// Watch registers callback which is called with zone name once zone was changed or removed
+	Watch(callback func(zone string))
+}
+
+// ProviderState is a lifecycle phase of the registered provider
+type ProviderState string
+
+// Provider states tracked by Cerber
+const (
+	ProviderStarting ProviderState = "starting"
+	ProviderOnline   ProviderState = "online"
+	ProviderFailed   ProviderState = "failed"
+	ProviderStopped  ProviderState = "stopped"
+)
+
+// ProviderStatus is a snapshot of the registered provider health
+type ProviderStatus struct {
+	URL       string        `json:"url"`
+	Priority  int           `json:"priority"`
+	State     ProviderState `json:"state"`
+	Since     time.Time     `json:"since"`
+	LastError string        `json:"last_error,omitempty"`
+	Zones     int           `json:"zones"`
+
+	// Source revision loaded zones are backed by, set for versioned providers such as git
+	Revision string `json:"revision,omitempty"`
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningMethodFactory creates jwt signing method instance
+type SigningMethodFactory func() jwt.SigningMethod
+
+var (
+	signingLock    sync.RWMutex
+	signingMethods = make(map[string]SigningMethodFactory)
+)
+
+// SignerMethod is implemented by signing methods able to sign with crypto.Signer, so private key
+// could be kept outside of the process. Methods without it get private key of the zone as is
+type SignerMethod interface {
+	jwt.SigningMethod
+
+	// SignWith returns encoded signature of the signing string
+	SignWith(signingString string, signer crypto.Signer) (string, error)
+}
+
+// signingMethodRSA extends jwt RSA methods with signing through crypto.Signer
+type signingMethodRSA struct {
+	*jwt.SigningMethodRSA
+}
+
+func init() {
+	for _, m := range []*jwt.SigningMethodRSA{jwt.SigningMethodRS256, jwt.SigningMethodRS384, jwt.SigningMethodRS512} {
+		method := signingMethodRSA{m}
+		RegisterSigningMethod(method.Alg(), func() jwt.SigningMethod { return method })
+	}
+}
+
+// SignWith signs PKCS#1 v1.5 digest of the signing string
+func (m signingMethodRSA) SignWith(signingString string, signer crypto.Signer) (string, error) {
+	if _, ok := signer.Public().(*rsa.PublicKey); !ok {
+		return "", jwt.ErrInvalidKey
+	} else if !m.Hash.Available() {
+		return "", jwt.ErrHashUnavailable
+	}
+
+	hasher := m.Hash.New()
+	hasher.Write([]byte(signingString))
+
+	sig, err := signer.Sign(rand.Reader, hasher.Sum(nil), m.Hash)
+	if err != nil {
+		return "", err
+	}
+	return jwt.EncodeSegment(sig), nil
+}
+
+// RegisterSigningMethod makes signing method available for zones under the given "alg" name. Method is
+// also registered in jwt library, so tokens signed with it could be parsed. Method signs tokens with
+// private key of the zone certificate and verifies them with the certificate public key. Registering
+// the same name twice panics
+func RegisterSigningMethod(alg string, factory SigningMethodFactory) {
+	signingLock.Lock()
+	defer signingLock.Unlock()
+
+	if factory == nil {
+		panic("Signing method factory is nil: " + alg)
+	} else if _, ok := signingMethods[alg]; ok {
+		panic("Signing method is already registered: " + alg)
+	}
+
+	signingMethods[alg] = factory
+	jwt.RegisterSigningMethod(alg, func() jwt.SigningMethod { return factory() })
+}
+
+// GetSigningMethod returns registered signing method by its "alg" name
+func GetSigningMethod(alg string) (jwt.SigningMethod, error) {
+	signingLock.RLock()
+	defer signingLock.RUnlock()
+
+	factory, ok := signingMethods[alg]
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing method: %s", alg)
+	}
+	return factory(), nil
+}
+
+// SigningMethods returns sorted names of all registered signing methods
+func SigningMethods() []string {
+	signingLock.RLock()
+	defer signingLock.RUnlock()
+
+	names := make([]string, 0, len(signingMethods))
+	for name := range signingMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// zoneSigningMethod resolves signing method configured for the zone
+func zoneSigningMethod(z Zone) (jwt.SigningMethod, error) {
+	alg := z.SigningMethod()
+	if strings.TrimSpace(alg) == "" {
+		return nil, fmt.Errorf("Zone '%s' has no signing method configured", z.Name())
+	}
+	return GetSigningMethod(alg)
+}
+
+// slowSigning is the signing latency logged as warning, remote signers are expected to answer faster
+const slowSigning = 500 * time.Millisecond
+
+// signWith computes token signature with the zone key. Key implementing crypto.Signer is used through
+// that interface if signing method supports it. Latency and failures are logged and counted in metrics
+func signWith(zone string, token *jwt.Token, key crypto.PrivateKey) (string, error) {
+	signingString, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	var sig string
+	if method, ok := token.Method.(SignerMethod); ok && isSigner(key) {
+		sig, err = method.SignWith(signingString, key.(crypto.Signer))
+	} else {
+		sig, err = token.Method.Sign(signingString, key)
+	}
+	elapsed := time.Since(start)
+	observeSigning(zone, elapsed, err)
+
+	entry := log.WithFields(log.Fields{"zone": zone, "alg": token.Method.Alg(), "latency": elapsed})
+	if err != nil {
+		entry.WithField("reason", err).Error("Token signing failed")
+		return "", fmt.Errorf("Failed to sign token for the zone '%s': %s", zone, err)
+	} else if elapsed > slowSigning {
+		entry.Warn("Token signing is slow")
+	} else {
+		entry.Debug("Token signed")
+	}
+	return signingString + "." + sig, nil
+}
+
+func isSigner(key crypto.PrivateKey) bool {
+	_, ok := key.(crypto.Signer)
+	return ok
+}
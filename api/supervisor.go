@@ -0,0 +1,168 @@
+package api
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// registration tracks state of the provider registered in Cerber and restarts it
+// with exponential backoff once it fails
+type registration struct {
+	provider Provider
+	priority int
+
+	lock    sync.RWMutex
+	state   ProviderState
+	since   time.Time
+	lastErr error
+
+	stop chan bool
+	done chan bool
+}
+
+func newRegistration(p Provider, priority int) *registration {
+	return &registration{
+		provider: p,
+		priority: priority,
+		state:    ProviderStopped,
+		since:    time.Now(),
+		stop:     make(chan bool),
+		done:     make(chan bool),
+	}
+}
+
+// online returns true if provider serves zones
+func (r *registration) online() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.state == ProviderOnline
+}
+
+// status returns snapshot of the provider health
+func (r *registration) status() ProviderStatus {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	s := ProviderStatus{
+		URL:      redactURL(r.provider),
+		Priority: r.priority,
+		State:    r.state,
+		Since:    r.since,
+	}
+	if r.lastErr != nil {
+		s.LastError = r.lastErr.Error()
+	}
+	if r.state == ProviderOnline {
+		s.Zones = len(r.provider.Zones())
+	}
+	if v, ok := r.provider.(Versioned); ok {
+		s.Revision = v.Revision()
+	}
+	return s
+}
+
+func (r *registration) setState(state ProviderState, err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.state != state {
+		r.since = time.Now()
+	}
+	r.state, r.lastErr = state, err
+}
+
+// start makes a single attempt to start provider
+func (r *registration) start() error {
+	r.setState(ProviderStarting, nil)
+	if err := r.provider.Start(); err != nil {
+		r.setState(ProviderFailed, err)
+		return err
+	}
+
+	r.setState(ProviderOnline, nil)
+	return nil
+}
+
+// supervise restarts failed provider and periodically checks if running provider is
+// still online. Returns once registration is stopped
+func (r *registration) supervise(c *Cerber) {
+	defer close(r.done)
+
+	delay := c.RetryMin
+	for {
+		if !r.online() {
+			if !r.wait(delay) {
+				return
+			}
+
+			if err := r.start(); err != nil {
+				delay *= 2
+				if delay > c.RetryMax {
+					delay = c.RetryMax
+				}
+				log.Warnf("Failed to start zone provider '%s', retry in %s: %s", redactURL(r.provider), delay, err)
+				continue
+			}
+
+			log.Infof("Zone provider '%s' is online", redactURL(r.provider))
+			delay = c.RetryMin
+			c.checkConflicts()
+			c.checkCertificates(r.provider)
+		}
+
+		if !r.wait(c.CheckInterval) {
+			return
+		}
+
+		online, err := r.provider.IsOnline()
+		if online {
+			// Keep provider reported problems visible in status
+			r.lock.Lock()
+			r.lastErr = err
+			r.lock.Unlock()
+
+			// Zones could be reloaded in background
+			c.checkConflicts()
+			c.checkCertificates(r.provider)
+			c.sweepMemberships(r.provider)
+			continue
+		}
+
+		if err == nil {
+			err = errors.New("provider reported offline state")
+		}
+		log.Warnf("Zone provider '%s' went offline, restarting: %s", redactURL(r.provider), err)
+		r.setState(ProviderFailed, err)
+		if err := r.provider.Stop(); err != nil {
+			log.Warnf("Failed to stop zone provider '%s': %s", redactURL(r.provider), err)
+		}
+	}
+}
+
+// wait sleeps for the given duration, returns false if registration was stopped meanwhile
+func (r *registration) wait(d time.Duration) bool {
+	select {
+	case <-r.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// shutdown stops supervisor and provider itself
+func (r *registration) shutdown() error {
+	close(r.stop)
+	<-r.done
+
+	err := r.provider.Stop()
+	r.setState(ProviderStopped, err)
+	return err
+}
+
+// redactURL returns provider URL without password
+func redactURL(p Provider) string {
+	return p.URL().Redacted()
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyProvider fails to start given number of times and could be put offline
+type flakyProvider struct {
+	lock     sync.Mutex
+	failures int
+	online   bool
+	starts   int
+}
+
+func (p *flakyProvider) URL() *url.URL                      { return &url.URL{Scheme: "test", Host: "flaky"} }
+func (p *flakyProvider) FindZone(name string) (Zone, error) { return nil, errors.New("Not found") }
+func (p *flakyProvider) Zones() []string                    { return nil }
+
+func (p *flakyProvider) IsOnline() (bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.online, nil
+}
+
+func (p *flakyProvider) Start() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.starts++
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("Backend is unavailable")
+	}
+	p.online = true
+	return nil
+}
+
+func (p *flakyProvider) Stop() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.online = false
+	return nil
+}
+
+func (p *flakyProvider) setOnline(online bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.online = online
+}
+
+func (p *flakyProvider) startCount() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.starts
+}
+
+func waitState(t *testing.T, c *Cerber, state ProviderState) {
+	for i := 0; i < 200; i++ {
+		if s := c.Status(); len(s) == 1 && s[0].State == state {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Provider didn't reach state %s: %+v", state, c.Status())
+}
+
+// TestProviderRestart checks failed and offline providers are restarted in background
+func TestProviderRestart(t *testing.T) {
+	c, _ := New("test")
+	c.RetryMin, c.RetryMax, c.CheckInterval = time.Millisecond, 4*time.Millisecond, time.Millisecond
+
+	p := &flakyProvider{failures: 3}
+	if err := c.AddProvider(p); err == nil {
+		t.Fatal("Expected first start to fail")
+	}
+	waitState(t, c, ProviderOnline)
+
+	p.setOnline(false)
+	for i := 0; i < 200 && p.startCount() < 5; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := p.startCount(); n != 5 {
+		t.Fatalf("Expected 5 start attempts but found: %d", n)
+	}
+	waitState(t, c, ProviderOnline)
+
+	c.Stop()
+	if len(c.Providers()) != 0 {
+		t.Fatal("Expected all providers to be removed")
+	}
+	if online, _ := p.IsOnline(); online {
+		t.Fatal("Expected provider to be stopped")
+	}
+}
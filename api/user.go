@@ -0,0 +1,51 @@
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+// CheckState verifies user state allows access at the given moment, UserStateError returns otherwise
+func (u *User) CheckState(now time.Time) error {
+	if u.Disabled {
+		return &UserStateError{Code: UserDisabled, User: u.Name, Reason: "is disabled"}
+	}
+
+	if u.ExpiresAt != "" {
+		expires, err := parseUntil(u.ExpiresAt)
+		if err != nil || !now.Before(expires) {
+			return &UserStateError{Code: UserExpired, User: u.Name, Reason: "expired at " + u.ExpiresAt}
+		}
+	}
+
+	if u.MustChangePassword {
+		return &UserStateError{Code: PasswordChangeRequired, User: u.Name, Reason: "must change password"}
+	}
+
+	if u.PasswordMaxAge > 0 {
+		// Password without change time is expired, so max age couldn't be bypassed by missing field
+		changed, err := time.Parse(time.RFC3339, u.PasswordChangedAt)
+		if err != nil || !now.Before(changed.Add(u.PasswordMaxAge)) {
+			return &UserStateError{Code: PasswordExpired, User: u.Name, Reason: "password is older than " + u.PasswordMaxAge.String()}
+		}
+	}
+	return nil
+}
+
+// ValidateState checks user state fields are well formed
+func (u *User) ValidateState() error {
+	if u.ExpiresAt != "" {
+		if _, err := parseUntil(u.ExpiresAt); err != nil {
+			return fmt.Errorf("User %s expires_at: %s", u.Name, err)
+		}
+	}
+	if u.PasswordChangedAt != "" {
+		if _, err := time.Parse(time.RFC3339, u.PasswordChangedAt); err != nil {
+			return fmt.Errorf("User %s password_changed_at must be RFC3339 time: %s", u.Name, u.PasswordChangedAt)
+		}
+	}
+	if u.PasswordMaxAge < 0 {
+		return fmt.Errorf("User %s password_max_age must be positive: %s", u.Name, u.PasswordMaxAge)
+	}
+	return nil
+}
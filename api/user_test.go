@@ -0,0 +1,48 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+// TestUserState checks every state refusing access is reported with its own code
+func TestUserState(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		usr  User
+		code string
+	}{
+		{User{Name: "active", ExpiresAt: "2020-06-02", PasswordChangedAt: "2020-05-15T00:00:00Z", PasswordMaxAge: 720 * time.Hour}, ""},
+		{User{Name: "gone", Disabled: true}, UserDisabled},
+		{User{Name: "contractor", ExpiresAt: "2020-06-01"}, UserExpired},
+		{User{Name: "stale", PasswordChangedAt: "2020-04-01T00:00:00Z", PasswordMaxAge: 720 * time.Hour}, PasswordExpired},
+		{User{Name: "unknown", PasswordMaxAge: 720 * time.Hour}, PasswordExpired},
+		{User{Name: "reset", MustChangePassword: true}, PasswordChangeRequired},
+	}
+
+	for _, c := range cases {
+		err := c.usr.CheckState(now)
+		if c.code == "" && err != nil {
+			t.Fatalf("User %s must be allowed: %s", c.usr.Name, err)
+		} else if state, ok := err.(*UserStateError); c.code != "" && (!ok || state.Code != c.code) {
+			t.Fatalf("Expected %s for user %s, found: %v", c.code, c.usr.Name, err)
+		}
+	}
+
+	z := &memberZone{
+		stubZone: stubZone{name: "registry"},
+		users:    map[string]*User{"gone": {Name: "gone", Passwd: "secret", Disabled: true}},
+		groups:   map[string]*Group{},
+	}
+	cerber, _ := New("test")
+	if _, err := cerber.Authorize(z, "gone", "wrong", AccessContext{Time: now}); IsUserState(err) {
+		t.Fatal("State must not be disclosed before credentials are checked")
+	}
+	if _, err := cerber.Authorize(z, "gone", "secret", AccessContext{Time: now}); !IsUserState(err) {
+		t.Fatalf("Expected disabled user to be refused, found: %v", err)
+	}
+
+	if err := (&User{Name: "bad", ExpiresAt: "soon"}).ValidateState(); err == nil {
+		t.Fatal("Expected malformed expiry to be rejected")
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import "time"
+
+// WritableZone is implemented by zones which could be modified at runtime, for example by admin API.
+// Passwords are passed already hashed with zone HashPassword. Missing entities are reported with
+// NotFoundError, duplicated ones with ExistsError and changes source doesn't allow with ReadOnlyError.
+// Only zones of the directory provider implement it
+type WritableZone interface {
+	Zone
+
+	// Users returns all users of the zone
+	Users() ([]User, error)
+
+	// Groups returns all groups of the zone
+	Groups() ([]Group, error)
+
+	// CreateUser adds new user, all user groups must exist
+	CreateUser(usr User) error
+
+	// UpdateUser replaces groups, membership expiries and state of the existing user, password is
+	// kept if not set
+	UpdateUser(usr User) error
+
+	// DeleteUser removes user from the zone
+	DeleteUser(userID string) error
+
+	// SetPassword replaces password hash of the user, change time is recorded and must change password
+	// flag is cleared
+	SetPassword(userID, passwd string) error
+
+	// AddMember adds user to the group, membership expires at until unless it is zero. Expiry of the
+	// existing membership is replaced
+	AddMember(userID, groupID string, until time.Time) error
+
+	// RemoveMember removes user from the group
+	RemoveMember(userID, groupID string) error
+
+	// CreateGroup adds new group
+	CreateGroup(grp Group) error
+
+	// UpdateGroup replaces actions of the existing group
+	UpdateGroup(grp Group) error
+
+	// DeleteGroup removes group, group without members could be deleted only
+	DeleteGroup(groupID string) error
+}
+
+// Writable returns zone modifications should be applied to. Decorated zones are writable if the
+// underlying zone is
+func Writable(z Zone) (WritableZone, bool) {
+	if w, ok := z.(WritableZone); ok {
+		return w, true
+	}
+	w, ok := Underlying(z).(WritableZone)
+	return w, ok
+}
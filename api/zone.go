@@ -6,10 +6,14 @@ import (
 )
 
 // Group is a named set of permitted actions. Each action must be in form of
-// resource:action, where resource & action are strings
+// resource:action, where resource & action are strings. Actions could be granted
+// conditionally, see Conditions
 type Group struct {
 	Name    string   `yaml:"name"`
 	Actions []string `yaml:"actions"`
+
+	// Conditions actions are granted on, granted always if nil
+	When *Conditions `yaml:"when,omitempty"`
 }
 
 // User tracks information about single user
@@ -17,6 +21,29 @@ type User struct {
 	Name   string   `yaml:"name"`
 	Passwd string   `yaml:"passwd"`
 	Groups []string `yaml:"groups,omitempty"`
+
+	// Expiry of temporary memberships: group name to RFC3339 time or date. Expired membership
+	// is ignored and is removed from writable zones by the membership sweeper
+	Until map[string]string `yaml:"until,omitempty"`
+
+	// Disabled user couldn't login or refresh tokens, record is kept for audit
+	Disabled bool `yaml:"disabled,omitempty"`
+
+	// Moment account expires at, RFC3339 time or date. Account never expires if empty
+	ExpiresAt string `yaml:"expires_at,omitempty"`
+
+	// Moment password was changed at, RFC3339 time. Password max age is counted from it
+	PasswordChangedAt string `yaml:"password_changed_at,omitempty"`
+
+	// How long password could be used after it was changed, password never expires if 0
+	PasswordMaxAge time.Duration `yaml:"password_max_age,omitempty"`
+
+	// User must change password before login is allowed, for example after password reset
+	MustChangePassword bool `yaml:"must_change_password,omitempty"`
+
+	// Hashes of the previous passwords, the latest first. Kept as long as zone password policy
+	// requires
+	PasswordHistory []string `yaml:"password_history,omitempty"`
 }
 
 // Zone repsents a single authorization zone - set of users, groups and permissions along with cryptographic information
@@ -38,6 +65,10 @@ type Zone interface {
 	// can't be more then MaxRefresh duration. If configured value is 0 then no MaxRefresh limit applied
 	MaxRefresh() time.Duration
 
+	// SigningMethod returns name of the method tokens of the zone are signed with, such as RS256. Method
+	// must be registered with RegisterSigningMethod
+	SigningMethod() string
+
 	// Certificate provides information enought to sign token issued for the users in
 	// the current Zone
 	Certificate() (*tls.Certificate, error)
@@ -51,3 +82,36 @@ type Zone interface {
 	// FindGroup performs lookup of the group by the given name
 	FindGroup(groupID string) (*Group, error)
 }
+
+// Authenticator is implemented by zones which verify user credentials on their own, for example with a bind to
+// an external directory, instead of comparing password hash stored in the user record
+type Authenticator interface {
+	// Authenticate checks given credentials and returns user information if they are valid. Password is passed
+	// through zone HashPassword before the call
+	Authenticate(userID, passwd string) (usr *User, err error)
+}
+
+// Versioned is implemented by zones which know revision of the source they were loaded from, for example
+// commit hash of the repository
+type Versioned interface {
+	// Revision returns source revision backing the zone
+	Revision() string
+}
+
+// Unwrapper is implemented by zones which decorate another zone, for example to cache lookups. Optional
+// zone interfaces such as Authenticator must be checked on the underlying zone
+type Unwrapper interface {
+	// Unwrap returns decorated zone
+	Unwrap() Zone
+}
+
+// Underlying returns the innermost zone of the decoration chain
+func Underlying(z Zone) Zone {
+	for {
+		u, ok := z.(Unwrapper)
+		if !ok {
+			return z
+		}
+		z = u.Unwrap()
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/ant0ine/go-json-rest/rest"
@@ -17,10 +21,22 @@ import (
 	"github.com/xphoenix/cerber/api"
 	"github.com/xphoenix/cerber/config"
 	handlers "github.com/xphoenix/cerber/rest"
+	"github.com/xphoenix/cerber/signer"
 	"github.com/xphoenix/cerber/zone"
 )
 
 func main() {
+	// Utility commands
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// Load configuration file
 	cfg, err := loadConfig()
 	if err != nil {
@@ -35,10 +51,35 @@ func main() {
 
 	// Configure server
 	configureLogger(cfg.Log)
-	configureZoneProviders(cerber, cfg.Providers)
+	logrus.WithFields(logrus.Fields{
+		"providers":       strings.Join(zone.ProviderSchemes(), ","),
+		"hashers":         strings.Join(zone.Hashers(), ","),
+		"signing_methods": strings.Join(api.SigningMethods(), ","),
+		"signers":         strings.Join(signer.Schemes(), ","),
+	}).Info("Registered extensions")
+	cerber.Strict = cfg.StrictZones
+	if cfg.ExpiryWarning > 0 {
+		cerber.ExpiryWarning = cfg.ExpiryWarning
+	}
+	for _, cidr := range cfg.TrustedProxies {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			logrus.Panicf("Invalid trusted proxy network: %s", cidr)
+		}
+		handlers.TrustedProxies = append(handlers.TrustedProxies, network)
+	}
+	if cfg.AuditLog != "" {
+		audit, err := os.OpenFile(cfg.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			logrus.Panicf("Failed to open audit log: %s", err)
+		}
+		defer audit.Close()
+		cerber.AuditLog = audit
+	}
+	configureZoneProviders(cerber, cfg.Providers, cfg.Cache)
 
 	api := rest.NewApi()
-	configureAPI(api, cerber)
+	configureAPI(api, cerber, cfg.ManagementZone)
 
 	// Spin up HTTP server
 	done := make(chan bool)
@@ -65,25 +106,42 @@ func main() {
 
 	// Spinup HTTP server
 	if cfg.HTTPS != nil {
+		// Key could be inline PEM resolved from the secret reference
+		cert, err := cfg.HTTPS.Certificate()
+		if err != nil {
+			logrus.Panicf("Failed to load HTTPS certificate: %s", err)
+		}
+
 		go func() {
 			srv := http.Server{
-				Addr:     fmt.Sprintf("%s:%d", cfg.HTTPS.Host, cfg.HTTPS.Port),
-				Handler:  handler,
-				ErrorLog: log.New(unhandled, "", 0),
+				Addr:      fmt.Sprintf("%s:%d", cfg.HTTPS.Host, cfg.HTTPS.Port),
+				Handler:   handler,
+				ErrorLog:  log.New(unhandled, "", 0),
+				TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
 			}
 
 			logrus.WithField("address", srv.Addr).Info("Start HTTPS interface")
-			err := srv.ListenAndServeTLS(cfg.HTTPS.Cert, cfg.HTTPS.Key)
+			err := srv.ListenAndServeTLS("", "")
 
 			logrus.WithField("reason", err).Error("HTTPS server stopped")
 			done <- true
 		}()
 	}
 
-	// Wit until one of interface dies
+	// Stop providers on termination
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logrus.WithField("signal", sig).Info("Shutting down")
+		done <- true
+	}()
+
+	// Wit until one of interface dies or process is asked to stop
 	if cfg.HTTP != nil || cfg.HTTPS != nil {
 		<-done
 	}
+	cerber.Stop()
 }
 
 func loadConfig() (config.Config, error) {
@@ -138,28 +196,40 @@ func configureLogger(cfg config.LogConfig) {
 	logrus.SetLevel(level)
 }
 
-func configureZoneProviders(c *api.Cerber, cfg []string) {
+func configureZoneProviders(c *api.Cerber, cfg []string, cache *config.CacheConfig) {
 	for _, location := range cfg {
-		// Resolve location from config
-		p, err := zone.NewProvider(location)
+		// Priority is handled by Cerber itself
+		loc, priority, err := zone.SplitPriority(location)
 		if err != nil {
 			logrus.Warnf("Error creating provider for location '%s': %s", location, err)
 			continue
 		}
 
-		// Initializate new provider
-		err2 := p.Start()
-		if err2 != nil {
-			logrus.Warnf("Failed to start zone provider '%s': %s", location, err2)
+		// Resolve location from config
+		p, err := zone.NewProvider(loc)
+		if err != nil {
+			logrus.Warnf("Error creating provider for location '%s': %s", location, err)
 			continue
 		}
 
-		// Register provider in the Cerber instance
-		c.AddProvider(p)
+		// Keep slow backends out of the hot path
+		if cache != nil {
+			p = zone.NewCachingProvider(p, *cache)
+		}
+
+		// Register provider in the Cerber instance, failed provider is restarted in background
+		if err := c.AddProviderWithPriority(p, priority); err != nil {
+			logrus.Warnf("Failed to start zone provider '%s', will retry: %s", location, err)
+		}
 	}
 }
 
-func configureAPI(api *rest.Api, cerber *api.Cerber) {
+func configureAPI(api *rest.Api, cerber *api.Cerber, managementZone string) {
+	if managementZone == "" {
+		logrus.Warn("Management zone is not set, zones could be administered by own tokens only")
+	}
+	authorizator := &handlers.ZoneAuthorizator{ManagementZone: managementZone, Rules: handlers.DefaultAccessRules}
+
 	// Create middleware chains
 	api.Use(
 		&handlers.LogMiddleware{Logger: logrus.StandardLogger()},
@@ -167,13 +237,17 @@ func configureAPI(api *rest.Api, cerber *api.Cerber) {
 		&handlers.CerberMiddleware{
 			Cerber: cerber,
 
-			// Allow login to bypass JWT auth
+			// Allow login, password change, health checks, metrics, public keys and access decisions to
+			// bypass JWT auth, decision endpoint checks tokens by itself. Metrics publish cerber
+			// variables only
 			ExceptionSelector: func(request *rest.Request) (bypass bool, err error) {
-				return request.URL.Path == "/login", nil
+				path := request.URL.Path
+				return path == "/login" || path == "/password" || path == "/health" || path == "/metrics" ||
+					path == "/keys" || path == "/authorize", nil
 			},
 
-			// Allow all request which has JWT token
-			Authorizator: nil,
+			// Admin endpoints require zone permissions, others are allowed for any valid token
+			Authorizator: authorizator.Authorize,
 		},
 		&rest.TimerMiddleware{},
 		&rest.RecorderMiddleware{},
@@ -191,6 +265,28 @@ func configureAPI(api *rest.Api, cerber *api.Cerber) {
 		rest.Get("/login", handlers.BasicLogin),
 		rest.Get("/validate", handlers.ValidateToken),
 		rest.Get("/refresh", handlers.RefreshToken),
+		rest.Post("/password", handlers.ChangePassword),
+		rest.Get("/health", handlers.Health),
+		rest.Get("/metrics", handlers.Metrics),
+		rest.Get("/keys", handlers.ZoneKeys),
+		rest.Post("/authorize", handlers.AuthorizeAccess),
+
+		// Zone administration, zone source must be writable
+		rest.Get("/admin/zones/#zone/users", handlers.ListUsers),
+		rest.Post("/admin/zones/#zone/users", handlers.CreateUser),
+		rest.Get("/admin/zones/#zone/users/#user", handlers.GetUser),
+		rest.Put("/admin/zones/#zone/users/#user", handlers.UpdateUser),
+		rest.Delete("/admin/zones/#zone/users/#user", handlers.DeleteUser),
+		rest.Put("/admin/zones/#zone/users/#user/password", handlers.SetPassword),
+		rest.Post("/admin/zones/#zone/users/#user/password/reset", handlers.ResetPassword),
+		rest.Get("/admin/zones/#zone/users/#user/explain", handlers.ExplainUser),
+		rest.Put("/admin/zones/#zone/users/#user/groups/#group", handlers.AddMember),
+		rest.Delete("/admin/zones/#zone/users/#user/groups/#group", handlers.RemoveMember),
+		rest.Get("/admin/zones/#zone/groups", handlers.ListGroups),
+		rest.Post("/admin/zones/#zone/groups", handlers.CreateGroup),
+		rest.Get("/admin/zones/#zone/groups/#group", handlers.GetGroup),
+		rest.Put("/admin/zones/#zone/groups/#group", handlers.UpdateGroup),
+		rest.Delete("/admin/zones/#zone/groups/#group", handlers.DeleteGroup),
 	)
 
 	api.SetApp(router)
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/xphoenix/cerber/api"
+	"github.com/xphoenix/cerber/config"
+	"github.com/xphoenix/cerber/signer"
+	"github.com/xphoenix/cerber/zone"
+	"gopkg.in/yaml.v2"
+)
+
+// commands are utility subcommands available instead of the config file argument
+var commands = map[string]func(args []string) error{
+	"keygen":       keygenCommand,
+	"seal":         sealCommand,
+	"signer-agent": signerAgentCommand,
+	"policy-test":  policyTestCommand,
+}
+
+// keygenCommand writes new random master key into the given file:
+//
+//	cerber keygen /etc/cerber/master.key
+func keygenCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: cerber keygen <master key file>")
+	}
+
+	key, err := config.GenerateMasterKey()
+	if err != nil {
+		return fmt.Errorf("Failed to generate master key: %s", err)
+	}
+
+	fd, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("Failed to create master key file: %s", err)
+	}
+	defer fd.Close()
+
+	_, err = fmt.Fprintln(fd, hex.EncodeToString(key))
+	return err
+}
+
+// sealCommand reads secret from stdin and prints reference sealed with the master key:
+//
+//	echo -n secret | cerber seal /etc/cerber/master.key
+func sealCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: cerber seal <master key file> < secret")
+	}
+
+	key, err := config.LoadMasterKey(args[0])
+	if err != nil {
+		return err
+	}
+
+	value, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("Failed to read secret: %s", err)
+	}
+
+	ref, err := config.Seal(key, strings.TrimRight(string(value), "\r\n"))
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(ref)
+	return nil
+}
+
+// signerAgentCommand serves named keys to remote signers until interrupted. Encrypted keys are opened
+// with passphrase from CERBER_SIGNER_PASSPHRASE environment variable:
+//
+//	cerber signer-agent /run/cerber/signer.sock registry=/etc/cerber/private/registry.key
+func signerAgentCommand(args []string) error {
+	if len(args) < 2 {
+		return errors.New("Usage: cerber signer-agent <socket> <name>=<key file>...")
+	}
+
+	keys := make(map[string]crypto.Signer)
+	for _, arg := range args[1:] {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("Key must be set as <name>=<key file>: %s", arg)
+		}
+
+		cert, err := config.KeySource{Key: parts[1], Passphrase: os.Getenv("CERBER_SIGNER_PASSPHRASE")}.Load()
+		if err != nil {
+			return fmt.Errorf("Failed to load key %s: %s", parts[0], err)
+		}
+		keys[parts[0]] = cert.PrivateKey.(crypto.Signer)
+	}
+
+	agent := signer.NewAgent(keys)
+	if err := agent.Listen(args[0]); err != nil {
+		return err
+	}
+	logrus.Infof("Signer agent is listening on %s", args[0])
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	return agent.Close()
+}
+
+// policyTest is a case of the policy test file
+type policyTest struct {
+	Name    string            `yaml:"name"`
+	User    string            `yaml:"user"`
+	Groups  []string          `yaml:"groups,omitempty"`
+	IP      string            `yaml:"ip,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	At      string            `yaml:"at,omitempty"`
+	Scope   string            `yaml:"scope"`
+	Allow   bool              `yaml:"allow"`
+}
+
+// policyTestCommand checks access decisions of the zone file against expected ones, groups of the
+// test case replace groups of the zone user:
+//
+//	cerber policy-test zones/registry.yaml registry-policy-test.yaml
+func policyTestCommand(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Usage: cerber policy-test <zone file> <tests file>")
+	}
+
+	zones, err := zone.LoadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		return fmt.Errorf("Failed to read tests: %s", err)
+	}
+	suite := struct {
+		Zone  string       `yaml:"zone"`
+		Cases []policyTest `yaml:"cases"`
+	}{}
+	if err := yaml.Unmarshal(data, &suite); err != nil {
+		return fmt.Errorf("Failed to parse tests: %s", err)
+	}
+
+	var z api.Zone
+	for _, candidate := range zones {
+		if suite.Zone == "" || strings.EqualFold(candidate.Name(), suite.Zone) {
+			z = candidate
+			break
+		}
+	}
+	if z == nil {
+		return fmt.Errorf("Zone %s is not found in %s", suite.Zone, args[0])
+	}
+
+	cerber, _ := api.New("test")
+	failed := 0
+	for _, tc := range suite.Cases {
+		result, err := runPolicyTest(cerber, z, tc)
+		if err != nil {
+			fmt.Printf("FAIL %s: %s\n", tc.Name, err)
+			failed++
+		} else if result.Allowed != tc.Allow {
+			fmt.Printf("FAIL %s: %s expected allowed=%v, found allowed=%v by %q\n", tc.Name, tc.Scope, tc.Allow, result.Allowed, result.Rule)
+			for _, p := range result.Policies {
+				fmt.Printf("     policy %s (%s): matched=%v %s\n", p.Policy, p.Effect, p.Matched, p.Error)
+			}
+			failed++
+		} else {
+			fmt.Printf("PASS %s\n", tc.Name)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d policy tests failed", failed, len(suite.Cases))
+	}
+	return nil
+}
+
+// runPolicyTest explains the test case scope the same way as token request is handled
+func runPolicyTest(cerber *api.Cerber, z api.Zone, tc policyTest) (*api.ActionTrace, error) {
+	scope, err := api.ParseScope(tc.Scope)
+	if err != nil {
+		return nil, err
+	} else if len(scope.Actions) != 1 {
+		return nil, errors.New("Exactly one action must be tested")
+	}
+
+	ctx := api.AccessContext{Time: time.Now(), Header: http.Header{}}
+	if tc.IP != "" {
+		if ctx.ClientIP = net.ParseIP(tc.IP); ctx.ClientIP == nil {
+			return nil, fmt.Errorf("Invalid client address: %s", tc.IP)
+		}
+	}
+	if tc.At != "" {
+		if ctx.Time, err = time.Parse(time.RFC3339, tc.At); err != nil {
+			return nil, fmt.Errorf("Invalid time, RFC3339 is expected: %s", tc.At)
+		}
+	}
+	for k, v := range tc.Headers {
+		ctx.Header.Set(k, v)
+	}
+
+	usr := &api.User{Name: tc.User, Groups: tc.Groups}
+	if tc.Groups == nil {
+		if usr, err = z.FindUser(tc.User); err != nil {
+			return nil, err
+		}
+	}
+
+	e, err := cerber.ExplainUser(z, usr, []api.Scope{scope}, ctx)
+	if err != nil {
+		return nil, err
+	} else if e.Error != "" {
+		return &api.ActionTrace{Decision: api.Decision{Scope: scope.String(), Rule: e.Error}}, nil
+	}
+	return &e.Checks[0], nil
+}
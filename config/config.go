@@ -5,37 +5,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
-// Certificate extends tls certificate with Yaml unmarshaling methods
-type Certificate struct {
-	tls.Certificate
-}
-
-// UnmarshalYAML decodes tls.Certificate from key/certificate srings
-func (c *Certificate) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	cfg := struct {
-		Key string `yaml:"key"`
-		Crt string `yaml:"crt"`
-	}{}
-
-	err := unmarshal(&cfg)
-	if err != nil {
-		return err
-	}
-
-	// Decode
-	cert, err := tls.LoadX509KeyPair(cfg.Crt, cfg.Key)
-	if err != nil {
-		return err
-	}
-
-	c.Certificate = cert
-	return nil
-}
-
 // HTTP procotol endpoint configuration
 type HTTP struct {
 	Host string `yaml:"iface"`
@@ -48,6 +23,20 @@ type HTTPS struct {
 	Port int    `yaml:"port"`
 	Key  string `yaml:"key"`
 	Cert string `yaml:"crt"`
+
+	// PKCS#12 bundle used instead of key and crt
+	PKCS12 string `yaml:"pkcs12,omitempty"`
+
+	// CA bundle certificate chain is verified against
+	CA string `yaml:"ca,omitempty"`
+
+	// Passphrase of the encrypted private key
+	Passphrase string `yaml:"passphrase,omitempty"`
+}
+
+// Certificate loads HTTPS certificate and key
+func (h *HTTPS) Certificate() (tls.Certificate, error) {
+	return KeySource{Key: h.Key, Crt: h.Cert, PKCS12: h.PKCS12, Passphrase: h.Passphrase, CA: h.CA}.Load()
 }
 
 // LogConfig describes logging configuration
@@ -57,11 +46,28 @@ type LogConfig struct {
 	Level  string `yaml:"level"`
 }
 
+// CacheConfig describes zone, user and group lookup cache
+type CacheConfig struct {
+	// How long found zone, user or group is reused
+	TTL time.Duration `yaml:"ttl"`
+
+	// How long lookup of unknown zone, user or group is remembered, 0 disables negative caching.
+	// Other failures, such as unavailable backend, are never cached
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+
+	// Maximum number of entries cached per provider
+	Size int `yaml:"size"`
+}
+
 // Config describes main Cerber server configuration. Such things as
 // network enpoints, zone providers, logging, e.t.c
 type Config struct {
 	Realm string `yaml:"realm"`
 
+	// File with hex encoded AES-256 key used to open sealed secrets, CERBER_MASTER_KEY_FILE
+	// environment variable is used if not set
+	MasterKeyFile string `yaml:"master_key_file,omitempty"`
+
 	// HTTP network endpoint for client communication
 	HTTP *HTTP `yaml:"http,omitempty"`
 
@@ -71,8 +77,28 @@ type Config struct {
 	// Logrus logging config
 	Log LogConfig `yaml:"log"`
 
-	// Zone providers
+	// Zone providers, 'priority' query parameter defines lookup order
 	Providers []string `yaml:"providers"`
+
+	// Refuse zones served by more than one provider instead of using the one with highest priority
+	StrictZones bool `yaml:"strict_zones"`
+
+	// Cache of provider lookups, disabled if not set
+	Cache *CacheConfig `yaml:"cache,omitempty"`
+
+	// Zone which tokens could administer all zones through admin API, zones without it could be
+	// administered by own tokens only
+	ManagementZone string `yaml:"management_zone,omitempty"`
+
+	// Networks of reverse proxies X-Forwarded-For header is trusted from, client address is used to
+	// check group conditions
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+
+	// File admin API changes are appended to, changes are written to the main log if not set
+	AuditLog string `yaml:"audit_log,omitempty"`
+
+	// How long before zone signing certificate expiry warnings are logged, default is 720h
+	ExpiryWarning time.Duration `yaml:"expiry_warning,omitempty"`
 }
 
 // New creates new config with all values set to defaults. Function creates minimum
@@ -96,6 +122,31 @@ func Load(input io.Reader) (Config, error) {
 		return cfg, fmt.Errorf("Failed to read config yaml file: %s", err)
 	}
 
+	// Master key must be known before secret references are resolved
+	head := struct {
+		MasterKeyFile string `yaml:"master_key_file"`
+	}{}
+	if err := yaml.Unmarshal(bytes, &head); err != nil {
+		return cfg, fmt.Errorf("Failed to parse config yaml file: %s", err)
+	}
+	if head.MasterKeyFile == "" {
+		head.MasterKeyFile = os.Getenv("CERBER_MASTER_KEY_FILE")
+	}
+	if head.MasterKeyFile != "" {
+		key, err := LoadMasterKey(head.MasterKeyFile)
+		if err != nil {
+			return cfg, err
+		}
+		if err := Secrets.SetMasterKey(key); err != nil {
+			return cfg, err
+		}
+	}
+
+	bytes, err = Secrets.ResolveYAML(bytes)
+	if err != nil {
+		return cfg, fmt.Errorf("Failed to resolve secrets in config yaml file: %s", err)
+	}
+
 	err2 := yaml.Unmarshal(bytes, &cfg)
 	if err2 != nil {
 		return cfg, fmt.Errorf("Failed to parse config yaml file: %s", err2)
@@ -108,6 +159,14 @@ func Load(input io.Reader) (Config, error) {
 	if cfg.HTTPS != nil && cfg.HTTPS.Port == 0 {
 		cfg.HTTPS.Port = 443
 	}
+	if cfg.Cache != nil {
+		if cfg.Cache.TTL == 0 {
+			cfg.Cache.TTL = time.Minute
+		}
+		if cfg.Cache.Size == 0 {
+			cfg.Cache.Size = 10000
+		}
+	}
 
 	return cfg, nil
 }
@@ -0,0 +1,444 @@
+package config
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/xphoenix/cerber/signer"
+	"golang.org/x/crypto/pbkdf2"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Certificate extends tls certificate with Yaml unmarshaling methods. Certificate chain is
+// empty if only bare key was configured
+type Certificate struct {
+	tls.Certificate
+}
+
+// UnmarshalYAML decodes tls.Certificate from key/certificate srings
+func (c *Certificate) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	src := KeySource{}
+	err := unmarshal(&src)
+	if err != nil {
+		return err
+	}
+
+	// Decode
+	cert, err := src.Load()
+	if err != nil {
+		return err
+	}
+
+	c.Certificate = cert
+	return nil
+}
+
+// KeySource describes where private key and certificate chain are loaded from. Key and Crt could be
+// either path to the PEM file or PEM content itself, for example resolved from the secret reference.
+// PKCS12 is a path to the bundle or its base64 encoded content. Signer is URL of the external signer
+// holding the key, see signer package. Crt could be omitted, in that case bare key is loaded. If CA
+// bundle is set certificate chain must be valid against it
+type KeySource struct {
+	Key        string `yaml:"key"`
+	Crt        string `yaml:"crt"`
+	PKCS12     string `yaml:"pkcs12"`
+	Signer     string `yaml:"signer"`
+	Passphrase string `yaml:"passphrase"`
+	CA         string `yaml:"ca"`
+}
+
+// Load reads private key along with the certificate chain. Certificate must match the key and be
+// within its validity period
+func (s KeySource) Load() (tls.Certificate, error) {
+	cert, err := s.load()
+	if err != nil {
+		return cert, err
+	}
+
+	if err := s.verify(cert.Certificate, time.Now()); err != nil {
+		return tls.Certificate{}, err
+	}
+	return cert, nil
+}
+
+func (s KeySource) load() (tls.Certificate, error) {
+	var key crypto.Signer
+	switch {
+	case s.PKCS12 != "":
+		if s.Key != "" || s.Crt != "" || s.Signer != "" {
+			return tls.Certificate{}, errors.New("PKCS#12 bundle couldn't be used along with key, crt or signer")
+		}
+		return loadPKCS12(s.PKCS12, s.Passphrase)
+	case s.Signer != "":
+		if s.Key != "" {
+			return tls.Certificate{}, errors.New("Signer couldn't be used along with key")
+		}
+
+		remote, err := signer.Open(s.Signer)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		key = remote
+	case s.Key != "":
+		keyPEM, err := readPEM(s.Key)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+
+		key, err = parsePrivateKey(keyPEM, s.Passphrase)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+	default:
+		return tls.Certificate{}, errors.New("Private key is not configured")
+	}
+
+	cert := tls.Certificate{PrivateKey: key}
+	if s.Crt == "" {
+		return cert, nil
+	}
+
+	crtPEM, err := readPEM(s.Crt)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	for block, rest := pem.Decode(crtPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return tls.Certificate{}, errors.New("No certificate found in crt")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Failed to parse certificate: %s", err)
+	}
+	if !publicKeyMatches(leaf.PublicKey, key) {
+		return tls.Certificate{}, errors.New("Private key doesn't match certificate")
+	}
+
+	cert.Leaf = leaf
+	return cert, nil
+}
+
+// verify checks leaf certificate validity period and the chain against CA bundle if configured
+func (s KeySource) verify(chain [][]byte, now time.Time) error {
+	if len(chain) == 0 {
+		if s.CA != "" {
+			return errors.New("CA bundle is set, but key has no certificate")
+		}
+		return nil
+	}
+
+	certs := make([]*x509.Certificate, len(chain))
+	for i, der := range chain {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("Failed to parse certificate: %s", err)
+		}
+		certs[i] = c
+	}
+
+	leaf := certs[0]
+	if now.Before(leaf.NotBefore) {
+		return fmt.Errorf("Certificate %s is not valid before %s", leaf.Subject.CommonName, leaf.NotBefore.Format(time.RFC3339))
+	} else if now.After(leaf.NotAfter) {
+		return fmt.Errorf("Certificate %s expired at %s", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	if s.CA == "" {
+		return nil
+	}
+
+	bundle, err := readPEM(s.CA)
+	if err != nil {
+		return fmt.Errorf("Failed to read CA bundle: %s", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(bundle) {
+		return errors.New("No certificates found in CA bundle")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs[1:] {
+		intermediates.AddCert(c)
+	}
+
+	_, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("Certificate %s is not trusted by CA bundle: %s", leaf.Subject.CommonName, err)
+	}
+	return nil
+}
+
+// CheckInline returns error if key source refers to files or signers of the server. Sources which
+// are not trusted with server files, such as zones of remote repositories, must carry keys inline
+func (s KeySource) CheckInline() error {
+	fields := []struct{ name, value string }{{"key", s.Key}, {"crt", s.Crt}, {"ca", s.CA}}
+	for _, f := range fields {
+		if f.value != "" && !isInlinePEM(f.value) {
+			return fmt.Errorf("Signing %s must be inline PEM, file paths are not allowed", f.name)
+		}
+	}
+
+	if s.PKCS12 != "" {
+		return errors.New("PKCS#12 bundle is not allowed, use inline key and crt")
+	} else if s.Signer != "" {
+		return errors.New("External signer is not allowed")
+	}
+	return nil
+}
+
+// LoadKeyPair loads certificate and unencrypted private key, see KeySource
+func LoadKeyPair(crt, key string) (tls.Certificate, error) {
+	return KeySource{Key: key, Crt: crt}.Load()
+}
+
+// readPEM returns inline PEM content as is or reads file
+func readPEM(value string) ([]byte, error) {
+	if isInlinePEM(value) {
+		return []byte(value), nil
+	}
+	return ioutil.ReadFile(value)
+}
+
+func isInlinePEM(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN")
+}
+
+// parsePrivateKey decodes first private key found in PEM data. Encrypted PKCS#8 keys and legacy
+// OpenSSL encrypted keys are decrypted with the given passphrase
+func parsePrivateKey(data []byte, passphrase string) (crypto.Signer, error) {
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		der := block.Bytes
+		switch {
+		case block.Type == "ENCRYPTED PRIVATE KEY":
+			if passphrase == "" {
+				return nil, errors.New("Private key is encrypted, but passphrase is not set")
+			}
+			decrypted, err := decryptPKCS8(der, passphrase)
+			if err != nil {
+				return nil, err
+			}
+			return parseDER(decrypted)
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			// Legacy encryption is still produced by openssl genrsa -aes256
+			if x509.IsEncryptedPEMBlock(block) {
+				if passphrase == "" {
+					return nil, errors.New("Private key is encrypted, but passphrase is not set")
+				}
+				decrypted, err := x509.DecryptPEMBlock(block, []byte(passphrase))
+				if err != nil {
+					return nil, errors.New("Failed to decrypt private key, wrong passphrase?")
+				}
+				der = decrypted
+			}
+			return parseDER(der)
+		}
+	}
+	return nil, errors.New("No private key found")
+}
+
+// parseDER decodes PKCS#8, PKCS#1 or SEC 1 private key
+func parseDER(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("Unsupported private key type: %T", key)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("Failed to parse private key")
+}
+
+// publicKeyMatches checks that private key belongs to the public key
+func publicKeyMatches(pub crypto.PublicKey, key crypto.Signer) bool {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return k.Equal(key.Public())
+	case *ecdsa.PublicKey:
+		return k.Equal(key.Public())
+	case ed25519.PublicKey:
+		return k.Equal(key.Public())
+	}
+	return reflect.DeepEqual(pub, key.Public())
+}
+
+// loadPKCS12 decodes key and certificate chain from PKCS#12 bundle file or base64 encoded content
+func loadPKCS12(value, passphrase string) (tls.Certificate, error) {
+	data, err := ioutil.ReadFile(value)
+	if err != nil {
+		decoded, decodeErr := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if decodeErr != nil {
+			return tls.Certificate{}, fmt.Errorf("Failed to read PKCS#12 bundle: %s", err)
+		}
+		data = decoded
+	}
+
+	key, leaf, chain, err := pkcs12.DecodeChain(data, passphrase)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Failed to decode PKCS#12 bundle: %s", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return tls.Certificate{}, fmt.Errorf("Unsupported private key type: %T", key)
+	}
+
+	cert := tls.Certificate{PrivateKey: signer, Leaf: leaf, Certificate: [][]byte{leaf.Raw}}
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return cert, nil
+}
+
+var (
+	oidPBES2      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     algorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc algorithmIdentifier
+	EncryptionScheme  algorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                 `asn1:"optional"`
+	PRF            algorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts PKCS#8 key encrypted with PBES2 scheme, the one openssl pkcs8 -topk8 uses
+// by default
+func decryptPKCS8(der []byte, passphrase string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("Failed to parse encrypted private key: %s", err)
+	} else if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("Unsupported private key encryption: %s", info.Algorithm.Algorithm)
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("Failed to parse PBES2 parameters: %s", err)
+	} else if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("Unsupported key derivation function: %s", params.KeyDerivationFunc.Algorithm)
+	}
+
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("Failed to parse PBKDF2 parameters: %s", err)
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0 || kdf.PRF.Algorithm.Equal(oidHMACSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACSHA256):
+		prf = sha256.New
+	case kdf.PRF.Algorithm.Equal(oidHMACSHA384):
+		prf = sha512.New384
+	case kdf.PRF.Algorithm.Equal(oidHMACSHA512):
+		prf = sha512.New
+	default:
+		return nil, fmt.Errorf("Unsupported PBKDF2 function: %s", kdf.PRF.Algorithm)
+	}
+
+	var keyLen int
+	var newCipher func(key []byte) (cipher.Block, error)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keyLen, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLen, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLen, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLen, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("Unsupported private key cipher: %s", scheme)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, fmt.Errorf("Failed to parse cipher parameters: %s", err)
+	}
+
+	block, err := newCipher(pbkdf2.Key([]byte(passphrase), kdf.Salt, kdf.IterationCount, keyLen, prf))
+	if err != nil {
+		return nil, err
+	}
+
+	data := info.EncryptedData
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("Malformed encrypted private key")
+	}
+
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	// Remove PKCS#7 padding, broken padding most likely means wrong passphrase
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > block.BlockSize() {
+		return nil, errors.New("Failed to decrypt private key, wrong passphrase?")
+	}
+	for _, b := range plain[len(plain)-pad:] {
+		if int(b) != pad {
+			return nil, errors.New("Failed to decrypt private key, wrong passphrase?")
+		}
+	}
+	return plain[:len(plain)-pad], nil
+}
@@ -0,0 +1,247 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Secret references could be used in any string value of the server config and zone files:
+//
+//	${env:NAME}        - value of the environment variable
+//	${file:/path}      - content of the file with trailing newlines removed
+//	${enc:v1:BASE64}   - value sealed with the master key, see Seal
+//
+// Use $${ to write literal ${ sequence. Files of remote sources are resolved by the Sealed resolver,
+// so they couldn't read environment and local files of the server.
+var secretRef = regexp.MustCompile(`\$?\$\{(env|file|enc):([^}]*)\}`)
+
+// MasterKeySize is a size of AES-256 master key used to seal secrets
+const MasterKeySize = 32
+
+// SecretResolver substitutes secret references with their values
+type SecretResolver struct {
+	lock      sync.RWMutex
+	masterKey []byte
+
+	// Resolver sealed values are opened by, env and file references are refused if set
+	sealedBy *SecretResolver
+}
+
+// Secrets is a resolver used by config and zone loading
+var Secrets = &SecretResolver{}
+
+// SetMasterKey sets key used to open sealed values
+func (r *SecretResolver) SetMasterKey(key []byte) error {
+	if len(key) != MasterKeySize {
+		return fmt.Errorf("Master key must be %d bytes long, but it is %d", MasterKeySize, len(key))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.masterKey = key
+	return nil
+}
+
+// Sealed returns resolver which opens sealed values with the master key of this resolver, but
+// refuses env and file references
+func (r *SecretResolver) Sealed() *SecretResolver {
+	return &SecretResolver{sealedBy: r}
+}
+
+// Resolve substitutes all references found in the given string
+func (r *SecretResolver) Resolve(value string) (string, error) {
+	var failure error
+	result := secretRef.ReplaceAllStringFunc(value, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+
+		m := secretRef.FindStringSubmatch(ref)
+		v, err := r.lookup(m[1], m[2])
+		if err != nil && failure == nil {
+			failure = err
+		}
+		return v
+	})
+	return result, failure
+}
+
+func (r *SecretResolver) lookup(kind, arg string) (string, error) {
+	if r.sealedBy != nil {
+		if kind != "enc" {
+			return "", fmt.Errorf("Reference ${%s:...} is not allowed in this source, only sealed values are", kind)
+		}
+		return r.sealedBy.lookup(kind, arg)
+	}
+
+	switch kind {
+	case "env":
+		v, ok := os.LookupEnv(arg)
+		if !ok {
+			return "", fmt.Errorf("Environment variable is not set: %s", arg)
+		}
+		return v, nil
+	case "file":
+		data, err := ioutil.ReadFile(arg)
+		if err != nil {
+			return "", fmt.Errorf("Failed to read secret file: %s", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	default:
+		r.lock.RLock()
+		key := r.masterKey
+		r.lock.RUnlock()
+
+		if key == nil {
+			return "", errors.New("Master key is not configured, sealed value couldn't be opened")
+		}
+		return open(key, arg)
+	}
+}
+
+// ResolveValue substitutes references in all strings of the decoded document: maps, lists and
+// scalars produced by yaml, json or toml decoders
+func (r *SecretResolver) ResolveValue(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case string:
+		return r.Resolve(t)
+	case []interface{}:
+		for i := range t {
+			resolved, err := r.ResolveValue(t[i])
+			if err != nil {
+				return nil, err
+			}
+			t[i] = resolved
+		}
+	case map[string]interface{}:
+		for k := range t {
+			resolved, err := r.ResolveValue(t[k])
+			if err != nil {
+				return nil, err
+			}
+			t[k] = resolved
+		}
+	case map[interface{}]interface{}:
+		for k := range t {
+			resolved, err := r.ResolveValue(t[k])
+			if err != nil {
+				return nil, err
+			}
+			t[k] = resolved
+		}
+	case []map[string]interface{}:
+		for i := range t {
+			if _, err := r.ResolveValue(t[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// ResolveYAML substitutes references in the yaml document. Documents without references are
+// returned as is
+func (r *SecretResolver) ResolveYAML(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte("${")) {
+		return data, nil
+	}
+
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	v, err := r.ResolveValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(v)
+}
+
+// GenerateMasterKey creates random master key
+func GenerateMasterKey() ([]byte, error) {
+	key := make([]byte, MasterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// LoadMasterKey reads hex encoded master key from the file
+func LoadMasterKey(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read master key: %s", err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("Master key must be hex encoded: %s", path)
+	}
+	return key, nil
+}
+
+// Seal encrypts value with the master key and returns reference which could be put into config
+// or zone file
+func Seal(key []byte, value string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(value), nil)
+	return "${enc:v1:" + base64.StdEncoding.EncodeToString(sealed) + "}", nil
+}
+
+// open decrypts value sealed by Seal
+func open(key []byte, arg string) (string, error) {
+	if !strings.HasPrefix(arg, "v1:") {
+		return "", errors.New("Unsupported sealed value version")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(arg[3:])
+	if err != nil {
+		return "", fmt.Errorf("Sealed value is not base64: %s", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return "", errors.New("Sealed value is too short")
+	}
+
+	value, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("Failed to open sealed value, wrong master key?")
+	}
+	return string(value), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid master key: %s", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestResolveSecrets checks env, file and sealed references are substituted
+func TestResolveSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cerber-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "secret")
+	ioutil.WriteFile(file, []byte("from-file\n"), 0600)
+	os.Setenv("CERBER_TEST_SECRET", "from-env")
+	defer os.Unsetenv("CERBER_TEST_SECRET")
+
+	key, _ := GenerateMasterKey()
+	sealed, err := Seal(key, "from-master-key")
+	if err != nil {
+		t.Fatalf("Failed to seal value: %s", err)
+	}
+
+	r := &SecretResolver{}
+	if _, err := r.Resolve(sealed); err == nil {
+		t.Fatal("Expected sealed value to fail without master key")
+	}
+	r.SetMasterKey(key)
+
+	value, err := r.Resolve("${env:CERBER_TEST_SECRET}/${file:" + file + "}/" + sealed + "/$${env:HOME}")
+	if err != nil {
+		t.Fatalf("Failed to resolve: %s", err)
+	} else if value != "from-env/from-file/from-master-key/${env:HOME}" {
+		t.Fatalf("Unexpected value: %s", value)
+	}
+
+	if _, err := r.Resolve("${env:CERBER_TEST_MISSING}"); err == nil {
+		t.Fatal("Expected missing variable to be reported")
+	}
+
+	sealedOnly := r.Sealed()
+	if value, err := sealedOnly.Resolve(sealed); err != nil || value != "from-master-key" {
+		t.Fatalf("Sealed resolver failed to open value: %s %v", value, err)
+	}
+	for _, ref := range []string{"${env:CERBER_TEST_SECRET}", "${file:" + file + "}"} {
+		if _, err := sealedOnly.Resolve(ref); err == nil {
+			t.Fatalf("Expected %s to be refused by sealed resolver", ref)
+		}
+	}
+
+	other, _ := GenerateMasterKey()
+	r.SetMasterKey(other)
+	if _, err := r.Resolve(sealed); err == nil {
+		t.Fatal("Expected sealed value to fail with wrong master key")
+	}
+}
+
+// TestLoadConfigSecrets checks config values are resolved with the configured master key
+func TestLoadConfigSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cerber-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, _ := GenerateMasterKey()
+	keyFile := filepath.Join(dir, "master.key")
+	ioutil.WriteFile(keyFile, []byte(hex.EncodeToString(key)+"\n"), 0600)
+
+	sealed, _ := Seal(key, "ldap://ldap.example.org/dc=example?zone=z.yaml")
+	os.Setenv("CERBER_TEST_REALM", "example.org: test")
+	defer os.Unsetenv("CERBER_TEST_REALM")
+
+	cfg, err := Load(strings.NewReader(`
+master_key_file: ` + keyFile + `
+realm: ${env:CERBER_TEST_REALM}
+providers:
+- "` + sealed + `"
+`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %s", err)
+	}
+
+	if cfg.Realm != "example.org: test" {
+		t.Fatalf("Unexpected realm: %s", cfg.Realm)
+	}
+	if cfg.Providers[0] != "ldap://ldap.example.org/dc=example?zone=z.yaml" {
+		t.Fatalf("Unexpected provider: %s", cfg.Providers[0])
+	}
+}
@@ -0,0 +1,599 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/xphoenix/cerber/api"
+)
+
+// userPayload is a user as admin API accepts and returns it, password hash is never returned
+type userPayload struct {
+	Name     string   `json:"name"`
+	Password string   `json:"password,omitempty"`
+	Groups   []string `json:"groups"`
+
+	// Expiry of temporary memberships, group name to RFC3339 time or date
+	Until map[string]string `json:"until,omitempty"`
+
+	Disabled           bool   `json:"disabled,omitempty"`
+	ExpiresAt          string `json:"expires_at,omitempty"`
+	PasswordMaxAge     string `json:"password_max_age,omitempty"`
+	MustChangePassword bool   `json:"must_change_password,omitempty"`
+
+	// Maintained by password changes, ignored in requests
+	PasswordChangedAt string `json:"password_changed_at,omitempty"`
+}
+
+// newUserPayload converts user of the zone into payload
+func newUserPayload(u *api.User) userPayload {
+	payload := userPayload{
+		Name:               u.Name,
+		Groups:             u.Groups,
+		Until:              u.Until,
+		Disabled:           u.Disabled,
+		ExpiresAt:          u.ExpiresAt,
+		MustChangePassword: u.MustChangePassword,
+		PasswordChangedAt:  u.PasswordChangedAt,
+	}
+	if u.PasswordMaxAge > 0 {
+		payload.PasswordMaxAge = u.PasswordMaxAge.String()
+	}
+	return payload
+}
+
+// user converts payload into the user with the given name, password is not set
+func (p userPayload) user(name string) (api.User, error) {
+	usr := api.User{
+		Name:               name,
+		Groups:             p.Groups,
+		Until:              p.Until,
+		Disabled:           p.Disabled,
+		ExpiresAt:          p.ExpiresAt,
+		MustChangePassword: p.MustChangePassword,
+	}
+
+	if p.PasswordMaxAge != "" {
+		age, err := time.ParseDuration(p.PasswordMaxAge)
+		if err != nil {
+			return usr, fmt.Errorf("Invalid password max age: %s", p.PasswordMaxAge)
+		}
+		usr.PasswordMaxAge = age
+	}
+	return usr, usr.ValidateState()
+}
+
+// memberPayload sets expiry of the membership, either as a time or as a duration from now
+type memberPayload struct {
+	Until    string `json:"until,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+type groupPayload struct {
+	Name    string          `json:"name"`
+	Actions []string        `json:"actions"`
+	When    *api.Conditions `json:"when,omitempty"`
+}
+
+type passwordPayload struct {
+	Password string `json:"password"`
+}
+
+const (
+	// Zone administrator manages users and groups of the zone
+	roleAdmin = "admin"
+
+	// Zone manager manages users of the zone, but couldn't change groups, grant cerber actions or
+	// modify users having them
+	roleManage = "manage"
+)
+
+// adminZone resolves writable zone from the request path and checks token has the required role in
+// it. Error response is written and nil is returned if zone is unknown, couldn't be modified or token
+// has no permission. Denied changes are audited, read only requests pass empty operation
+func adminZone(writer rest.ResponseWriter, request *rest.Request, operation, required string) api.WritableZone {
+	z := managedZone(writer, request, operation, required)
+	if z == nil {
+		return nil
+	}
+
+	w, ok := api.Writable(z)
+	if !ok {
+		adminFailed(writer, request, &api.ReadOnlyError{Zone: z.Name(), Reason: "zone source is read only"})
+		return nil
+	}
+	return w
+}
+
+// managedZone resolves zone from the request path and checks token has the required role in it, zone
+// source could be read only. Error response is written and nil is returned on failure
+func managedZone(writer rest.ResponseWriter, request *rest.Request, operation, required string) api.Zone {
+	role := zoneRole(request, request.PathParam("zone"))
+	if role == "" || (required == roleAdmin && role != roleAdmin) {
+		if operation != "" {
+			audit(request, operation, "", "denied", fmt.Sprintf("%s role is required", required))
+		}
+		Forbidden(writer, request)
+		return nil
+	}
+	request.Env["ZONE_ROLE"] = role
+
+	z, err := Cerber(request).FindZone(request.PathParam("zone"))
+	if err != nil {
+		adminFailed(writer, request, err)
+		return nil
+	}
+	return z
+}
+
+// zoneRole returns the strongest role request token grants in the zone
+func zoneRole(request *rest.Request, zone string) string {
+	token, ok := request.Env["JWT_TOKEN"].(*jwt.Token)
+	if !ok {
+		return ""
+	}
+
+	role := ""
+	for _, granted := range TokenActions(token) {
+		if api.ActionMatches(granted, "cerber:zone/"+zone+":"+roleAdmin) {
+			return roleAdmin
+		} else if api.ActionMatches(granted, "cerber:zone/"+zone+":"+roleManage) {
+			role = roleManage
+		}
+	}
+	return role
+}
+
+// delegated checks zone manager doesn't grant cerber actions to the user with the given groups, nor
+// modifies user already having them. Forbidden response is written and false is returned otherwise
+func delegated(writer rest.ResponseWriter, request *rest.Request, z api.WritableZone, operation, user string, groups []string) bool {
+	if request.Env["ZONE_ROLE"] != roleManage {
+		return true
+	}
+
+	if usr, err := z.FindUser(user); err == nil {
+		groups = append(append([]string{}, groups...), usr.Groups...)
+	}
+	for _, name := range groups {
+		if grp, err := z.FindGroup(name); err == nil && privileged(grp, z.Name()) {
+			audit(request, operation, user, "denied", fmt.Sprintf("group %s grants cerber actions", name))
+			Forbidden(writer, request)
+			return false
+		}
+	}
+	return true
+}
+
+// privileged checks group grants administration of cerber or of the zone, or any other action on
+// cerber itself
+func privileged(grp *api.Group, zone string) bool {
+	required := []string{"cerber:admin", "cerber:zone/" + zone + ":" + roleAdmin, "cerber:zone/" + zone + ":" + roleManage}
+	for _, a := range grp.Actions {
+		if api.IsCerberResource(a) {
+			return true
+		}
+		for _, r := range required {
+			if api.ActionMatches(a, r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// audited records result of the zone change and writes error response if change failed
+func audited(writer rest.ResponseWriter, request *rest.Request, operation, target string, err error) bool {
+	if err != nil {
+		audit(request, operation, target, "failed", err.Error())
+		adminFailed(writer, request, err)
+		return false
+	}
+
+	audit(request, operation, target, "success", "")
+	return true
+}
+
+// audit records zone change requested by the token owner
+func audit(request *rest.Request, operation, target, result, reason string) {
+	e := api.AuditEvent{
+		Zone:      request.PathParam("zone"),
+		Operation: operation,
+		Target:    target,
+		Result:    result,
+		Reason:    reason,
+	}
+
+	if token, ok := request.Env["JWT_TOKEN"].(*jwt.Token); ok {
+		e.Actor, _ = token.Claims["id"].(string)
+		e.ActorZone, _ = token.Claims["aud"].(string)
+		e.Role = zoneRole(request, e.Zone)
+	}
+	Cerber(request).Audit(e)
+}
+
+// adminFailed maps zone errors to response codes
+func adminFailed(writer rest.ResponseWriter, request *rest.Request, err error) {
+	Logger(request).WithField("reason", err).Warn("Admin request failed")
+
+	switch err.(type) {
+	case *api.NotFoundError:
+		rest.Error(writer, err.Error(), http.StatusNotFound)
+	case *api.ExistsError, *api.ReadOnlyError:
+		rest.Error(writer, err.Error(), http.StatusConflict)
+	case *api.UnavailableError, *api.ConflictError:
+		Unavailable(writer, request, err)
+	default:
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// hashPassword hashes password with the zone hasher
+func hashPassword(z api.Zone, passwd string) (string, error) {
+	if passwd == "" {
+		return "", errors.New("Password is required")
+	}
+	return z.HashPassword(passwd)
+}
+
+// ListUsers is a rest handler function that returns all users of the zone
+func ListUsers(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "", roleManage)
+	if z == nil {
+		return
+	}
+
+	users, err := z.Users()
+	if err != nil {
+		adminFailed(writer, request, err)
+		return
+	}
+
+	result := make([]userPayload, len(users))
+	for i := range users {
+		result[i] = newUserPayload(&users[i])
+	}
+	writer.WriteJson(result)
+}
+
+// GetUser is a rest handler function that returns user of the zone
+func GetUser(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "", roleManage)
+	if z == nil {
+		return
+	}
+
+	u, err := z.FindUser(request.PathParam("user"))
+	if err != nil {
+		adminFailed(writer, request, err)
+		return
+	}
+	writer.WriteJson(newUserPayload(u))
+}
+
+// CreateUser is a rest handler function that adds user with the given password and groups
+func CreateUser(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "create_user", roleManage)
+	if z == nil {
+		return
+	}
+
+	payload := userPayload{}
+	if err := request.DecodeJsonPayload(&payload); err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	usr, err := payload.user(payload.Name)
+	if err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !delegated(writer, request, z, "create_user", "", usr.Groups) {
+		return
+	}
+
+	usr.Passwd, err = hashPassword(z, payload.Password)
+	if err == nil {
+		err = z.CreateUser(usr)
+	}
+	if !audited(writer, request, "create_user", usr.Name, err) {
+		return
+	}
+
+	writer.WriteHeader(http.StatusCreated)
+	writer.WriteJson(newUserPayload(&usr))
+}
+
+// UpdateUser is a rest handler function that replaces user groups, membership expiries, state and
+// password if it is given
+func UpdateUser(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "update_user", roleManage)
+	if z == nil {
+		return
+	}
+
+	payload := userPayload{}
+	if err := request.DecodeJsonPayload(&payload); err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	usr, err := payload.user(request.PathParam("user"))
+	if err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !delegated(writer, request, z, "update_user", usr.Name, usr.Groups) {
+		return
+	}
+
+	if payload.Password != "" {
+		usr.Passwd, err = hashPassword(z, payload.Password)
+	}
+	if err == nil {
+		err = z.UpdateUser(usr)
+	}
+	if !audited(writer, request, "update_user", usr.Name, err) {
+		return
+	}
+	writer.WriteJson(newUserPayload(&usr))
+}
+
+// DeleteUser is a rest handler function that removes user from the zone
+func DeleteUser(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "delete_user", roleManage)
+	if z == nil {
+		return
+	}
+
+	user := request.PathParam("user")
+	if !delegated(writer, request, z, "delete_user", user, nil) {
+		return
+	}
+
+	if !audited(writer, request, "delete_user", user, z.DeleteUser(user)) {
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// SetPassword is a rest handler function that replaces user password with the given one
+func SetPassword(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "set_password", roleManage)
+	if z == nil {
+		return
+	}
+
+	payload := passwordPayload{}
+	if err := request.DecodeJsonPayload(&payload); err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user := request.PathParam("user")
+	if !delegated(writer, request, z, "set_password", user, nil) {
+		return
+	}
+
+	hash, err := hashPassword(z, payload.Password)
+	if err == nil {
+		err = z.SetPassword(user, hash)
+	}
+	if !audited(writer, request, "set_password", user, err) {
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// ResetPassword is a rest handler function that replaces user password with the random one and
+// returns it, password is shown only once
+func ResetPassword(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "reset_password", roleManage)
+	if z == nil {
+		return
+	}
+
+	user := request.PathParam("user")
+	if !delegated(writer, request, z, "reset_password", user, nil) {
+		return
+	}
+
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		rest.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	passwd := base64.RawURLEncoding.EncodeToString(random)
+
+	hash, err := hashPassword(z, passwd)
+	if err == nil {
+		err = z.SetPassword(user, hash)
+	}
+	if !audited(writer, request, "reset_password", user, err) {
+		return
+	}
+	writer.WriteJson(passwordPayload{Password: passwd})
+}
+
+// AddMember is a rest handler function that adds user to the group. Optional body makes membership
+// temporary, it expires at the given time or after the given duration
+func AddMember(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "add_member", roleManage)
+	if z == nil {
+		return
+	}
+
+	until, err := memberUntil(request)
+	if err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, group := request.PathParam("user"), request.PathParam("group")
+	if !delegated(writer, request, z, "add_member", user, []string{group}) {
+		return
+	}
+
+	target := user + " to " + group
+	if !until.IsZero() {
+		target += " until " + until.UTC().Format(time.RFC3339)
+	}
+	if !audited(writer, request, "add_member", target, z.AddMember(user, group, until)) {
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// memberUntil returns membership expiry requested by the optional body, zero time if body is empty
+func memberUntil(request *rest.Request) (time.Time, error) {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil || len(bytes.TrimSpace(body)) == 0 {
+		return time.Time{}, err
+	}
+
+	payload := memberPayload{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return time.Time{}, err
+	}
+
+	var until time.Time
+	switch {
+	case payload.Until != "" && payload.Duration != "":
+		return until, errors.New("Either until or duration is expected")
+	case payload.Until != "":
+		until, err = api.ParseUntil(payload.Until)
+	case payload.Duration != "":
+		var d time.Duration
+		if d, err = time.ParseDuration(payload.Duration); err == nil && d <= 0 {
+			err = fmt.Errorf("Duration must be positive: %s", payload.Duration)
+		}
+		until = time.Now().Add(d)
+	}
+	if err != nil {
+		return time.Time{}, err
+	} else if !until.IsZero() && !until.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("Membership expiry is in the past: %s", payload.Until)
+	}
+	return until, nil
+}
+
+// RemoveMember is a rest handler function that removes user from the group
+func RemoveMember(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "remove_member", roleManage)
+	if z == nil {
+		return
+	}
+
+	user, group := request.PathParam("user"), request.PathParam("group")
+	if !delegated(writer, request, z, "remove_member", user, nil) {
+		return
+	}
+
+	if !audited(writer, request, "remove_member", user+" from "+group, z.RemoveMember(user, group)) {
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// ListGroups is a rest handler function that returns all groups of the zone
+func ListGroups(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "", roleManage)
+	if z == nil {
+		return
+	}
+
+	groups, err := z.Groups()
+	if err != nil {
+		adminFailed(writer, request, err)
+		return
+	}
+
+	result := make([]groupPayload, len(groups))
+	for i, g := range groups {
+		result[i] = groupPayload{Name: g.Name, Actions: g.Actions, When: g.When}
+	}
+	writer.WriteJson(result)
+}
+
+// GetGroup is a rest handler function that returns group of the zone
+func GetGroup(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "", roleManage)
+	if z == nil {
+		return
+	}
+
+	g, err := z.FindGroup(request.PathParam("group"))
+	if err != nil {
+		adminFailed(writer, request, err)
+		return
+	}
+	writer.WriteJson(groupPayload{Name: g.Name, Actions: g.Actions, When: g.When})
+}
+
+// CreateGroup is a rest handler function that adds group with the given actions
+func CreateGroup(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "create_group", roleAdmin)
+	if z == nil {
+		return
+	}
+
+	payload := groupPayload{}
+	if err := request.DecodeJsonPayload(&payload); err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := z.CreateGroup(api.Group{Name: payload.Name, Actions: payload.Actions, When: payload.When})
+	if !audited(writer, request, "create_group", payload.Name, err) {
+		return
+	}
+
+	writer.WriteHeader(http.StatusCreated)
+	writer.WriteJson(payload)
+}
+
+// UpdateGroup is a rest handler function that replaces group actions
+func UpdateGroup(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "update_group", roleAdmin)
+	if z == nil {
+		return
+	}
+
+	payload := groupPayload{}
+	if err := request.DecodeJsonPayload(&payload); err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	payload.Name = request.PathParam("group")
+
+	err := z.UpdateGroup(api.Group{Name: payload.Name, Actions: payload.Actions, When: payload.When})
+	if !audited(writer, request, "update_group", payload.Name, err) {
+		return
+	}
+	writer.WriteJson(payload)
+}
+
+// DeleteGroup is a rest handler function that removes group without members
+func DeleteGroup(writer rest.ResponseWriter, request *rest.Request) {
+	z := adminZone(writer, request, "delete_group", roleAdmin)
+	if z == nil {
+		return
+	}
+
+	group := request.PathParam("group")
+	if !audited(writer, request, "delete_group", group, z.DeleteGroup(group)) {
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,127 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/xphoenix/cerber/api"
+)
+
+// TestZoneRole checks role in the zone is resolved from token actions and audited along with the
+// change
+func TestZoneRole(t *testing.T) {
+	cerber, _ := api.New("test")
+	out := &bytes.Buffer{}
+	cerber.AuditLog = out
+
+	access := []interface{}{map[string]interface{}{
+		"type":    "repository",
+		"name":    "cerber",
+		"actions": []interface{}{"zone/registry:manage", "zone/mirror:admin"},
+	}}
+	token := &jwt.Token{Claims: map[string]interface{}{"id": "lead", "aud": "registry", "access": access}}
+
+	r, _ := http.NewRequest("PUT", "http://localhost/admin/zones/registry/users/ci", nil)
+	request := &rest.Request{
+		Request:    r,
+		PathParams: map[string]string{"zone": "registry", "user": "ci"},
+		Env: map[string]interface{}{
+			"LOGGER":    log.WithField("test", true),
+			"CERBER":    cerber,
+			"JWT_TOKEN": token,
+		},
+	}
+
+	for zone, expected := range map[string]string{"registry": roleManage, "mirror": roleAdmin, "other": ""} {
+		if role := zoneRole(request, zone); role != expected {
+			t.Fatalf("Expected %q role in %s, found %q", expected, zone, role)
+		}
+	}
+
+	audit(request, "update_user", "ci", "success", "")
+	e := api.AuditEvent{}
+	if err := json.Unmarshal(out.Bytes(), &e); err != nil {
+		t.Fatalf("Failed to decode audit event: %s", err)
+	} else if e.Zone != "registry" || e.Actor != "lead" || e.Role != roleManage || e.Target != "ci" {
+		t.Fatalf("Unexpected audit event: %+v", e)
+	}
+}
+
+// groupZone is a writable zone stub resolving users and groups only
+type groupZone struct {
+	api.WritableZone
+	users  map[string]*api.User
+	groups map[string]*api.Group
+}
+
+func (z *groupZone) Name() string { return "registry" }
+
+func (z *groupZone) FindUser(userID string) (*api.User, error) {
+	if u, ok := z.users[userID]; ok {
+		return u, nil
+	}
+	return nil, api.NewNotFoundError("user", userID)
+}
+
+func (z *groupZone) FindGroup(groupID string) (*api.Group, error) {
+	if g, ok := z.groups[groupID]; ok {
+		return g, nil
+	}
+	return nil, api.NewNotFoundError("group", groupID)
+}
+
+// statusWriter records response status
+type statusWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *statusWriter) Header() http.Header                      { return w.header }
+func (w *statusWriter) WriteJson(v interface{}) error            { return nil }
+func (w *statusWriter) EncodeJson(v interface{}) ([]byte, error) { return json.Marshal(v) }
+func (w *statusWriter) WriteHeader(status int)                   { w.status = status }
+
+// TestDelegated checks zone manager couldn't assign groups granting administration, while wildcard
+// repository group doesn't make its members administrators
+func TestDelegated(t *testing.T) {
+	cerber, _ := api.New("test")
+	cerber.AuditLog = &bytes.Buffer{}
+
+	z := &groupZone{
+		users: map[string]*api.User{"lead": {Name: "lead"}},
+		groups: map[string]*api.Group{
+			"all":     {Name: "all", Actions: []string{"*:*"}},
+			"ops":     {Name: "ops", Actions: []string{"cerber:zone/*:admin"}},
+			"leads":   {Name: "leads", Actions: []string{"cerber:zone/registry:manage"}},
+			"decider": {Name: "decider", Actions: []string{"cerber:zone/registry:authorize"}},
+		},
+	}
+
+	r, _ := http.NewRequest("PUT", "http://localhost/admin/zones/registry/users/lead/groups/all", nil)
+	request := &rest.Request{
+		Request:    r,
+		PathParams: map[string]string{"zone": "registry", "user": "lead"},
+		Env:        map[string]interface{}{"LOGGER": log.WithField("test", true), "CERBER": cerber, "ZONE_ROLE": roleManage},
+	}
+
+	for group, allowed := range map[string]bool{"all": true, "ops": false, "leads": false, "decider": false} {
+		w := &statusWriter{header: http.Header{}}
+		if delegated(w, request, z, "add_member", "lead", []string{group}) != allowed {
+			t.Fatalf("Expected manager assigning %s to be allowed: %v", group, allowed)
+		} else if !allowed && w.status != http.StatusForbidden {
+			t.Fatalf("Expected 403 for %s, found %d", group, w.status)
+		}
+	}
+
+	// Member of the wildcard group gets no role in the zone
+	access := []interface{}{map[string]interface{}{"type": "repository", "name": "*", "actions": []interface{}{"*"}}}
+	request.Env["JWT_TOKEN"] = &jwt.Token{Claims: map[string]interface{}{"aud": "registry", "access": access}}
+	if role := zoneRole(request, "registry"); role != "" {
+		t.Fatalf("Wildcard group must not grant %s role", role)
+	}
+}
@@ -0,0 +1,147 @@
+package rest
+
+import (
+	"strings"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/xphoenix/cerber/api"
+)
+
+// AccessRule requires one of the actions from tokens calling endpoints matching the path pattern.
+// Pattern segments in braces, such as {zone}, match any single path segment and are substituted into
+// actions, '*' as the last segment matches the rest of the path
+type AccessRule struct {
+	// HTTP methods rule applies to, all methods if empty
+	Methods []string
+	Pattern string
+	Actions []string
+}
+
+// DefaultAccessRules protect admin endpoints: zone administration requires cerber:zone/<name>:admin
+// or cerber:zone/<name>:manage action, handlers restrict what managers could do. Any other admin
+// endpoint requires cerber:admin
+var DefaultAccessRules = []AccessRule{
+	{Pattern: "/admin/zones/{zone}/*", Actions: []string{"cerber:zone/{zone}:admin", "cerber:zone/{zone}:manage"}},
+	{Pattern: "/admin/*", Actions: []string{"cerber:admin"}},
+}
+
+// ZoneAuthorizator authorizes calls of cerber own endpoints with actions from the token access claim.
+// The first rule matching request decides, requests matching no rule are allowed for any valid token
+type ZoneAuthorizator struct {
+	// Zone which tokens are allowed to administer any zone. Token of other zone could be used for
+	// endpoints of its own zone only, endpoints not bound to a zone require management zone token
+	ManagementZone string
+
+	Rules []AccessRule
+}
+
+// Authorize implements CerberMiddleware Authorizator
+func (a *ZoneAuthorizator) Authorize(token *jwt.Token, request *rest.Request) (allowed bool, err error) {
+	rule, params := a.match(request)
+	if rule == nil {
+		return true, nil
+	}
+
+	logger := Logger(request)
+	aud, _ := token.Claims["aud"].(string)
+	if !a.trusted(aud, params["zone"]) {
+		logger.WithField("zone", aud).Warnf("Token zone is not allowed to call %s", request.URL.Path)
+		return false, nil
+	}
+
+	granted := TokenActions(token)
+	for _, action := range rule.Actions {
+		for name, value := range params {
+			action = strings.Replace(action, "{"+name+"}", value, -1)
+		}
+
+		for _, g := range granted {
+			// Only explicit grants of cerber resources allow admin endpoints
+			if api.IsCerberResource(g) && api.ActionMatches(g, action) {
+				request.Env["ACTION"] = action
+				return true, nil
+			}
+		}
+	}
+
+	logger.WithField("actions", strings.Join(rule.Actions, ",")).Warnf("Token has no permission to call %s", request.URL.Path)
+	return false, nil
+}
+
+// trusted checks token of the zone could be used for endpoints of the target zone
+func (a *ZoneAuthorizator) trusted(aud, target string) bool {
+	if a.ManagementZone != "" && strings.EqualFold(aud, a.ManagementZone) {
+		return true
+	}
+	return target != "" && strings.EqualFold(aud, target)
+}
+
+// match returns the first rule matching request along with the path parameters
+func (a *ZoneAuthorizator) match(request *rest.Request) (*AccessRule, map[string]string) {
+	path := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	for i := range a.Rules {
+		rule := &a.Rules[i]
+		if len(rule.Methods) > 0 && !contains(rule.Methods, request.Method) {
+			continue
+		}
+		if params, ok := matchPath(strings.Split(strings.Trim(rule.Pattern, "/"), "/"), path); ok {
+			return rule, params
+		}
+	}
+	return nil, nil
+}
+
+// matchPath matches path segments against the pattern ones
+func matchPath(pattern, path []string) (map[string]string, bool) {
+	params := make(map[string]string)
+	for i, p := range pattern {
+		if p == "*" && i == len(pattern)-1 {
+			return params, len(path) > i
+		} else if i >= len(path) {
+			return nil, false
+		}
+
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			params[p[1:len(p)-1]] = path[i]
+		} else if p != path[i] {
+			return nil, false
+		}
+	}
+	return params, len(path) == len(pattern)
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenActions returns actions of the token access claim in the resource:action form
+func TokenActions(token *jwt.Token) []string {
+	result := make([]string, 0)
+	access, _ := token.Claims["access"].([]interface{})
+	for _, item := range access {
+		p, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// Zone actions apply to repositories only
+		if t, _ := p["type"].(string); t != "" && t != "repository" {
+			continue
+		}
+
+		name, _ := p["name"].(string)
+		actions, _ := p["actions"].([]interface{})
+		for _, a := range actions {
+			if action, ok := a.(string); ok {
+				result = append(result, name+":"+action)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,64 @@
+package rest
+
+import (
+	"net/http"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// TestZoneAuthorizator checks zone admin actions are required from tokens of the zone itself or of
+// the management zone
+func TestZoneAuthorizator(t *testing.T) {
+	a := &ZoneAuthorizator{ManagementZone: "cerber", Rules: DefaultAccessRules}
+
+	token := func(zone string, actions ...string) *jwt.Token {
+		list := make([]interface{}, len(actions))
+		for i, a := range actions {
+			list[i] = a
+		}
+		access := []interface{}{map[string]interface{}{"type": "repository", "name": "cerber", "actions": list}}
+		return &jwt.Token{Claims: map[string]interface{}{"aud": zone, "access": access}}
+	}
+
+	wildcard := func(zone string) *jwt.Token {
+		access := []interface{}{map[string]interface{}{"type": "repository", "name": "*", "actions": []interface{}{"*"}}}
+		return &jwt.Token{Claims: map[string]interface{}{"aud": zone, "access": access}}
+	}
+
+	cases := []struct {
+		token   *jwt.Token
+		method  string
+		path    string
+		allowed bool
+	}{
+		{token("registry", "zone/registry:admin"), "GET", "/admin/zones/registry/users", true},
+		{token("registry", "zone/registry:admin"), "GET", "/admin/zones/mirror/users", false},
+		{token("registry", "zone/*:admin"), "GET", "/admin/zones/mirror/users", false},
+		{token("registry", "zone/registry:admin"), "GET", "/admin/zones", false},
+		{token("cerber", "zone/*:admin"), "DELETE", "/admin/zones/mirror/users/ci", true},
+		{token("cerber", "zone/registry:admin"), "GET", "/admin/zones/mirror/groups", false},
+		{token("cerber", "zone/*:admin"), "GET", "/admin/zones", false},
+		{token("cerber", "admin"), "GET", "/admin/zones", true},
+		{token("registry", "zone/registry:manage"), "PUT", "/admin/zones/registry/users/ci", true},
+		{token("registry", "zone/registry:manage"), "GET", "/admin/zones", false},
+		{token("registry"), "GET", "/token", true},
+		{wildcard("cerber"), "GET", "/admin/zones", false},
+		{wildcard("cerber"), "GET", "/admin/zones/registry/users", false},
+		{wildcard("registry"), "PUT", "/admin/zones/registry/users/ci", false},
+	}
+
+	for _, c := range cases {
+		r, _ := http.NewRequest(c.method, "http://localhost"+c.path, nil)
+		request := &rest.Request{Request: r, Env: map[string]interface{}{"LOGGER": log.WithField("test", true)}}
+
+		allowed, err := a.Authorize(c.token, request)
+		if err != nil {
+			t.Fatalf("Failed to authorize %s %s: %s", c.method, c.path, err)
+		} else if allowed != c.allowed {
+			t.Fatalf("Expected %s %s allowed to be %v for %v", c.method, c.path, c.allowed, c.token.Claims)
+		}
+	}
+}
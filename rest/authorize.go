@@ -0,0 +1,129 @@
+package rest
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/xphoenix/cerber/api"
+)
+
+// authorizeRequest asks whether token or user of the zone is allowed to perform scope action
+type authorizeRequest struct {
+	Token   string `json:"token,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+	Subject string `json:"subject,omitempty"`
+	Scope   string `json:"scope"`
+
+	// Address of the user client group conditions are checked against
+	ClientIP string `json:"client_ip,omitempty"`
+}
+
+type authorizeResponse struct {
+	api.Decision
+	Zone    string `json:"zone"`
+	Subject string `json:"subject"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// AuthorizeAccess is a rest handler function that decides whether token or user of the zone is
+// allowed to perform the requested type:name:action. Decision is made by the same rules as access
+// is granted at login. Users are looked up with the caller token of the same zone which must grant
+// cerber:zone/<name>:authorize action, group conditions are checked against the given client address
+func AuthorizeAccess(writer rest.ResponseWriter, request *rest.Request) {
+	logger, c := Logger(request), Cerber(request)
+
+	payload := authorizeRequest{}
+	if err := request.DecodeJsonPayload(&payload); err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scope, err := api.ParseScope(payload.Scope)
+	if err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	} else if len(scope.Actions) != 1 {
+		rest.Error(writer, "Exactly one action must be requested", http.StatusBadRequest)
+		return
+	}
+
+	response := authorizeResponse{Decision: api.Decision{Scope: scope.String()}, Zone: payload.Zone, Subject: payload.Subject}
+	var granted []string
+	switch {
+	case payload.Token != "":
+		token, err := c.ParseToken(payload.Token)
+		if err == nil {
+			err = c.ValidateToken(token)
+		}
+		if api.IsUnavailable(err) {
+			Unavailable(writer, request, err)
+			return
+		} else if err != nil {
+			response.Reason = "Token is invalid: " + err.Error()
+			writer.WriteJson(response)
+			return
+		}
+		response.Zone, _ = token.Claims["aud"].(string)
+		response.Subject, _ = token.Claims["sub"].(string)
+		granted = TokenActions(token)
+
+	case payload.Zone != "" && payload.Subject != "":
+		caller, err := extractToken(request, c)
+		if api.IsUnavailable(err) {
+			Unavailable(writer, request, err)
+			return
+		} else if err != nil {
+			UnauthorizedJWT(writer, request, err)
+			return
+		} else if !zoneGrants(caller.Claims["aud"], payload.Zone, TokenActions(caller)) {
+			Forbidden(writer, request)
+			return
+		}
+
+		ctx := api.AccessContext{ClientIP: net.ParseIP(payload.ClientIP), Time: time.Now(), Scopes: []api.Scope{scope}}
+		z, err := c.FindZone(payload.Zone)
+		if err == nil {
+			granted, err = c.UserActions(z, payload.Subject, ctx)
+		}
+		if api.IsUnavailable(err) {
+			Unavailable(writer, request, err)
+			return
+		} else if err != nil {
+			response.Reason = err.Error()
+			writer.WriteJson(response)
+			return
+		}
+
+	default:
+		rest.Error(writer, "Token or zone and subject are required", http.StatusBadRequest)
+		return
+	}
+
+	response.Decision = api.Decide(granted, scope.Type, scope.Name, scope.Actions[0])
+	logger.WithFields(log.Fields{
+		"zone":    response.Zone,
+		"subject": response.Subject,
+		"scope":   response.Scope,
+		"allowed": response.Allowed,
+		"rule":    response.Rule,
+	}).Debug("Access decision")
+	writer.WriteJson(response)
+}
+
+// zoneGrants checks caller token of the zone could query decisions for users of the zone
+func zoneGrants(aud interface{}, zone string, actions []string) bool {
+	if name, _ := aud.(string); !strings.EqualFold(name, zone) {
+		return false
+	}
+
+	for _, a := range actions {
+		if api.ActionMatches(a, "cerber:zone/"+zone+":authorize") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package rest
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/xphoenix/cerber/api"
+)
+
+// TrustedProxies are networks of reverse proxies X-Forwarded-For header is accepted from. Client
+// address is the peer address of the connection if empty
+var TrustedProxies []*net.IPNet
+
+// accessContext describes token request for the group conditions and zone policies check
+func accessContext(request *rest.Request) api.AccessContext {
+	return api.AccessContext{ClientIP: clientIP(request), Time: time.Now(), Header: request.Header}
+}
+
+// clientIP returns address of the client, X-Forwarded-For entries are walked from the nearest one
+// while they are trusted proxies
+func clientIP(request *rest.Request) net.IP {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+	forwarded := strings.Split(request.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0 && ip != nil && trustedProxy(ip); i-- {
+		next := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if next == nil {
+			break
+		}
+		ip = next
+	}
+	return ip
+}
+
+func trustedProxy(ip net.IP) bool {
+	for _, n := range TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,37 @@
+package rest
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// TestClientIP checks X-Forwarded-For is accepted from trusted proxies only
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	TrustedProxies = []*net.IPNet{proxies}
+	defer func() { TrustedProxies = nil }()
+
+	cases := []struct {
+		remote, forwarded, expected string
+	}{
+		{"192.168.1.1:5000", "", "192.168.1.1"},
+		{"192.168.1.1:5000", "1.2.3.4", "192.168.1.1"},
+		{"10.0.0.1:5000", "1.2.3.4", "1.2.3.4"},
+		{"10.0.0.1:5000", "1.2.3.4, 10.0.0.2", "1.2.3.4"},
+		{"10.0.0.1:5000", "1.2.3.4, 5.6.7.8", "5.6.7.8"},
+	}
+	for _, c := range cases {
+		r, _ := http.NewRequest("GET", "http://localhost/login", nil)
+		r.RemoteAddr = c.remote
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+
+		if ip := clientIP(&rest.Request{Request: r}); ip.String() != c.expected {
+			t.Fatalf("Expected client %s for %s via %q, found %s", c.expected, c.remote, c.forwarded, ip)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package rest
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/xphoenix/cerber/api"
+)
+
+type explainResponse struct {
+	*api.Explanation
+	Access []permission `json:"access"`
+}
+
+// ExplainUser is a rest handler function that traces how access of the zone user is resolved for
+// the requested scopes: user groups, patterns matched the requested actions and access claim token
+// would get. Group conditions are checked for the client address and time given by ip and at query
+// parameters, current time is used by default. Zone source could be read only
+func ExplainUser(writer rest.ResponseWriter, request *rest.Request) {
+	z := managedZone(writer, request, "", roleManage)
+	if z == nil {
+		return
+	}
+
+	scopes, err := parseScopes(request.URL.Query()["scope"])
+	if err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, err := explainContext(request)
+	if err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e, err := Cerber(request).Explain(z, request.PathParam("user"), scopes, ctx)
+	if err != nil {
+		adminFailed(writer, request, err)
+		return
+	}
+
+	response := explainResponse{Explanation: e, Access: make([]permission, 0)}
+	switch {
+	case e.Error != "":
+		// Login would fail, no token is issued
+	case len(scopes) > 0:
+		response.Access = scopeAccessSet(e.Access)
+	default:
+		if response.Access, err = createAccessSet(e.Granted); err != nil {
+			response.Error = err.Error()
+		}
+	}
+	writer.WriteJson(response)
+}
+
+// explainContext reads token request context from the query parameters
+func explainContext(request *rest.Request) (api.AccessContext, error) {
+	query := request.URL.Query()
+	ctx := api.AccessContext{Time: time.Now()}
+	if ip := query.Get("ip"); ip != "" {
+		if ctx.ClientIP = net.ParseIP(ip); ctx.ClientIP == nil {
+			return ctx, fmt.Errorf("Invalid client address: %s", ip)
+		}
+	}
+	if at := query.Get("at"); at != "" {
+		t, err := time.Parse(time.RFC3339, at)
+		if err != nil {
+			return ctx, fmt.Errorf("Invalid time, RFC3339 is expected: %s", at)
+		}
+		ctx.Time = t
+	}
+	return ctx, nil
+}
@@ -0,0 +1,39 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/xphoenix/cerber/api"
+)
+
+type healthResponse struct {
+	Status       string                  `json:"status"`
+	Providers    []api.ProviderStatus    `json:"providers"`
+	Certificates []api.CertificateStatus `json:"certificates"`
+}
+
+// Health is a rest handler function that reports state of all registered zone
+// providers and zone signing certificates. Response code is 200 if all providers are
+// online and no active certificate is expired and 503 otherwise, so endpoint could be
+// used by load balancer checks
+func Health(writer rest.ResponseWriter, request *rest.Request) {
+	c := Cerber(request)
+
+	resp := healthResponse{Status: "ok", Providers: c.Status(), Certificates: c.Certificates()}
+	for _, p := range resp.Providers {
+		if p.State != api.ProviderOnline {
+			resp.Status = "degraded"
+		}
+	}
+	for _, cert := range resp.Certificates {
+		if cert.State == api.KeyActive && cert.Expired {
+			resp.Status = "degraded"
+		}
+	}
+
+	if resp.Status != "ok" {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writer.WriteJson(resp)
+}
@@ -0,0 +1,102 @@
+package rest
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/xphoenix/cerber/api"
+)
+
+// jwk is a public signing key of the zone in JSON Web Key format along with its rotation state
+type jwk struct {
+	Kid   string   `json:"kid"`
+	Kty   string   `json:"kty"`
+	Use   string   `json:"use"`
+	State string   `json:"state"`
+	Crv   string   `json:"crv,omitempty"`
+	X     string   `json:"x,omitempty"`
+	Y     string   `json:"y,omitempty"`
+	N     string   `json:"n,omitempty"`
+	E     string   `json:"e,omitempty"`
+	X5c   []string `json:"x5c,omitempty"`
+}
+
+type keysResponse struct {
+	Keys []jwk `json:"keys"`
+}
+
+// ZoneKeys is a rest handler function that publishes keys tokens of the zone given in service query
+// parameter are verified with, including pending and retired ones, so relying parties could follow
+// key rotation
+func ZoneKeys(writer rest.ResponseWriter, request *rest.Request) {
+	c := Cerber(request)
+
+	service := request.URL.Query().Get("service")
+	if service == "" {
+		rest.Error(writer, "Service is required", http.StatusBadRequest)
+		return
+	}
+
+	z, err := c.FindZone(service)
+	if api.IsNotFound(err) {
+		rest.NotFound(writer, request)
+		return
+	} else if err != nil {
+		Unavailable(writer, request, err)
+		return
+	}
+
+	keys, err := api.ZoneKeys(z)
+	if err != nil {
+		Unavailable(writer, request, err)
+		return
+	}
+
+	resp := keysResponse{Keys: make([]jwk, 0, len(keys))}
+	for _, k := range keys {
+		key, err := toJWK(k)
+		if err != nil {
+			Logger(request).WithField("kid", k.ID).Warn(err)
+			continue
+		}
+		resp.Keys = append(resp.Keys, key)
+	}
+	writer.WriteJson(resp)
+}
+
+// toJWK encodes RSA or ECDSA public key
+func toJWK(k api.ZoneKey) (jwk, error) {
+	key := jwk{Kid: k.ID, Use: "sig", State: k.State}
+	for _, der := range k.Chain {
+		key.X5c = append(key.X5c, base64.StdEncoding.EncodeToString(der))
+	}
+
+	encode := func(i *big.Int, size int) string {
+		b := i.Bytes()
+		if len(b) < size {
+			b = append(make([]byte, size-len(b)), b...)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = encode(pub.N, 0)
+		key.E = encode(big.NewInt(int64(pub.E)), 0)
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		key.Kty = "EC"
+		key.Crv = pub.Curve.Params().Name
+		key.X = encode(pub.X, size)
+		key.Y = encode(pub.Y, size)
+	default:
+		return key, errors.New("Public key type couldn't be published")
+	}
+	return key, nil
+}
@@ -4,12 +4,14 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
 	"sort"
 	"strings"
 
 	log "github.com/Sirupsen/logrus"
 
 	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/xphoenix/cerber/api"
 )
 
 type permission struct {
@@ -28,18 +30,18 @@ func BasicLogin(writer rest.ResponseWriter, request *rest.Request) {
 	// Get cerber instance
 	logger, c := Logger(request), Cerber(request)
 
-	// Check header
+	// Check header, request without credentials gets actions of the zone anonymous group
+	var providedUserID, providedPassword string
 	authHeader := request.Header.Get("Authorization")
-	if authHeader == "" {
-		UnauthorizedBasic(writer, request, errors.New("Basic qutorization is required"))
-		return
-	}
+	anonymous := authHeader == ""
 
 	// Decode user credentials
-	providedUserID, providedPassword, err := decodeBasicAuthHeader(authHeader)
-	if err != nil {
-		UnauthorizedBasic(writer, request, err)
-		return
+	if !anonymous {
+		var err error
+		if providedUserID, providedPassword, err = decodeBasicAuthHeader(authHeader); err != nil {
+			UnauthorizedBasic(writer, request, err)
+			return
+		}
 	}
 
 	// Parse query string for options
@@ -53,38 +55,68 @@ func BasicLogin(writer rest.ResponseWriter, request *rest.Request) {
 	}
 
 	scope := vals["scope"]
+	scopes, err := parseScopes(scope)
+	if err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if len(scope) == 0 {
 		scope = []string{""}
 	}
 
 	// Use cerber to login
 	logger.WithFields(log.Fields{
-		"zone":     service,
-		"user":     providedUserID,
-		"password": len(providedPassword),
+		"zone":      service,
+		"user":      providedUserID,
+		"password":  len(providedPassword),
+		"anonymous": anonymous,
 	}).Debug("Authentificating user in zone")
 
 	z, err := c.FindZone(service[0])
 	if err != nil {
-		UnauthorizedBasic(writer, request, err)
+		loginFailed(writer, request, err)
 		return
 	}
 
-	providedPassword, err = z.HashPassword(providedPassword)
-	if err != nil {
-		UnauthorizedBasic(writer, request, err)
-		return
+	// Track source revision zone was loaded from
+	if v, ok := api.Underlying(z).(api.Versioned); ok {
+		logger = logger.WithField("revision", v.Revision())
+		request.Env["LOGGER"] = logger
 	}
 
-	// Query zone for user and check password
-	actions, err := c.Authorize(z, providedUserID, providedPassword)
-	if err != nil {
-		UnauthorizedBasic(writer, request, err)
-		return
+	ctx := accessContext(request)
+	ctx.Scopes = scopes
+
+	var actions []string
+	if anonymous {
+		actions, err = c.Anonymous(z, ctx)
+		if api.IsNotFound(err) {
+			UnauthorizedBasic(writer, request, errors.New("Basic authorization is required"))
+			return
+		} else if err != nil {
+			loginFailed(writer, request, err)
+			return
+		}
+	} else {
+		providedPassword, err = z.HashPassword(providedPassword)
+		if err != nil {
+			UnauthorizedBasic(writer, request, err)
+			return
+		}
+
+		// Query zone for user and check password
+		actions, err = c.Authorize(z, providedUserID, providedPassword, ctx)
+		if err != nil {
+			loginFailed(writer, request, err)
+			return
+		}
 	}
 
-	access, err := createAccessSet(actions)
-	if err != nil {
+	// Token grants requested scopes only, all user actions if nothing is requested
+	var access []permission
+	if len(scopes) > 0 {
+		access = scopeAccessSet(api.Intersect(actions, scopes))
+	} else if access, err = createAccessSet(actions); err != nil {
 		UnauthorizedBasic(writer, request, err)
 		return
 	}
@@ -133,6 +165,35 @@ func decodeBasicAuthHeader(header string) (user string, password string, err err
 	return creds[0], creds[1], nil
 }
 
+// parseScopes parses scope query parameters, empty ones are skipped. Docker clients send several
+// scopes as repeated parameters, space separated scopes are accepted as well. Cerber own resources
+// couldn't be requested as scopes, they are granted to tokens requested without scope only
+func parseScopes(values []string) ([]api.Scope, error) {
+	result := make([]api.Scope, 0, len(values))
+	for _, v := range values {
+		for _, s := range strings.Fields(v) {
+			scope, err := api.ParseScope(s)
+			if err != nil {
+				return nil, err
+			} else if api.IsCerberResource(scope.Name + ":") {
+				// Scope cerber:admin has name "cerber", so it is checked along with the action separator
+				return nil, fmt.Errorf("Scope of cerber resource couldn't be requested: '%s'", s)
+			}
+			result = append(result, scope)
+		}
+	}
+	return result, nil
+}
+
+// scopeAccessSet converts granted scopes into the docker distribution access list
+func scopeAccessSet(scopes []api.Scope) []permission {
+	result := make([]permission, len(scopes))
+	for i, s := range scopes {
+		result[i] = permission{s.Type, s.Name, s.Actions}
+	}
+	return result
+}
+
 func createAccessSet(actions []string) ([]permission, error) {
 	perm := permission{"repository", "", make([]string, 0, 1)}
 	result := make([]permission, 0, 2)
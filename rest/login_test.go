@@ -0,0 +1,18 @@
+package rest
+
+import "testing"
+
+// TestParseScopes checks repeated and space separated scopes are accepted, but cerber own resources
+// couldn't be requested
+func TestParseScopes(t *testing.T) {
+	scopes, err := parseScopes([]string{"repository:app:pull repository:lib/db:push", "", "repository:tools:pull"})
+	if err != nil || len(scopes) != 3 {
+		t.Fatalf("Unexpected scopes: %v %v", scopes, err)
+	}
+
+	for _, s := range []string{"repository:cerber:zone/registry:admin", "repository:cerber:admin"} {
+		if _, err := parseScopes([]string{s}); err == nil {
+			t.Fatalf("Expected scope %s to be rejected", s)
+		}
+	}
+}
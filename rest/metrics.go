@@ -0,0 +1,24 @@
+package rest
+
+import (
+	"encoding/json"
+	"expvar"
+
+	"github.com/ant0ine/go-json-rest/rest"
+)
+
+// metricVars are expvar variables /metrics publishes. Endpoint is not authenticated, so process
+// variables such as cmdline and memstats are not exported
+var metricVars = []string{"signing", "certificates"}
+
+// Metrics is a rest handler function that exports cerber expvar variables, such as token signing
+// counters and latency, for monitoring systems
+func Metrics(writer rest.ResponseWriter, request *rest.Request) {
+	vars := make(map[string]json.RawMessage)
+	for _, name := range metricVars {
+		if v := expvar.Get(name); v != nil {
+			vars[name] = json.RawMessage(v.String())
+		}
+	}
+	writer.WriteJson(vars)
+}
@@ -0,0 +1,26 @@
+package rest
+
+import (
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+)
+
+// TestMetrics checks only cerber variables are published
+func TestMetrics(t *testing.T) {
+	a := rest.NewApi()
+	router, _ := rest.MakeRouter(rest.Get("/metrics", Metrics))
+	a.SetApp(router)
+
+	vars := make(map[string]interface{})
+	r := test.RunRequest(t, a.MakeHandler(), test.MakeSimpleRequest("GET", "http://localhost/metrics", nil))
+	r.CodeIs(200)
+	if err := r.DecodeJsonPayload(&vars); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := vars["signing"]; !ok || len(vars) != 2 {
+		t.Fatalf("Expected signing and certificates variables only: %v", vars)
+	}
+}
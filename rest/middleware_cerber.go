@@ -19,7 +19,8 @@ type CerberMiddleware struct {
 	// Could be nil in that case all requests require a valid JWT token to be executed
 	ExceptionSelector func(request *rest.Request) (bypass bool, err error)
 
-	// Authorizator allow request to be processed with given token
+	// Authorizator allow request to be processed with given token, denied request is responded
+	// with 403 error, error returned means token couldn't be checked and is responded with 401.
 	// Could be nil in that case all request contains a valid JWT token are allowed
 	Authorizator func(token *jwt.Token, request *rest.Request) (allowed bool, err error)
 }
@@ -64,14 +65,20 @@ func (mw *CerberMiddleware) MiddlewareFunc(handler rest.HandlerFunc) rest.Handle
 		// Check token if required
 		if !bypass {
 			token, err := extractToken(request, mw.Cerber)
-			if err != nil {
+			if api.IsUnavailable(err) {
+				Unavailable(writer, request, err)
+				return
+			} else if err != nil {
 				UnauthorizedJWT(writer, request, err)
 				return
 			}
 
-			if allowed, err := mw.Authorizator(token, request); !allowed || err != nil {
+			if allowed, err := mw.Authorizator(token, request); err != nil {
 				UnauthorizedJWT(writer, request, err)
 				return
+			} else if !allowed {
+				Forbidden(writer, request)
+				return
 			}
 
 			request.Env["REMOTE_USER"] = token.Claims["id"].(string)
@@ -103,7 +110,8 @@ func Token(request *rest.Request) *jwt.Token {
 }
 
 // Extract token from the request and decode payload
-// by using provided Cerber instance
+// by using provided Cerber instance. Token is rejected if its user
+// is not allowed to use it anymore, see Cerber#ValidateToken
 func extractToken(request *rest.Request, cbr *api.Cerber) (*jwt.Token, error) {
 	authHeader := request.Header.Get("Authorization")
 
@@ -116,5 +124,11 @@ func extractToken(request *rest.Request, cbr *api.Cerber) (*jwt.Token, error) {
 		return nil, errors.New("Invalid auth header")
 	}
 
-	return cbr.ParseToken(parts[1])
+	token, err := cbr.ParseToken(parts[1])
+	if err != nil {
+		return nil, err
+	} else if err := cbr.ValidateToken(token); err != nil {
+		return nil, err
+	}
+	return token, nil
 }
@@ -0,0 +1,95 @@
+package rest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/ant0ine/go-json-rest/rest/test"
+	"github.com/xphoenix/cerber/api"
+	"github.com/xphoenix/cerber/zone"
+)
+
+// TestRevokedToken checks tokens of disabled users and tokens issued before password change are
+// refused by admin API and access decisions
+func TestRevokedToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cerber-revoked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := "name: registry\nhashing: none\nsign:\n  method: ES256\n  generate: true\n  store: private/keys.yaml\n" +
+		"groups:\n- name: admins\n  actions: ['cerber:zone/registry:admin']\n" +
+		"users:\n- name: lead\n  passwd: secret\n  groups: [admins]\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "registry.yaml"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := zone.NewProvider("directory://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, _ := api.New("test")
+	defer c.Stop()
+	if err := c.AddProvider(p); err != nil {
+		t.Fatal(err)
+	}
+
+	authorizator := &ZoneAuthorizator{Rules: DefaultAccessRules}
+	a := rest.NewApi()
+	a.Use(&LogMiddleware{}, &CerberMiddleware{
+		Cerber: c,
+		ExceptionSelector: func(request *rest.Request) (bool, error) {
+			return request.URL.Path == "/login" || request.URL.Path == "/authorize", nil
+		},
+		Authorizator: authorizator.Authorize,
+	})
+	router, _ := rest.MakeRouter(
+		rest.Get("/login", BasicLogin),
+		rest.Post("/authorize", AuthorizeAccess),
+		rest.Get("/admin/zones/#zone/users", ListUsers),
+	)
+	a.SetApp(router)
+	handler := a.MakeHandler()
+
+	request := test.MakeSimpleRequest("GET", "http://localhost/login?service=registry", nil)
+	request.SetBasicAuth("lead", "secret")
+	login := loginResponse{}
+	if err := test.RunRequest(t, handler, request).DecodeJsonPayload(&login); err != nil || login.Token == nil {
+		t.Fatalf("Failed to login: %v", err)
+	}
+
+	check := func(expected int, allowed bool) {
+		request := test.MakeSimpleRequest("GET", "http://localhost/admin/zones/registry/users", nil)
+		request.Header.Set("Authorization", "Bearer "+*login.Token)
+		test.RunRequest(t, handler, request).CodeIs(expected)
+
+		decision := authorizeResponse{}
+		request = test.MakeSimpleRequest("POST", "http://localhost/authorize", authorizeRequest{Token: *login.Token, Scope: "repository:cerber:zone/registry:admin"})
+		if err := test.RunRequest(t, handler, request).DecodeJsonPayload(&decision); err != nil || decision.Allowed != allowed {
+			t.Fatalf("Expected decision to be %v: %+v %v", allowed, decision, err)
+		}
+	}
+	check(http.StatusOK, true)
+
+	z, _ := c.FindZone("registry")
+	w, _ := api.Writable(z)
+	if err := w.UpdateUser(api.User{Name: "lead", Groups: []string{"admins"}, Disabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	check(http.StatusUnauthorized, false)
+
+	// Token issued in the same second as password change is revoked too
+	if err := w.UpdateUser(api.User{Name: "lead", Groups: []string{"admins"}}); err != nil {
+		t.Fatal(err)
+	}
+	check(http.StatusOK, true)
+	if err := w.SetPassword("lead", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	check(http.StatusUnauthorized, false)
+}
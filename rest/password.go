@@ -0,0 +1,74 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/xphoenix/cerber/api"
+)
+
+// changePayload is a new password user sets for itself
+type changePayload struct {
+	Password string `json:"password"`
+}
+
+// ChangePassword is a rest handler function that replaces password of the user authenticated with
+// current credentials in Basic HTTP authentification header. Zone is taken from the service query
+// parameter as on login. Users which password expired or must be changed could use it, tokens issued
+// before the change are revoked
+func ChangePassword(writer rest.ResponseWriter, request *rest.Request) {
+	c := Cerber(request)
+
+	authHeader := request.Header.Get("Authorization")
+	if authHeader == "" {
+		UnauthorizedBasic(writer, request, errors.New("Basic authorization is required"))
+		return
+	}
+
+	user, current, err := decodeBasicAuthHeader(authHeader)
+	if err != nil {
+		UnauthorizedBasic(writer, request, err)
+		return
+	}
+
+	payload := changePayload{}
+	if err := request.DecodeJsonPayload(&payload); err != nil {
+		rest.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	service := request.URL.Query().Get("service")
+	if service == "" {
+		service = request.Host
+	}
+
+	z, err := c.FindZone(service)
+	if err != nil {
+		loginFailed(writer, request, err)
+		return
+	}
+
+	err = c.ChangePassword(z, user, current, payload.Password)
+	e := api.AuditEvent{Zone: z.Name(), Operation: "change_password", Target: user, Actor: user, ActorZone: z.Name(), Result: "success"}
+	switch err := err.(type) {
+	case nil:
+		c.Audit(e)
+		writer.WriteHeader(http.StatusNoContent)
+	case *api.PasswordPolicyError:
+		e.Result, e.Reason = "denied", err.Reason
+		c.Audit(e)
+		writer.WriteHeader(http.StatusBadRequest)
+		writer.WriteJson(codeResponse{Error: err.Error(), Code: "password_policy", Reason: err.Reason})
+	case *api.ReadOnlyError:
+		e.Result, e.Reason = "failed", err.Error()
+		c.Audit(e)
+		rest.Error(writer, err.Error(), http.StatusConflict)
+	case *api.UnavailableError, *api.ConflictError:
+		Unavailable(writer, request, err)
+	default:
+		e.Result, e.Reason = "denied", err.Error()
+		c.Audit(e)
+		UnauthorizedBasic(writer, request, err)
+	}
+}
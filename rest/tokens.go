@@ -1,6 +1,9 @@
 package rest
 
-import "github.com/ant0ine/go-json-rest/rest"
+import (
+	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/xphoenix/cerber/api"
+)
 
 // RefreshToken is a rest handler function that accepts token provided by
 // middleware and generates new token which is full copy of original token
@@ -13,7 +16,10 @@ func RefreshToken(writer rest.ResponseWriter, request *rest.Request) {
 	}
 
 	newToken, err := cerber.RefreshToken(tkn)
-	if err != nil {
+	if api.IsUnavailable(err) {
+		Unavailable(writer, request, err)
+		return
+	} else if err != nil {
 		UnauthorizedJWT(writer, request, err)
 		return
 	}
@@ -23,11 +29,12 @@ func RefreshToken(writer rest.ResponseWriter, request *rest.Request) {
 
 // ValidateToken is a rest handler function that return details of the token
 // provided by middleware. If response of that handler is 200 then given token
-// is valid
+// is valid and its user is still allowed to use it, middleware checks both
 func ValidateToken(writer rest.ResponseWriter, request *rest.Request) {
 	tkn := Token(request)
 	if tkn == nil {
 		UnauthorizedJWT(writer, request, nil)
+		return
 	}
 
 	writer.WriteJson(tkn.Claims)
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/ant0ine/go-json-rest/rest"
+	"github.com/xphoenix/cerber/api"
 )
 
 // UnauthorizedJWT is the rest endpoint that return 401 error with JWT realm
@@ -22,10 +23,47 @@ func UnauthorizedBasic(writer rest.ResponseWriter, request *rest.Request, err er
 	unanauthorized(writer, request, fmt.Sprintf("Basic realm=%s", realm), err)
 }
 
+// codeResponse tells client why request is refused, Code is a machine readable reason such as one of
+// api user state codes
+type codeResponse struct {
+	Error  string `json:"Error"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
 func unanauthorized(writer rest.ResponseWriter, request *rest.Request, realm string, err error) {
 	logger := Logger(request)
 	logger.WithField("reason", err).Error("Request unauthorized")
 
 	writer.Header().Set("WWW-Authenticate", realm)
+	if state, ok := err.(*api.UserStateError); ok {
+		writer.WriteHeader(http.StatusUnauthorized)
+		writer.WriteJson(codeResponse{Error: "Not Authorized", Code: state.Code, Reason: state.Reason})
+		return
+	}
 	rest.Error(writer, "Not Authorized", http.StatusUnauthorized)
 }
+
+// Forbidden is the rest endpoint that return 403 error if token is valid, but has no permission
+// for the request
+func Forbidden(writer rest.ResponseWriter, request *rest.Request) {
+	rest.Error(writer, "Forbidden", http.StatusForbidden)
+}
+
+// Unavailable is the rest endpoint that return 503 error if zone backend failed to answer
+func Unavailable(writer rest.ResponseWriter, request *rest.Request, err error) {
+	logger := Logger(request)
+	logger.WithField("reason", err).Error("Zone backend unavailable")
+
+	rest.Error(writer, "Service Unavailable", http.StatusServiceUnavailable)
+}
+
+// loginFailed responds with 503 error if request failed because of zone backend and with 401 otherwise
+func loginFailed(writer rest.ResponseWriter, request *rest.Request, err error) {
+	switch err.(type) {
+	case *api.UnavailableError, *api.ConflictError:
+		Unavailable(writer, request, err)
+	default:
+		UnauthorizedBasic(writer, request, err)
+	}
+}
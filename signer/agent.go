@@ -0,0 +1,131 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Agent is a software signing agent serving Remote signers. It keeps keys in memory of its own
+// process, so could be used as a stand-in for hardware backed agents in tests and small setups
+type Agent struct {
+	keys map[string]crypto.Signer
+
+	lock     sync.Mutex
+	listener net.Listener
+	wait     sync.WaitGroup
+}
+
+// NewAgent creates agent serving given named keys
+func NewAgent(keys map[string]crypto.Signer) *Agent {
+	return &Agent{keys: keys}
+}
+
+// Listen starts serving requests on the given unix socket, socket file is replaced if exists
+// and is accessible by the owner only
+func (a *Agent) Listen(socket string) error {
+	os.Remove(socket)
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return fmt.Errorf("Failed to listen on signer socket %s: %s", socket, err)
+	}
+
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return err
+	}
+
+	a.lock.Lock()
+	a.listener = l
+	a.lock.Unlock()
+
+	a.wait.Add(1)
+	go a.serve(l)
+	return nil
+}
+
+// Wait blocks until agent is closed
+func (a *Agent) Wait() {
+	a.wait.Wait()
+}
+
+// Close stops accepting new requests
+func (a *Agent) Close() error {
+	a.lock.Lock()
+	l := a.listener
+	a.listener = nil
+	a.lock.Unlock()
+
+	if l == nil {
+		return nil
+	}
+	err := l.Close()
+	a.wait.Wait()
+	return err
+}
+
+func (a *Agent) serve(l net.Listener) {
+	defer a.wait.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go a.handle(conn)
+	}
+}
+
+// handle answers single request of the connection
+func (a *Agent) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	req := request{}
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		log.Warnf("Malformed signer request: %s", err)
+		return
+	}
+
+	resp, err := a.process(req)
+	if err != nil {
+		log.WithField("key", req.Key).Warnf("Signer request '%s' failed: %s", req.Op, err)
+		resp = &response{Error: err.Error()}
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+func (a *Agent) process(req request) (*response, error) {
+	key, ok := a.keys[req.Key]
+	if !ok {
+		return nil, fmt.Errorf("Unknown key: %s", req.Key)
+	}
+
+	switch req.Op {
+	case "public":
+		der, err := x509.MarshalPKIXPublicKey(key.Public())
+		if err != nil {
+			return nil, err
+		}
+		return &response{Public: der}, nil
+	case "sign":
+		for hash, name := range hashes {
+			if name == req.Hash {
+				sig, err := key.Sign(rand.Reader, req.Digest, hash)
+				if err != nil {
+					return nil, err
+				}
+				return &response{Signature: sig}, nil
+			}
+		}
+		return nil, fmt.Errorf("Unsupported digest algorithm: %s", req.Hash)
+	}
+	return nil, fmt.Errorf("Unknown operation: %s", req.Op)
+}
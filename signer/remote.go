@@ -0,0 +1,148 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Remote is a signer which asks signing agent listening on the unix socket to sign digests, so
+// private key never enters Cerber process. Key is selected by name on the agent side:
+//
+//	unix:///run/cerber/signer.sock?key=registry&timeout=2s
+//
+// Each operation uses a new connection with a single JSON request and response. Public key is
+// requested once, when signer is opened
+type Remote struct {
+	socket  string
+	key     string
+	timeout time.Duration
+	public  crypto.PublicKey
+}
+
+// request is sent to the signing agent
+type request struct {
+	Op     string `json:"op"`
+	Key    string `json:"key"`
+	Hash   string `json:"hash,omitempty"`
+	Digest []byte `json:"digest,omitempty"`
+}
+
+// response is returned by the signing agent
+type response struct {
+	Error     string `json:"error,omitempty"`
+	Public    []byte `json:"public,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// hashes are names digest algorithms are transferred with
+var hashes = map[crypto.Hash]string{
+	crypto.SHA1:   "SHA1",
+	crypto.SHA224: "SHA224",
+	crypto.SHA256: "SHA256",
+	crypto.SHA384: "SHA384",
+	crypto.SHA512: "SHA512",
+}
+
+func init() {
+	Register("unix", openRemote)
+}
+
+// openRemote adapts NewRemote to the Factory signature
+func openRemote(u *url.URL) (crypto.Signer, error) {
+	if u.Host != "" {
+		return nil, fmt.Errorf("Signer socket URL shouldn't has host: %s", u.String())
+	}
+
+	timeout := 5 * time.Second
+	if v := u.Query().Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("Invalid signer timeout: %s", v)
+		}
+		timeout = d
+	}
+
+	s, err := NewRemote(u.Path, u.Query().Get("key"), timeout)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewRemote connects to the agent listening on the given socket and requests public part of
+// the named key
+func NewRemote(socket, key string, timeout time.Duration) (*Remote, error) {
+	if key == "" {
+		return nil, errors.New("Signer key name is not set")
+	}
+
+	r := &Remote{socket: socket, key: key, timeout: timeout}
+	resp, err := r.call(request{Op: "public", Key: key})
+	if err != nil {
+		return nil, err
+	}
+
+	r.public, err = x509.ParsePKIXPublicKey(resp.Public)
+	if err != nil {
+		return nil, fmt.Errorf("Signer %s returned malformed public key: %s", r, err)
+	}
+	return r, nil
+}
+
+// String returns signer location for logs
+func (r *Remote) String() string {
+	return "unix://" + r.socket + "?key=" + r.key
+}
+
+// Public returns public part of the remote key
+func (r *Remote) Public() crypto.PublicKey {
+	return r.public
+}
+
+// Sign sends digest to the agent and returns signature. RSA-PSS is not supported
+func (r *Remote) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if _, ok := opts.(*rsa.PSSOptions); ok {
+		return nil, errors.New("RSA-PSS is not supported by remote signer")
+	}
+
+	hash, ok := hashes[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported digest algorithm: %v", opts.HashFunc())
+	}
+
+	resp, err := r.call(request{Op: "sign", Key: r.key, Hash: hash, Digest: digest})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Signature, nil
+}
+
+// call performs single request to the agent
+func (r *Remote) call(req request) (*response, error) {
+	conn, err := net.DialTimeout("unix", r.socket, r.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("Signer %s is unavailable: %s", r, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(r.timeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("Failed to send request to signer %s: %s", r, err)
+	}
+
+	resp := &response{}
+	if err := json.NewDecoder(conn).Decode(resp); err != nil {
+		return nil, fmt.Errorf("Failed to read response of signer %s: %s", r, err)
+	} else if resp.Error != "" {
+		return nil, fmt.Errorf("Signer %s failed: %s", r, resp.Error)
+	}
+	return resp, nil
+}
@@ -0,0 +1,68 @@
+package signer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRemoteSigner checks remote signer against software agent
+func TestRemoteSigner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cerber-signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	socket := filepath.Join(dir, "agent.sock")
+	agent := NewAgent(map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey})
+	if err := agent.Listen(socket); err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte("payload"))
+
+	s, err := Open("unix://" + socket + "?key=rsa")
+	if err != nil {
+		t.Fatalf("Failed to open signer: %s", err)
+	}
+	if !rsaKey.PublicKey.Equal(s.Public()) {
+		t.Fatalf("Unexpected public key: %v", s.Public())
+	}
+	sig, err := s.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Failed to sign: %s", err)
+	}
+	if err := rsa.VerifyPKCS1v15(&rsaKey.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Fatalf("Invalid signature: %s", err)
+	}
+
+	ec, err := Open("unix://" + socket + "?key=ec")
+	if err != nil {
+		t.Fatalf("Failed to open signer: %s", err)
+	}
+	sig, err = ec.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil || !ecdsa.VerifyASN1(&ecKey.PublicKey, digest[:], sig) {
+		t.Fatalf("Invalid ECDSA signature: %s", err)
+	}
+
+	if _, err := Open("unix://" + socket + "?key=missing"); err == nil || !strings.Contains(err.Error(), "Unknown key") {
+		t.Fatalf("Expected unknown key error: %v", err)
+	}
+
+	agent.Close()
+	if _, err := s.Sign(rand.Reader, digest[:], crypto.SHA256); err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Fatalf("Expected unavailable error: %v", err)
+	}
+}
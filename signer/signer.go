@@ -0,0 +1,63 @@
+// Package signer provides crypto.Signer backends, so zone private keys could be kept outside
+// of the Cerber process, for example by a signing agent or hardware module
+package signer
+
+import (
+	"crypto"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// Factory opens signer described by the given URL
+type Factory func(u *url.URL) (crypto.Signer, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = make(map[string]Factory)
+)
+
+// Register makes signer backend available under the given URL scheme. Registering the same
+// scheme twice panics
+func Register(scheme string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+
+	if factory == nil {
+		panic("Signer factory is nil: " + scheme)
+	} else if _, ok := factories[scheme]; ok {
+		panic("Signer scheme is already registered: " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// Schemes returns sorted list of registered signer schemes
+func Schemes() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open creates signer for the given URL using factory registered for its scheme
+func Open(location string) (crypto.Signer, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid signer URL %s: %s", location, err)
+	}
+
+	factoriesLock.RLock()
+	factory, ok := factories[u.Scheme]
+	factoriesLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unsupported signer scheme: %s", u.Scheme)
+	}
+	return factory(u)
+}
@@ -0,0 +1,253 @@
+package zone
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xphoenix/cerber/api"
+	"github.com/xphoenix/cerber/config"
+)
+
+// CachingProvider decorates another provider and caches found zones along with their users
+// and groups, so slow backends are not queried on every request. Lookups of unknown entries are
+// remembered for NegativeTTL, other failures such as unavailable backend are never cached. If decorated provider implements api.Watcher cached entries of the zone are
+// dropped as soon as the zone changes
+type CachingProvider struct {
+	api.Provider
+
+	cfg        config.CacheConfig
+	cache      *lruCache
+	generation uint64
+}
+
+// NewCachingProvider wraps given provider with cache
+func NewCachingProvider(p api.Provider, cfg config.CacheConfig) *CachingProvider {
+	c := &CachingProvider{
+		Provider: p,
+		cfg:      cfg,
+		cache:    newLRUCache(cfg.Size),
+	}
+
+	if w, ok := p.(api.Watcher); ok {
+		w.Watch(c.Invalidate)
+	}
+	return c
+}
+
+// FindZone returns cached zone or queries decorated provider
+func (c *CachingProvider) FindZone(name string) (api.Zone, error) {
+	key := "zone:" + strings.ToUpper(name)
+	if v, err, ok := c.cache.get(key); ok {
+		if err != nil {
+			return nil, err
+		}
+		return v.(api.Zone), nil
+	}
+
+	z, err := c.Provider.FindZone(name)
+	if err != nil {
+		if api.IsNotFound(err) {
+			c.cache.put(key, nil, err, c.cfg.NegativeTTL)
+		}
+		return nil, err
+	}
+
+	// Every zone instance gets own key space, so lookups made through outdated
+	// instance never pollute cache of the reloaded zone
+	cz := &cachedZone{
+		Zone:   z,
+		cache:  c.cache,
+		prefix: fmt.Sprintf("%s#%d:", key, atomic.AddUint64(&c.generation, 1)),
+		cfg:    c.cfg,
+	}
+	c.cache.put(key, cz, nil, c.cfg.TTL)
+	return cz, nil
+}
+
+// Revision returns source revision of the decorated provider, empty if it is not versioned
+func (c *CachingProvider) Revision() string {
+	if v, ok := c.Provider.(api.Versioned); ok {
+		return v.Revision()
+	}
+	return ""
+}
+
+// Invalidate drops cached zone along with its users and groups
+func (c *CachingProvider) Invalidate(name string) {
+	key := "zone:" + strings.ToUpper(name)
+	c.cache.remove(key)
+	c.cache.removePrefix(key + "#")
+}
+
+// Stop stops decorated provider and clears cache
+func (c *CachingProvider) Stop() error {
+	err := c.Provider.Stop()
+	c.cache.clear()
+	return err
+}
+
+// cachedZone caches user and group lookups of the decorated zone
+type cachedZone struct {
+	api.Zone
+
+	cache  *lruCache
+	prefix string
+	cfg    config.CacheConfig
+}
+
+// Unwrap returns decorated zone
+func (z *cachedZone) Unwrap() api.Zone {
+	return z.Zone
+}
+
+// FindUser returns cached user or queries decorated zone
+func (z *cachedZone) FindUser(userID string) (*api.User, error) {
+	key := z.prefix + "user:" + userID
+	if v, err, ok := z.cache.get(key); ok {
+		if err != nil {
+			return nil, err
+		}
+		usr := *v.(*api.User)
+		return &usr, nil
+	}
+
+	usr, err := z.Zone.FindUser(userID)
+	if err != nil {
+		if api.IsNotFound(err) {
+			z.cache.put(key, nil, err, z.cfg.NegativeTTL)
+		}
+		return nil, err
+	}
+
+	z.cache.put(key, usr, nil, z.cfg.TTL)
+	cp := *usr
+	return &cp, nil
+}
+
+// FindGroup returns cached group or queries decorated zone
+func (z *cachedZone) FindGroup(groupID string) (*api.Group, error) {
+	key := z.prefix + "group:" + groupID
+	if v, err, ok := z.cache.get(key); ok {
+		if err != nil {
+			return nil, err
+		}
+		grp := *v.(*api.Group)
+		return &grp, nil
+	}
+
+	grp, err := z.Zone.FindGroup(groupID)
+	if err != nil {
+		if api.IsNotFound(err) {
+			z.cache.put(key, nil, err, z.cfg.NegativeTTL)
+		}
+		return nil, err
+	}
+
+	z.cache.put(key, grp, nil, z.cfg.TTL)
+	cp := *grp
+	return &cp, nil
+}
+
+// lruCache is a size limited cache of values or errors, least recently used entries
+// are evicted first
+type lruCache struct {
+	size int
+
+	lock  sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+type lruEntry struct {
+	key     string
+	value   interface{}
+	err     error
+	expires time.Time
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:  size,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// get returns value or error stored for the key, last result is false if there is no alive entry
+func (c *lruCache) get(key string) (interface{}, error, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, nil, false
+	}
+
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return nil, nil, false
+	}
+
+	c.order.MoveToFront(el)
+	return e.value, e.err, true
+}
+
+// put stores value or error for the given time, non positive ttl means do not cache
+func (c *lruCache) put(key string, value interface{}, err error, ttl time.Duration) {
+	if ttl <= 0 || c.size <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry := &lruEntry{key: key, value: value, err: err, expires: time.Now().Add(ttl)}
+	if el, ok := c.items[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.items, last.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lruCache) remove(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+func (c *lruCache) removePrefix(prefix string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for key, el := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(el)
+			delete(c.items, key)
+		}
+	}
+}
+
+func (c *lruCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+}
@@ -0,0 +1,140 @@
+package zone
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xphoenix/cerber/api"
+	"github.com/xphoenix/cerber/config"
+)
+
+// countingProvider serves fixed zones and counts lookups
+type countingProvider struct {
+	zones   map[string]*yamlZone
+	lookups int
+
+	// Error every lookup fails with if set
+	err error
+
+	watchers
+}
+
+func (p *countingProvider) URL() *url.URL           { return &url.URL{Scheme: "test"} }
+func (p *countingProvider) IsOnline() (bool, error) { return true, nil }
+func (p *countingProvider) Start() error            { return nil }
+func (p *countingProvider) Stop() error             { return nil }
+func (p *countingProvider) Zones() []string         { return nil }
+
+func (p *countingProvider) FindZone(name string) (api.Zone, error) {
+	p.lookups++
+	if p.err != nil {
+		return nil, p.err
+	}
+	z, ok := p.zones[strings.ToUpper(name)]
+	if !ok {
+		return nil, api.NewNotFoundError("zone", name)
+	}
+	return z, nil
+}
+
+// TestCachingProvider checks positive, negative caching and invalidation by watcher
+func TestCachingProvider(t *testing.T) {
+	p := &countingProvider{zones: map[string]*yamlZone{
+		"REGISTRY": {ZName: "registry", ZUsers: []api.User{{Name: "admin", Passwd: "x"}}},
+	}}
+	c := NewCachingProvider(p, config.CacheConfig{TTL: time.Minute, NegativeTTL: time.Minute, Size: 10})
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.FindZone("registry"); err != nil {
+			t.Fatalf("Failed to find zone: %s", err)
+		}
+		if _, err := c.FindZone("unknown"); err == nil {
+			t.Fatal("Expected unknown zone to be not found")
+		}
+	}
+
+	if p.lookups != 2 {
+		t.Fatalf("Expected 2 provider lookups but found: %d", p.lookups)
+	}
+
+	z, _ := c.FindZone("registry")
+	if api.Underlying(z) != p.zones["REGISTRY"] {
+		t.Fatal("Expected cached zone to unwrap into the provider zone")
+	}
+
+	// Zone reloaded by provider
+	p.zones["REGISTRY"] = &yamlZone{ZName: "registry"}
+	p.notify("REGISTRY")
+
+	z, _ = c.FindZone("registry")
+	if p.lookups != 3 {
+		t.Fatalf("Expected zone to be looked up after invalidation, lookups: %d", p.lookups)
+	}
+	if _, err := z.FindUser("admin"); err == nil {
+		t.Fatal("Expected user of the reloaded zone to be not found")
+	}
+}
+
+// flakyZone fails user lookups with the error if set
+type flakyZone struct {
+	*yamlZone
+	err error
+}
+
+func (z *flakyZone) FindUser(userID string) (*api.User, error) {
+	if z.err != nil {
+		return nil, z.err
+	}
+	return z.yamlZone.FindUser(userID)
+}
+
+// TestCachingOutage checks failures of unavailable backend are not cached, so lookups succeed as
+// soon as backend recovers
+func TestCachingOutage(t *testing.T) {
+	outage := &api.UnavailableError{Source: "test", Err: errors.New("connection refused")}
+	p := &countingProvider{zones: map[string]*yamlZone{"REGISTRY": {ZName: "registry"}}, err: outage}
+	cfg := config.CacheConfig{TTL: time.Minute, NegativeTTL: time.Minute, Size: 10}
+	c := NewCachingProvider(p, cfg)
+
+	if _, err := c.FindZone("registry"); !api.IsUnavailable(err) {
+		t.Fatalf("Expected backend outage, found: %v", err)
+	}
+	p.err = nil
+	if _, err := c.FindZone("registry"); err != nil {
+		t.Fatalf("Zone must be found after backend recovered: %s", err)
+	}
+
+	flaky := &flakyZone{yamlZone: &yamlZone{ZName: "registry", ZUsers: []api.User{{Name: "admin"}}}, err: outage}
+	z := &cachedZone{Zone: flaky, cache: newLRUCache(10), prefix: "zone:REGISTRY#1:", cfg: cfg}
+	if _, err := z.FindUser("admin"); !api.IsUnavailable(err) {
+		t.Fatalf("Expected backend outage, found: %v", err)
+	}
+	flaky.err = nil
+	if _, err := z.FindUser("admin"); err != nil {
+		t.Fatalf("User must be found after backend recovered: %s", err)
+	}
+}
+
+// TestLRUCacheSize checks least recently used entries are evicted first
+func TestLRUCacheSize(t *testing.T) {
+	c := newLRUCache(2)
+	c.put("a", 1, nil, time.Minute)
+	c.put("b", 2, nil, time.Minute)
+	c.get("a")
+	c.put("c", 3, nil, time.Minute)
+
+	if _, _, ok := c.get("b"); ok {
+		t.Fatal("Expected b to be evicted")
+	}
+	if v, _, ok := c.get("a"); !ok || v.(int) != 1 {
+		t.Fatal("Expected a to stay in cache")
+	}
+
+	c.put("d", 4, nil, -time.Second)
+	if _, _, ok := c.get("d"); ok {
+		t.Fatal("Expected entry with non positive ttl to be not cached")
+	}
+}
@@ -7,19 +7,60 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-
-	"gopkg.in/yaml.v2"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/xphoenix/cerber/api"
 )
 
-// DirectoryProvider watch given directory for yaml/json zone descriptions and
-// load/remove Zones when file changes
+// DirectoryProvider watch given directory for yaml/json/toml zone descriptions and
+// load/remove Zones when file changes. Hidden files, editor backups and files matching
+// patterns from 'ignore' query parameter are skipped. Zones loaded from yaml files are
+// writable, see api.WritableZone
 type DirectoryProvider struct {
-	url *url.URL
+	url    *url.URL
+	filter *fileFilter
+
+	lock      sync.RWMutex
+	writeLock sync.Mutex
+	zones     map[string]api.Zone
+
+	watchers
+	lifecycle
+}
+
+func init() {
+	RegisterProviderFactory("directory", newDirectoryProvider)
+}
+
+// newDirectoryProvider checks given URL points to the existing directory
+func newDirectoryProvider(u *url.URL) (api.Provider, error) {
+	// Check that URL has no hash & unknown query parameters
+	query := u.Query()
+	filter, err := newFileFilter(query.Get("ignore"))
+	if err != nil {
+		return nil, err
+	}
+
+	query.Del("ignore")
+	if u.Fragment != "" || len(query) > 0 {
+		return nil, fmt.Errorf("Directory URL shouldn't has fragmanet or query parts: %s", u.String())
+	}
+
+	// Check if path exists and is a directory
+	// TODO: permission checks?
+	fileInfo, err := os.Stat(u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Error during access specified path: %s", err)
+	} else if !fileInfo.IsDir() {
+		return nil, fmt.Errorf("Given path is not directory: %s", u.Path)
+	}
 
-	zones map[string]api.Zone
+	return &DirectoryProvider{
+		url:    u,
+		filter: filter,
+		zones:  make(map[string]api.Zone, 0),
+	}, nil
 }
 
 // URL returns URI for the current Provider. Protocol must be
@@ -39,44 +80,18 @@ func (d *DirectoryProvider) Start() error {
 	// It is important to start inotify first to not skip updates happens in between
 	// directory read & inotify initialization
 
-	files, err := ioutil.ReadDir(d.url.Path)
+	set, err := d.load()
 	if err != nil {
-		return fmt.Errorf("Failed to list directory: %s", d.url.Path)
+		d.setState(false, err)
+		return err
 	}
 
-	// Load all zones
-	for _, f := range files {
-		if f.IsDir() {
-			continue
-		}
-
-		log.Infof("Loading zone file: %s", f.Name())
-		fullPath := filepath.Join(d.url.Path, f.Name())
-
-		fd, err := os.Open(fullPath)
-		if err != nil {
-			return fmt.Errorf("Failed to open file: %s", fullPath)
-		}
-
-		bytes, err := ioutil.ReadAll(fd)
-		if err != nil {
-			return fmt.Errorf("Failed to read file: %s", fullPath)
-		}
-
-		z := yamlZone{}
-		parseErr := yaml.Unmarshal(bytes, &z)
-		if parseErr != nil {
-			return fmt.Errorf("Failed to parse file: %s %s", fullPath, parseErr)
-		}
+	d.lock.Lock()
+	d.zones = set.zones
+	d.lock.Unlock()
 
-		i, err := d.FindZone(z.Name())
-		if err == nil {
-			return fmt.Errorf("Found duplicated zone: %s (%s)", i.Name(), i.Description())
-		}
-
-		// Save zone
-		d.registerZone(&z)
-	}
+	// Broken files are reported, but do not prevent other zones from being served
+	d.setState(true, set.err())
 	return nil
 }
 
@@ -85,28 +100,70 @@ func (d *DirectoryProvider) Start() error {
 // started then method returns without any actual work
 // After was stopped Provider returns no zones
 func (d *DirectoryProvider) Stop() error {
-	return nil
-}
+	d.lock.Lock()
+	d.zones = make(map[string]api.Zone)
+	d.lock.Unlock()
 
-// IsOnline returns true if provider was started successfully and running,
-// without errors. If method returns true then zone information available
-// for readers
-func (d *DirectoryProvider) IsOnline() (bool, error) {
-	return false, nil
+	d.setState(false, nil)
+	return nil
 }
 
 // FindZone returns first available zone known by the current Provider and has given name
 func (d *DirectoryProvider) FindZone(name string) (api.Zone, error) {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
 	name = strings.ToUpper(name)
 	z, ok := d.zones[name]
 	if !ok {
-		return nil, fmt.Errorf("There is no zone with the given name: %s", name)
+		return nil, api.NewNotFoundError("zone", name)
 	}
 
 	return z, nil
 }
 
-func (d *DirectoryProvider) registerZone(z api.Zone) {
-	name := strings.ToUpper(z.Name())
-	d.zones[name] = z
+// Zones returns names of all loaded zones
+func (d *DirectoryProvider) Zones() []string {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return zoneNames(d.zones)
+}
+
+// load reads all zone files from the directory. Error returns only if directory itself couldn't be read
+func (d *DirectoryProvider) load() (*zoneSet, error) {
+	files, err := ioutil.ReadDir(d.url.Path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list directory: %s", d.url.Path)
+	}
+
+	// Load all zones
+	sources := make([]zoneSource, 0, len(files))
+	set := newZoneSet(osFiles{})
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		} else if !d.filter.accept(f.Name()) {
+			log.Debugf("Skip file: %s", f.Name())
+			continue
+		}
+
+		log.Infof("Loading zone file: %s", f.Name())
+		fullPath := filepath.Join(d.url.Path, f.Name())
+
+		data, err := readFile(fullPath)
+		if err != nil {
+			set.fail(fullPath, err)
+			continue
+		}
+		sources = append(sources, zoneSource{name: fullPath, data: data, wrap: d.wrapper(fullPath)})
+	}
+	set.addAll(sources)
+	return set, nil
+}
+
+// wrapper makes zones of the given file writable
+func (d *DirectoryProvider) wrapper(source string) func(z *yamlZone) api.Zone {
+	return func(z *yamlZone) api.Zone {
+		return &localZone{yamlZone: z, source: source, provider: d}
+	}
 }
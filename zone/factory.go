@@ -1,14 +1,54 @@
 package zone
 
 import (
-	"errors"
 	"fmt"
 	"net/url"
-	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/xphoenix/cerber/api"
 )
 
+// ProviderFactory creates Zone Provider for the given URL. Factory is expected to validate
+// URL and return error if it couldn't be served
+type ProviderFactory func(u *url.URL) (api.Provider, error)
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = make(map[string]ProviderFactory)
+)
+
+// RegisterProviderFactory makes Zone Provider available for URLs with the given scheme. It is
+// expected to be called from init function of the package implementing provider, so custom
+// providers could be plugged in by import. Registering the same scheme twice panics
+func RegisterProviderFactory(scheme string, factory ProviderFactory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+
+	key := strings.ToLower(scheme)
+	if factory == nil {
+		panic("Zone Provider factory is nil: " + scheme)
+	} else if _, ok := factories[key]; ok {
+		panic("Zone Provider factory is already registered: " + scheme)
+	}
+	factories[key] = factory
+}
+
+// ProviderSchemes returns sorted list of URL schemes Zone Providers are registered for
+func ProviderSchemes() []string {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
 // NewProvider creates Zone Provider object based on given URL.
 func NewProvider(loc string) (api.Provider, error) {
 	u, err := url.Parse(loc)
@@ -16,34 +56,36 @@ func NewProvider(loc string) (api.Provider, error) {
 		return nil, fmt.Errorf("Failed to parse Cerber Zone Provider URL: %s", err)
 	}
 
-	switch u.Scheme {
-	case "directory":
-		// Check that URL has no hash & query
-		if u.Fragment != "" || len(u.Query()) > 0 {
-			return nil, fmt.Errorf("Directory URL shouldn't has fragmanet or query parts: %s", u.String())
-		}
-
-		// Check if path exists and is a directory
-		// TODO: permission checks?
-		fileInfo, err := os.Stat(u.Path)
-		if err != nil {
-			return nil, fmt.Errorf("Error during access specified path: %s", err)
-		} else if !fileInfo.IsDir() {
-			return nil, fmt.Errorf("Given path is not directory: %s", u.Path)
-		}
-
-		return &DirectoryProvider{
-			url:   u,
-			zones: make(map[string]api.Zone, 0),
-		}, nil
-
-	case "mongodb":
-		return &MongodbProvider{
-			url:   u,
-			zones: make([]api.Zone, 0),
-		}, nil
-
-	default:
-		return nil, errors.New("Unknown Cerber Zone Provider schema: " + u.Scheme)
+	factoriesLock.RLock()
+	factory, ok := factories[strings.ToLower(u.Scheme)]
+	factoriesLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown Cerber Zone Provider schema: %s", u.Scheme)
 	}
+	return factory(u)
+}
+
+// SplitPriority extracts 'priority' query parameter from the Zone Provider location. Priority is handled
+// by Cerber and is not passed to provider, default priority is 0
+func SplitPriority(loc string) (string, int, error) {
+	u, err := url.Parse(loc)
+	if err != nil {
+		return "", 0, fmt.Errorf("Failed to parse Cerber Zone Provider URL: %s", err)
+	}
+
+	query := u.Query()
+	value := query.Get("priority")
+	if value == "" {
+		return loc, 0, nil
+	}
+
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		return "", 0, fmt.Errorf("Invalid provider priority: %s", value)
+	}
+
+	query.Del("priority")
+	u.RawQuery = query.Encode()
+	return u.String(), priority, nil
 }
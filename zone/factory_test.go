@@ -0,0 +1,83 @@
+package zone
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/xphoenix/cerber/api"
+)
+
+// TestRegisterProviderFactory checks custom providers are created by scheme
+func TestRegisterProviderFactory(t *testing.T) {
+	p := &countingProvider{}
+	RegisterProviderFactory("Custom", func(u *url.URL) (api.Provider, error) {
+		return p, nil
+	})
+	defer func() {
+		factoriesLock.Lock()
+		delete(factories, "custom")
+		factoriesLock.Unlock()
+	}()
+
+	found, err := NewProvider("custom://somewhere/zones")
+	if err != nil {
+		t.Fatalf("Failed to create custom provider: %s", err)
+	} else if found != p {
+		t.Fatal("Expected provider created by the registered factory")
+	}
+
+	schemes := ProviderSchemes()
+	if !contains(schemes, "custom") || !contains(schemes, "directory") {
+		t.Fatalf("Expected custom and directory schemes to be listed: %v", schemes)
+	}
+
+	if _, err := NewProvider("unknown://somewhere"); err == nil {
+		t.Fatal("Expected unknown scheme to be rejected")
+	}
+	if _, err := NewProvider("mongodb://localhost:27017/cerber"); err == nil {
+		t.Fatal("Expected unimplemented mongodb provider to be rejected")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Expected duplicated registration to panic")
+		}
+	}()
+	RegisterProviderFactory("custom", func(u *url.URL) (api.Provider, error) { return nil, nil })
+}
+
+// TestRegisterHasher checks custom hashers are resolved case insensitive
+func TestRegisterHasher(t *testing.T) {
+	RegisterHasher("reverse", func(s string) (string, error) {
+		r := []rune(s)
+		for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+			r[i], r[j] = r[j], r[i]
+		}
+		return string(r), nil
+	})
+	defer func() {
+		hashersLock.Lock()
+		delete(hashers, "REVERSE")
+		hashersLock.Unlock()
+	}()
+
+	h, err := ResolveHashAlgorithm("REVERSE")
+	if err != nil {
+		t.Fatalf("Failed to resolve hasher: %s", err)
+	} else if v, _ := h("abc"); v != "cba" {
+		t.Fatalf("Unexpected hash: %s", v)
+	}
+
+	if names := Hashers(); !contains(names, "reverse") || !contains(names, "md5") {
+		t.Fatalf("Expected reverse and md5 hashers to be listed: %v", names)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,391 @@
+package zone
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/xphoenix/cerber/api"
+)
+
+// GitProvider loads zone files from the git repository and polls it for new commits. Repository
+// is fetched into the local bare cache, files are read directly from the commit without checkout.
+// Supported URL forms:
+//
+//	git://git.example.org/zones.git
+//	git+https://git.example.org/zones.git
+//	git+ssh://git@git.example.org/zones.git
+//	file+git:///srv/git/zones.git
+//
+// Provider options are passed as query parameters:
+//
+//	ref      - branch, tag or full ref name to load zones from, default is master
+//	path     - repository subdirectory with zone files, default is repository root
+//	interval - how often repository is polled, default is 1m
+//	verify   - if true commit signature is checked with git verify-commit
+//	cache    - local directory for the bare repository, shared caches keep providers apart
+//	ignore   - comma separated list of file patterns to skip
+//	local_secrets - if true zones could use env and file references and key paths, otherwise only
+//	                sealed values and inline keys
+type GitProvider struct {
+	url      *url.URL
+	remote   string
+	ref      string
+	dir      string
+	cache    string
+	head     string
+	interval time.Duration
+	verify   bool
+	filter   *fileFilter
+
+	// Allow env and file secret references in zones of the repository
+	localSecrets bool
+
+	lock     sync.RWMutex
+	zones    map[string]api.Zone
+	revision string
+	loadErr  error
+	stop     chan bool
+
+	watchers
+	lifecycle
+}
+
+// gitZone is a yaml zone loaded from the particular commit
+type gitZone struct {
+	*yamlZone
+	revision string
+}
+
+// Revision returns commit hash the zone was loaded from
+func (z *gitZone) Revision() string {
+	return z.revision
+}
+
+func init() {
+	RegisterProviderFactory("git", createGitProvider)
+	RegisterProviderFactory("git+https", createGitProvider)
+	RegisterProviderFactory("git+http", createGitProvider)
+	RegisterProviderFactory("git+ssh", createGitProvider)
+	RegisterProviderFactory("file+git", createGitProvider)
+}
+
+// createGitProvider adapts newGitProvider to the ProviderFactory signature
+func createGitProvider(u *url.URL) (api.Provider, error) {
+	p, err := newGitProvider(u)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// newGitProvider splits provider options from the repository URL
+func newGitProvider(u *url.URL) (*GitProvider, error) {
+	query := u.Query()
+	remote := *u
+
+	p := &GitProvider{
+		url:      u,
+		ref:      "master",
+		dir:      strings.Trim(query.Get("path"), "/"),
+		cache:    query.Get("cache"),
+		interval: time.Minute,
+		verify:   query.Get("verify") == "true",
+		zones:    make(map[string]api.Zone),
+
+		localSecrets: query.Get("local_secrets") == "true",
+	}
+
+	filter, err := newFileFilter(query.Get("ignore"))
+	if err != nil {
+		return nil, err
+	}
+	p.filter = filter
+
+	if v := query.Get("ref"); v != "" {
+		p.ref = v
+	}
+
+	if v := query.Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("Invalid polling interval: %s", v)
+		}
+		p.interval = d
+	}
+
+	for _, k := range []string{"ref", "path", "interval", "verify", "cache", "ignore", "local_secrets"} {
+		query.Del(k)
+	}
+	remote.RawQuery = query.Encode()
+
+	switch u.Scheme {
+	case "git":
+		p.remote = remote.String()
+	case "file+git":
+		if u.Host != "" {
+			return nil, fmt.Errorf("Local repository URL shouldn't has host: %s", u.String())
+		}
+		p.remote = u.Path
+	default:
+		remote.Scheme = strings.TrimPrefix(u.Scheme, "git+")
+		p.remote = remote.String()
+	}
+
+	// Providers of the same repository with different ref or path must not overwrite each
+	// other fetched commit
+	sum := sha1.Sum([]byte(p.remote + "\x00" + p.ref + "\x00" + p.dir))
+	key := hex.EncodeToString(sum[:])[:12]
+	p.head = "refs/cerber/" + key
+	if p.cache == "" {
+		p.cache = filepath.Join(os.TempDir(), "cerber-git-"+key)
+	}
+
+	return p, nil
+}
+
+// URL returns URI for the current Provider
+func (g *GitProvider) URL() *url.URL {
+	return g.url
+}
+
+// Start fetches repository, loads zones from the configured ref and starts background polling.
+// Before was started Provider returns no any zones
+func (g *GitProvider) Start() error {
+	log.Infof("Starting git zone provider: %s (%s)", g.remote, g.ref)
+
+	if _, err := os.Stat(g.cache); os.IsNotExist(err) {
+		if out, err := exec.Command("git", "init", "--bare", "-q", g.cache).CombinedOutput(); err != nil {
+			return fmt.Errorf("Failed to init repository cache %s: %s %s", g.cache, err, out)
+		}
+	}
+
+	if _, err := g.refresh(); err != nil {
+		g.setState(false, err)
+		return err
+	}
+
+	g.lock.Lock()
+	g.stop = make(chan bool)
+	go g.poll(g.stop)
+	loadErr := g.loadErr
+	g.lock.Unlock()
+
+	// Broken files are reported, but do not prevent other zones from being served
+	g.setState(true, loadErr)
+	return nil
+}
+
+// Stop kill background polling. If process wasn't started then method returns
+// without any actual work
+// After was stopped Provider returns no zones
+func (g *GitProvider) Stop() error {
+	g.lock.Lock()
+	if g.stop != nil {
+		close(g.stop)
+		g.stop = nil
+	}
+	old := g.zones
+	g.zones = make(map[string]api.Zone)
+	g.revision = ""
+	g.loadErr = nil
+	g.lock.Unlock()
+
+	g.setState(false, nil)
+	g.notifySwap(old, nil)
+	return nil
+}
+
+// FindZone returns zone with the given name from the last loaded commit
+func (g *GitProvider) FindZone(name string) (api.Zone, error) {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	z, ok := g.zones[strings.ToUpper(name)]
+	if !ok {
+		return nil, api.NewNotFoundError("zone", name)
+	}
+	return z, nil
+}
+
+// Zones returns names of all zones loaded from the repository
+func (g *GitProvider) Zones() []string {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return zoneNames(g.zones)
+}
+
+// Revision returns commit hash currently loaded zones are backed by
+func (g *GitProvider) Revision() string {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.revision
+}
+
+func (g *GitProvider) poll(stop chan bool) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_, err := g.refresh()
+			if err != nil {
+				log.Warnf("Failed to refresh zones from %s: %s", g.remote, err)
+			} else {
+				g.lock.RLock()
+				err = g.loadErr
+				g.lock.RUnlock()
+			}
+			g.setError(err)
+		}
+	}
+}
+
+// refresh fetches configured ref and reloads all zones if it points to the new commit.
+// Returns true if zones were replaced
+func (g *GitProvider) refresh() (bool, error) {
+	if _, err := g.git("fetch", "-q", "--force", "--", g.remote, "+"+g.ref+":"+g.head); err != nil {
+		return false, err
+	}
+
+	out, err := g.git("rev-parse", "--verify", g.head+"^{commit}")
+	if err != nil {
+		return false, err
+	}
+
+	commit := strings.TrimSpace(string(out))
+	if commit == g.Revision() {
+		return false, nil
+	}
+
+	if g.verify {
+		if _, err := g.git("verify-commit", commit); err != nil {
+			return false, fmt.Errorf("Commit %s signature verification failed: %s", commit, err)
+		}
+	}
+
+	set, err := g.load(commit)
+	if err != nil {
+		return false, err
+	}
+
+	g.lock.Lock()
+	old := g.zones
+	g.zones = set.zones
+	g.revision = commit
+	g.loadErr = set.err()
+	g.lock.Unlock()
+
+	g.notifySwap(old, set.zones)
+
+	log.WithField("revision", commit).Infof("Loaded %d zones from %s (%s)", len(set.zones), g.remote, g.ref)
+	return true, nil
+}
+
+// load parses all zone files found in the configured directory of the given commit. Broken files
+// are reported in the returned set and do not prevent other zones from being loaded
+func (g *GitProvider) load(commit string) (*zoneSet, error) {
+	tree := commit + ":" + g.dir
+	out, err := g.git("ls-tree", "-z", tree)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]zoneSource, 0)
+	set := newZoneSet(&gitFiles{git: g, commit: commit})
+	wrap := func(z *yamlZone) api.Zone { return &gitZone{yamlZone: z, revision: commit} }
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// Format is: <mode> SP <type> SP <object> TAB <file>
+		tab := strings.Index(entry, "\t")
+		if tab == -1 {
+			continue
+		}
+
+		meta, name := strings.Fields(entry[:tab]), entry[tab+1:]
+		if len(meta) != 3 || meta[1] != "blob" || !g.filter.accept(name) {
+			continue
+		}
+
+		source := path.Join(g.dir, name) + "@" + commit[:12]
+		log.Infof("Loading zone file: %s", source)
+
+		data, err := g.git("cat-file", "blob", meta[2])
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, zoneSource{name: path.Join(g.dir, name), data: data, wrap: wrap})
+	}
+	set.addAll(sources)
+	return set, nil
+}
+
+// gitFiles reads zone includes from the commit, paths are relative to the repository root
+type gitFiles struct {
+	git    *GitProvider
+	commit string
+	names  []string
+}
+
+func (f *gitFiles) glob(pattern string) ([]string, error) {
+	if f.names == nil {
+		out, err := f.git.git("ls-tree", "-r", "-z", "--name-only", f.commit)
+		if err != nil {
+			return nil, err
+		}
+		f.names = strings.Split(strings.TrimRight(string(out), "\x00"), "\x00")
+	}
+
+	pattern = strings.TrimPrefix(path.Clean(pattern), "/")
+	matches := make([]string, 0)
+	for _, name := range f.names {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, err
+		} else if ok {
+			matches = append(matches, name)
+		}
+	}
+	return matches, nil
+}
+
+func (f *gitFiles) read(name string) ([]byte, error) {
+	return f.git.git("cat-file", "blob", f.commit+":"+name)
+}
+
+// private is always true, file permissions are not tracked by git
+func (f *gitFiles) private(name string) bool {
+	return true
+}
+
+// trusted is false unless provider trusts the repository with local secrets
+func (f *gitFiles) trusted() bool {
+	return f.git.localSecrets
+}
+
+// git runs git command against the local cache repository
+func (g *GitProvider) git(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"--git-dir", g.cache}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s failed: %s %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
@@ -0,0 +1,163 @@
+package zone
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xphoenix/cerber/api"
+)
+
+// commitFile writes file into the work tree and commits it, returning commit hash
+func commitFile(t *testing.T, repo, name, content string) string {
+	full := filepath.Join(repo, name)
+	os.MkdirAll(filepath.Dir(full), 0755)
+	if err := ioutil.WriteFile(full, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %s", name, err)
+	}
+
+	run := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.org"}, args...)...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v failed: %s %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	run("add", name)
+	run("commit", "-q", "-m", "update "+name)
+	return run("rev-parse", "HEAD")
+}
+
+// TestGitProvider checks zones are loaded from the configured subdirectory and reloaded on new commits
+func TestGitProvider(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	tmp, _ := ioutil.TempDir("", "cerber-git")
+	defer os.RemoveAll(tmp)
+
+	repo := filepath.Join(tmp, "repo")
+	if out, err := exec.Command("git", "init", "-q", "-b", "main", repo).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to init repository: %s %s", err, out)
+	}
+
+	commitFile(t, repo, "README.md", "not a zone")
+	first := commitFile(t, repo, "zones/registry.yaml", "name: registry\nhashing: none\n")
+
+	p, err := NewProvider("file+git://" + repo + "?ref=main&path=zones&interval=1h&cache=" + filepath.Join(tmp, "cache"))
+	if err != nil {
+		t.Fatalf("Failed to create provider: %s", err)
+	}
+	c, _ := api.New("test")
+	defer c.Stop()
+	if err := c.AddProvider(p); err != nil {
+		t.Fatalf("Failed to start provider: %s", err)
+	}
+
+	z, err := p.FindZone("registry")
+	if err != nil {
+		t.Fatalf("Failed to find zone: %s", err)
+	}
+	if z.(api.Versioned).Revision() != first {
+		t.Fatalf("Expected zone revision %s but found %s", first, z.(api.Versioned).Revision())
+	}
+
+	if status := c.Status(); len(status) != 1 || status[0].Revision != first {
+		t.Fatalf("Expected loaded revision to be reported: %+v", status)
+	}
+
+	g := p.(*GitProvider)
+	if changed, err := g.refresh(); changed || err != nil {
+		t.Fatalf("Expected no changes: %v %v", changed, err)
+	}
+
+	second := commitFile(t, repo, "zones/mirror.yml", "name: mirror\nhashing: md5\n")
+	if changed, err := g.refresh(); !changed || err != nil {
+		t.Fatalf("Expected zones to be reloaded: %v %v", changed, err)
+	}
+
+	if g.Revision() != second {
+		t.Fatalf("Expected provider revision %s but found %s", second, g.Revision())
+	}
+	if _, err := p.FindZone("mirror"); err != nil {
+		t.Fatalf("Failed to find new zone: %s", err)
+	}
+
+	// Broken file is reported, other zones are still served
+	third := commitFile(t, repo, "zones/broken.yaml", "name: [")
+	if changed, err := g.refresh(); !changed || err != nil {
+		t.Fatalf("Expected zones to be reloaded: %v %v", changed, err)
+	}
+	if g.Revision() != third {
+		t.Fatalf("Expected provider revision %s but found %s", third, g.Revision())
+	}
+	if _, err := p.FindZone("mirror"); err != nil {
+		t.Fatalf("Failed to find zone: %s", err)
+	}
+	if g.loadErr == nil || !strings.Contains(g.loadErr.Error(), "broken.yaml") {
+		t.Fatalf("Expected broken file to be reported: %v", g.loadErr)
+	}
+}
+
+// TestGitProviderKeys checks providers of the same repository with different ref or path use own
+// cache and fetched ref
+func TestGitProviderKeys(t *testing.T) {
+	keys := make(map[string]bool)
+	for _, query := range []string{"ref=main", "ref=release", "ref=main&path=zones", "ref=main&path=zones&interval=1h"} {
+		u, _ := url.Parse("git+https://git.example.org/zones.git?" + query)
+		p, err := newGitProvider(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[p.cache+" "+p.head] = true
+	}
+	if len(keys) != 3 {
+		t.Fatalf("Expected cache and ref to depend on remote, ref and path only: %v", keys)
+	}
+}
+
+// TestGitProviderSecrets checks zones of the repository couldn't read server environment and files
+// unless provider allows local secrets
+func TestGitProviderSecrets(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	tmp, _ := ioutil.TempDir("", "cerber-git")
+	defer os.RemoveAll(tmp)
+
+	repo := filepath.Join(tmp, "repo")
+	if out, err := exec.Command("git", "init", "-q", "-b", "main", repo).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to init repository: %s %s", err, out)
+	}
+	commitFile(t, repo, "registry.yaml", "name: registry\nhashing: ${env:CERBER_TEST_GIT_HASHING}\n")
+
+	// Key file of the server couldn't be referenced by path either
+	key := filepath.Join(tmp, "server.key")
+	writeECKey(t, key)
+	commitFile(t, repo, "signed.yaml", "name: signed\nsign:\n  method: ES256\n  cert:\n    key: "+key+"\n")
+
+	os.Setenv("CERBER_TEST_GIT_HASHING", "none")
+	defer os.Unsetenv("CERBER_TEST_GIT_HASHING")
+
+	for query, allowed := range map[string]bool{"": false, "&local_secrets=true": true} {
+		p, err := NewProvider("file+git://" + repo + "?ref=main&interval=1h&cache=" + filepath.Join(tmp, "cache") + query)
+		if err != nil {
+			t.Fatalf("Failed to create provider: %s", err)
+		}
+		p.Start()
+		if _, err := p.FindZone("registry"); (err == nil) != allowed {
+			t.Fatalf("Expected env reference allowed %v with '%s', found: %v", allowed, query, err)
+		} else if _, err := p.FindZone("signed"); (err == nil) != allowed {
+			t.Fatalf("Expected key path allowed %v with '%s', found: %v", allowed, query, err)
+		}
+		p.Stop()
+	}
+}
@@ -5,19 +5,59 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
+	"sync"
 )
 
 // Hasher builds hash of the given string and return hex representation of result bytes
 type Hasher func(string) (string, error)
 
-//ResolveHashAlgorithm returns hashing function based on the algorithm name
+var (
+	hashersLock sync.RWMutex
+	hashers     = make(map[string]Hasher)
+)
+
+func init() {
+	RegisterHasher("none", none)
+	RegisterHasher("md5", md5Hasher)
+}
+
+// RegisterHasher makes hashing algorithm available for zones under the given name. Names are
+// case insensitive, registering the same name twice panics
+func RegisterHasher(name string, h Hasher) {
+	hashersLock.Lock()
+	defer hashersLock.Unlock()
+
+	key := strings.ToUpper(name)
+	if h == nil {
+		panic("Hasher is nil: " + name)
+	} else if _, ok := hashers[key]; ok {
+		panic("Hasher is already registered: " + name)
+	}
+	hashers[key] = h
+}
+
+// Hashers returns sorted names of all registered hashing algorithms
+func Hashers() []string {
+	hashersLock.RLock()
+	defer hashersLock.RUnlock()
+
+	names := make([]string, 0, len(hashers))
+	for name := range hashers {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResolveHashAlgorithm returns hashing function based on the algorithm name
 func ResolveHashAlgorithm(name string) (Hasher, error) {
-	switch strings.ToUpper(name) {
-	case "NONE":
-		return none, nil
-	case "MD5":
-		return md5Hasher, nil
+	hashersLock.RLock()
+	defer hashersLock.RUnlock()
+
+	if h, ok := hashers[strings.ToUpper(name)]; ok {
+		return h, nil
 	}
 	return nil, fmt.Errorf("Unknown hashing algorithm: %s", name)
 }
@@ -0,0 +1,455 @@
+package zone
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/xphoenix/cerber/api"
+)
+
+// HTTPProvider fetches bundle of zones from the remote URL and keeps it up to date by polling
+// with ETag. Bundle is a yaml or json document with list of zones:
+//
+//	zones:
+//	- name: docker-distribution
+//	  ...
+//
+// Provider options are passed as query parameters and are not sent to the remote server:
+//
+//	interval  - how often bundle is polled, default is 1m
+//	verify    - path to PEM public key used to verify bundle signature
+//	signature - URL of the detached signature, default is bundle URL with .sig suffix
+//
+// Bundle zones could use sealed secret values and inline signing keys only, they couldn't refer to
+// the server environment and files.
+//
+// Signature is taken from X-Bundle-Signature header of the bundle response if server sends it,
+// otherwise detached signature is fetched and bundle is checked to keep its ETag meanwhile
+type HTTPProvider struct {
+	url       *url.URL
+	source    string
+	signature string
+	publicKey crypto.PublicKey
+	interval  time.Duration
+	client    *http.Client
+
+	lock    sync.RWMutex
+	zones   map[string]api.Zone
+	etag    string
+	stop    chan bool
+	running bool
+
+	watchers
+	lifecycle
+}
+
+// httpBundle is a set of zones served by the remote, zones are decoded one by one
+type httpBundle struct {
+	Zones []interface{} `yaml:"zones"`
+}
+
+// bundleFiles stands for files of the bundle zones. Bundle is not trusted with server files and has
+// no files to include
+type bundleFiles struct{}
+
+func (bundleFiles) glob(pattern string) ([]string, error) {
+	return nil, errors.New("Bundle zones couldn't include files")
+}
+
+func (bundleFiles) read(name string) ([]byte, error) {
+	return nil, errors.New("Bundle zones couldn't include files")
+}
+
+func (bundleFiles) private(name string) bool {
+	return true
+}
+
+func (bundleFiles) trusted() bool {
+	return false
+}
+
+// bundleResponse is a bundle version fetched from the remote
+type bundleResponse struct {
+	body        []byte
+	etag        string
+	contentType string
+	signature   []byte
+}
+
+// SignatureHeader is a response header bundle signature could be sent in along with the bundle
+const SignatureHeader = "X-Bundle-Signature"
+
+// bundleAttempts limits how many times bundle is fetched if it changes while its detached signature
+// is fetched
+const bundleAttempts = 3
+
+func init() {
+	RegisterProviderFactory("http", createHTTPProvider)
+	RegisterProviderFactory("https", createHTTPProvider)
+}
+
+// createHTTPProvider adapts newHTTPProvider to the ProviderFactory signature
+func createHTTPProvider(u *url.URL) (api.Provider, error) {
+	p, err := newHTTPProvider(u)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// newHTTPProvider splits provider options from the remote URL
+func newHTTPProvider(u *url.URL) (*HTTPProvider, error) {
+	query := u.Query()
+	source := *u
+
+	p := &HTTPProvider{
+		url:      u,
+		interval: time.Minute,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		zones:    make(map[string]api.Zone),
+	}
+
+	if v := query.Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("Invalid polling interval: %s", v)
+		}
+		p.interval = d
+	}
+
+	if v := query.Get("verify"); v != "" {
+		key, err := loadPublicKey(v)
+		if err != nil {
+			return nil, err
+		}
+		p.publicKey = key
+	}
+
+	for _, k := range []string{"interval", "verify", "signature"} {
+		query.Del(k)
+	}
+	source.RawQuery = query.Encode()
+	p.source = source.String()
+
+	p.signature = u.Query().Get("signature")
+	if p.signature == "" {
+		sig := source
+		sig.Path = sig.Path + ".sig"
+		p.signature = sig.String()
+	}
+
+	return p, nil
+}
+
+// URL returns URI for the current Provider. Protocol must be 'http' or 'https'
+func (h *HTTPProvider) URL() *url.URL {
+	return h.url
+}
+
+// Start fetches bundle from the remote and starts background polling.
+// Before was started Provider returns no any zones
+func (h *HTTPProvider) Start() error {
+	log.Infof("Starting HTTP zone provider: %s", h.source)
+
+	h.lock.Lock()
+	h.running = true
+	h.lock.Unlock()
+
+	if _, err := h.refresh(); err != nil {
+		h.setState(false, err)
+		return err
+	}
+
+	h.lock.Lock()
+	h.stop = make(chan bool)
+	go h.poll(h.stop)
+	h.lock.Unlock()
+
+	h.setState(true, nil)
+	return nil
+}
+
+// Stop kill background polling. If process wasn't started then method returns
+// without any actual work
+// After was stopped Provider returns no zones
+func (h *HTTPProvider) Stop() error {
+	h.lock.Lock()
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+	h.running = false
+	old := h.zones
+	h.zones = make(map[string]api.Zone)
+	h.etag = ""
+	h.lock.Unlock()
+
+	h.setState(false, nil)
+	h.notifySwap(old, nil)
+	return nil
+}
+
+// FindZone returns zone with the given name from the last fetched bundle
+func (h *HTTPProvider) FindZone(name string) (api.Zone, error) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	z, ok := h.zones[strings.ToUpper(name)]
+	if !ok {
+		return nil, api.NewNotFoundError("zone", name)
+	}
+	return z, nil
+}
+
+// Zones returns names of all zones loaded from the bundle
+func (h *HTTPProvider) Zones() []string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return zoneNames(h.zones)
+}
+
+func (h *HTTPProvider) poll(stop chan bool) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			_, err := h.refresh()
+			h.setError(err)
+			if err != nil {
+				log.Warnf("Failed to refresh zones from %s: %s", h.source, err)
+			}
+		}
+	}
+}
+
+// refresh downloads bundle if it was changed since the last fetch and swaps all zones at once.
+// Refresh finished after provider was stopped doesn't store zones. Returns true if zones were replaced
+func (h *HTTPProvider) refresh() (bool, error) {
+	h.lock.RLock()
+	etag := h.etag
+	h.lock.RUnlock()
+
+	for attempt := 0; attempt < bundleAttempts; attempt++ {
+		b, err := h.fetch(etag)
+		if err != nil || b == nil {
+			return false, err
+		}
+
+		if h.publicKey != nil {
+			if same, err := h.verify(b); err != nil {
+				return false, err
+			} else if !same {
+				log.Infof("Bundle %s changed while its signature was fetched, fetching again", h.source)
+				continue
+			}
+		}
+		return h.store(b)
+	}
+	return false, fmt.Errorf("Bundle %s keeps changing while its signature is fetched", h.source)
+}
+
+// fetch downloads bundle unless it still has the given ETag, nil is returned for unchanged bundle
+func (h *HTTPProvider) fetch(etag string) (*bundleResponse, error) {
+	req, err := http.NewRequest("GET", h.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch bundle: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, nil
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch bundle: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read bundle: %s", err)
+	}
+
+	b := &bundleResponse{body: body, etag: resp.Header.Get("ETag"), contentType: resp.Header.Get("Content-Type")}
+	if sig := resp.Header.Get(SignatureHeader); sig != "" {
+		b.signature = []byte(sig)
+	}
+	return b, nil
+}
+
+// store replaces zones with ones of the bundle if provider is still running
+func (h *HTTPProvider) store(b *bundleResponse) (bool, error) {
+	zones, err := parseBundle(b.body, b.contentType)
+	if err != nil {
+		return false, err
+	}
+
+	h.lock.Lock()
+	if !h.running {
+		h.lock.Unlock()
+		return false, nil
+	}
+	old := h.zones
+	h.zones = zones
+	h.etag = b.etag
+	h.lock.Unlock()
+
+	h.notifySwap(old, zones)
+
+	log.Infof("Loaded %d zones from %s (etag %s)", len(zones), h.source, b.etag)
+	return true, nil
+}
+
+// verify checks bundle signature. Detached signature is fetched separately, so bundle is fetched
+// again to make sure it wasn't changed meanwhile, false is returned if it was. Bundle without ETag
+// couldn't be checked and is verified as is
+func (h *HTTPProvider) verify(b *bundleResponse) (bool, error) {
+	if b.signature != nil {
+		return true, verifySignature(h.publicKey, b.body, b.signature)
+	}
+
+	resp, err := h.client.Get(h.signature)
+	if err != nil {
+		return false, fmt.Errorf("Failed to fetch bundle signature: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("Failed to fetch bundle signature: %s", resp.Status)
+	}
+
+	sig, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("Failed to read bundle signature: %s", err)
+	}
+
+	if b.etag != "" {
+		if changed, err := h.fetch(b.etag); err != nil {
+			return false, err
+		} else if changed != nil {
+			return false, nil
+		}
+	}
+	return true, verifySignature(h.publicKey, b.body, sig)
+}
+
+// parseBundle decodes yaml or json bundle into the map of zones
+func parseBundle(body []byte, contentType string) (map[string]api.Zone, error) {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil && t == "application/json" {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, fmt.Errorf("Failed to parse json bundle: %s", err)
+		}
+	}
+
+	bundle := httpBundle{}
+	if err := yaml.Unmarshal(body, &bundle); err != nil {
+		return nil, fmt.Errorf("Failed to parse bundle: %s", err)
+	}
+
+	zones := make(map[string]api.Zone, len(bundle.Zones))
+	for i, doc := range bundle.Zones {
+		z, err := decodeZone(doc, bundleFiles{})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse bundle zone #%d: %s", i+1, err)
+		}
+
+		name := strings.ToUpper(z.Name())
+		if name == "" {
+			return nil, errors.New("Bundle has zone without name")
+		} else if len(z.ZInclude) > 0 {
+			return nil, fmt.Errorf("Bundle zone %s has includes, they are not supported", z.Name())
+		} else if _, ok := zones[name]; ok {
+			return nil, fmt.Errorf("Found duplicated zone: %s (%s)", z.Name(), z.Description())
+		} else if err := z.validateRules(); err != nil {
+			return nil, err
+		} else if err := z.openKeys(z.Name(), bundleFiles{}, true); err != nil {
+			return nil, err
+		}
+		zones[name] = z
+	}
+	return zones, nil
+}
+
+// loadPublicKey reads PEM encoded PKIX public key or certificate from the given file
+func loadPublicKey(path string) (crypto.PublicKey, error) {
+	bytes, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(bytes)
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found: %s", path)
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse certificate %s: %s", path, err)
+		}
+		return cert.PublicKey, nil
+	default:
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse public key %s: %s", path, err)
+		}
+		return key, nil
+	}
+}
+
+// verifySignature checks SHA256 signature made by RSA (PKCS#1 v1.5), ECDSA (ASN.1) or Ed25519 key,
+// the same format openssl dgst -sha256 -sign produces. Signature could be either raw or base64 encoded
+func verifySignature(key crypto.PublicKey, data, sig []byte) error {
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig))); err == nil {
+		sig = decoded
+	}
+
+	digest := sha256.Sum256(data)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("Bundle signature is invalid")
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest[:], sig) {
+			return errors.New("Bundle signature is invalid")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, data, sig) {
+			return errors.New("Bundle signature is invalid")
+		}
+	default:
+		return fmt.Errorf("Unsupported signature key type: %T", key)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package zone
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/xphoenix/cerber/config"
+)
+
+// bundleServer serves zone bundle along with its signature and counts full downloads. Inline server
+// sends signature in the bundle response header only, next bundle is published once signature is requested
+type bundleServer struct {
+	lock      sync.Mutex
+	bundle    string
+	signature []byte
+	version   int
+	downloads int
+	inline    bool
+	next      func()
+}
+
+func (b *bundleServer) set(bundle string, signature []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.bundle, b.signature = bundle, signature
+	b.version++
+}
+
+func (b *bundleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	switch r.URL.Path {
+	case "/zones.yaml":
+		etag := fmt.Sprintf(`"v%d"`, b.version)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		b.downloads++
+		w.Header().Set("ETag", etag)
+		if b.inline {
+			w.Header().Set(SignatureHeader, base64.StdEncoding.EncodeToString(b.signature))
+		}
+		w.Write([]byte(b.bundle))
+	case "/zones.yaml.sig":
+		if b.inline {
+			http.NotFound(w, r)
+			return
+		} else if b.next != nil {
+			b.next()
+			b.next = nil
+		}
+		w.Write(b.signature)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeECKey stores new PEM encoded ECDSA private key into the file
+func writeECKey(t *testing.T, path string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	der, _ := x509.MarshalECPrivateKey(key)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testBundle = `
+zones:
+- name: registry
+  hashing: none
+  users:
+  - name: admin
+    passwd: admin
+- name: %s
+  hashing: md5
+`
+
+// TestHTTPProviderPolling checks that bundle is downloaded only when ETag changes
+// and zones are replaced as a whole
+func TestHTTPProviderPolling(t *testing.T) {
+	b := &bundleServer{}
+	b.set(fmt.Sprintf(testBundle, "first"), nil)
+
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	p, err := NewProvider(srv.URL + "/zones.yaml?interval=1h")
+	if err != nil {
+		t.Fatalf("Failed to create provider: %s", err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Failed to start provider: %s", err)
+	}
+	defer p.Stop()
+
+	h := p.(*HTTPProvider)
+	if h.source != srv.URL+"/zones.yaml" {
+		t.Fatalf("Provider options must not be sent to remote: %s", h.source)
+	}
+
+	if _, err := p.FindZone("first"); err != nil {
+		t.Fatalf("Failed to find zone: %s", err)
+	}
+
+	// Nothing changed
+	if changed, err := h.refresh(); changed || err != nil {
+		t.Fatalf("Expected bundle to be not modified: %v %v", changed, err)
+	}
+	if b.downloads != 1 {
+		t.Fatalf("Expected single download but found: %d", b.downloads)
+	}
+
+	// Zone renamed
+	b.set(fmt.Sprintf(testBundle, "second"), nil)
+	if changed, err := h.refresh(); !changed || err != nil {
+		t.Fatalf("Expected bundle to be reloaded: %v %v", changed, err)
+	}
+
+	if _, err := p.FindZone("first"); err == nil {
+		t.Fatal("Expected old zone to be removed")
+	}
+	if _, err := p.FindZone("second"); err != nil {
+		t.Fatalf("Failed to find new zone: %s", err)
+	}
+
+	// Broken bundle keeps previous zones
+	b.set("zones: [", nil)
+	if _, err := h.refresh(); err == nil {
+		t.Fatal("Expected broken bundle to be rejected")
+	}
+	if _, err := p.FindZone("second"); err != nil {
+		t.Fatalf("Expected zones to be kept after failed refresh: %s", err)
+	}
+}
+
+// TestHTTPProviderSignature checks detached bundle signature verification
+func TestHTTPProviderSignature(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	dir, _ := ioutil.TempDir("", "cerber-http")
+	defer os.RemoveAll(dir)
+
+	pub := filepath.Join(dir, "bundle.pub")
+	ioutil.WriteFile(pub, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+
+	sign := func(data string) []byte {
+		digest := sha256.Sum256([]byte(data))
+		sig, _ := ecdsa.SignASN1(rand.Reader, key, digest[:])
+		return sig
+	}
+
+	b := &bundleServer{}
+	bundle := fmt.Sprintf(testBundle, "signed")
+	b.set(bundle, sign(bundle))
+
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	p, err := NewProvider(srv.URL + "/zones.yaml?verify=" + url.QueryEscape(pub))
+	if err != nil {
+		t.Fatalf("Failed to create provider: %s", err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Failed to start provider with valid signature: %s", err)
+	}
+	defer p.Stop()
+
+	b.set(fmt.Sprintf(testBundle, "tampered"), sign(bundle))
+	if _, err := p.(*HTTPProvider).refresh(); err == nil {
+		t.Fatal("Expected bundle with invalid signature to be rejected")
+	}
+
+	if _, err := p.FindZone("signed"); err != nil {
+		t.Fatalf("Expected verified zones to be kept: %s", err)
+	}
+
+	// Bundle published between bundle and signature requests is fetched again
+	updated := fmt.Sprintf(testBundle, "updated")
+	b.lock.Lock()
+	b.bundle, b.signature = bundle, sign(bundle)
+	b.version++
+	b.next = func() {
+		b.bundle, b.signature = updated, sign(updated)
+		b.version++
+	}
+	b.lock.Unlock()
+	if changed, err := p.(*HTTPProvider).refresh(); !changed || err != nil {
+		t.Fatalf("Expected bundle changed during verification to be fetched again: %v %v", changed, err)
+	} else if _, err := p.FindZone("updated"); err != nil {
+		t.Fatalf("Expected updated zones to be loaded: %s", err)
+	}
+
+	// Signature sent along with the bundle is used without detached one
+	b.lock.Lock()
+	b.inline = true
+	b.lock.Unlock()
+	b.set(fmt.Sprintf(testBundle, "inline"), sign(fmt.Sprintf(testBundle, "inline")))
+	if changed, err := p.(*HTTPProvider).refresh(); !changed || err != nil {
+		t.Fatalf("Expected bundle with inline signature to be loaded: %v %v", changed, err)
+	}
+
+	// Refresh finished after stop doesn't bring zones back
+	p.Stop()
+	if changed, _ := p.(*HTTPProvider).refresh(); changed || len(p.Zones()) != 0 {
+		t.Fatalf("Expected stopped provider to keep no zones: %v", p.Zones())
+	}
+}
+
+// TestHTTPProviderSources checks bundle zones could open sealed values, but couldn't reference server
+// environment and files
+func TestHTTPProviderSources(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cerber-http")
+	defer os.RemoveAll(dir)
+
+	master, _ := config.GenerateMasterKey()
+	config.Secrets.SetMasterKey(master)
+	sealed, _ := config.Seal(master, "none")
+
+	key := filepath.Join(dir, "server.key")
+	writeECKey(t, key)
+
+	b := &bundleServer{}
+	b.set("zones:\n- name: sealed\n  hashing: \""+sealed+"\"\n", nil)
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	p, err := NewProvider(srv.URL + "/zones.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create provider: %s", err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Failed to start provider: %s", err)
+	}
+	defer p.Stop()
+
+	z, err := p.FindZone("sealed")
+	if err != nil || z.(*yamlZone).ZHashing != "none" {
+		t.Fatalf("Expected sealed value to be opened: %v", err)
+	}
+
+	for _, zone := range []string{
+		"name: env\n  hashing: ${env:HOME}",
+		"name: file\n  hashing: ${file:" + key + "}",
+		"name: path\n  sign:\n    method: ES256\n    cert:\n      key: " + key,
+	} {
+		b.set("zones:\n- "+zone+"\n", nil)
+		if _, err := p.(*HTTPProvider).refresh(); err == nil {
+			t.Fatalf("Expected bundle to be rejected: %s", zone)
+		}
+	}
+}
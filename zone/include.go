@@ -0,0 +1,219 @@
+package zone
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/xphoenix/cerber/config"
+)
+
+// fileReader gives access to files zones could include
+type fileReader interface {
+	// glob returns sorted names of files matching pattern
+	glob(pattern string) ([]string, error)
+
+	// read returns content of the file
+	read(name string) ([]byte, error)
+
+	// private returns false if file could be read by other users
+	private(name string) bool
+
+	// trusted returns true if files could refer to the server environment and files, such as env
+	// and file secret references or signing key paths
+	trusted() bool
+}
+
+// osFiles reads includes from the local filesystem
+type osFiles struct{}
+
+func (osFiles) glob(pattern string) ([]string, error) {
+	names, err := filepath.Glob(pattern)
+	sort.Strings(names)
+	return names, err
+}
+
+func (osFiles) read(name string) ([]byte, error) {
+	return readFile(name)
+}
+
+func (osFiles) private(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.Mode().Perm()&0077 == 0
+}
+
+// trusted is always true, local files are trusted as much as server config
+func (osFiles) trusted() bool {
+	return true
+}
+
+// secretsOf returns resolver of secret references found in the files, untrusted files could use
+// sealed values only
+func secretsOf(files fileReader) *config.SecretResolver {
+	if files.trusted() {
+		return config.Secrets
+	}
+	return config.Secrets.Sealed()
+}
+
+// includePattern resolves include relative to the directory of the including file
+func includePattern(source, pattern string) string {
+	if filepath.IsAbs(pattern) {
+		return filepath.Clean(pattern)
+	}
+	return filepath.Join(filepath.Dir(source), pattern)
+}
+
+// resolveIncludes loads all files included by the zone and merges them into the zone. Included files
+// have the same format as zone files, but could contain any subset of zone fields. Users and groups are
+// appended, other fields could be set only once across the zone and all its includes. Includes could not
+// be nested. Zone is loaded as a unit: if any include fails the whole zone fails
+func resolveIncludes(z *yamlZone, source string, files fileReader) error {
+	if len(z.ZInclude) == 0 {
+		return nil
+	}
+
+	origins := newOrigins(z, source)
+	for _, pattern := range z.ZInclude {
+		names, err := files.glob(includePattern(source, pattern))
+		if err != nil {
+			return fmt.Errorf("Invalid include pattern %s in %s: %s", pattern, source, err)
+		} else if len(names) == 0 && !hasMeta(pattern) {
+			return fmt.Errorf("Included file %s is not found: %s", pattern, source)
+		}
+
+		for _, name := range names {
+			if !isZoneFile(name) {
+				continue
+			}
+
+			data, err := files.read(name)
+			if err != nil {
+				return err
+			}
+
+			fragments, err := decodeZoneFile(data, name, files)
+			if err != nil {
+				return err
+			}
+
+			for _, f := range fragments {
+				if f.ZSign.Method != "" && !files.private(name) {
+					log.WithField("file", name).Warn("Signing configuration is readable by other users")
+				}
+				if err := z.merge(f, name, origins); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	z.ZInclude = nil
+	return nil
+}
+
+// hasMeta reports whether pattern is a glob rather than a plain file name
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// origins tracks file every merged zone field came from, to report conflicts
+type origins map[string]string
+
+func newOrigins(z *yamlZone, source string) origins {
+	o := make(origins)
+	for field, set := range z.fields() {
+		if set {
+			o[field] = source
+		}
+	}
+	for _, u := range z.ZUsers {
+		o["user "+u.Name] = source
+	}
+	for _, g := range z.ZGroups {
+		o["group "+g.Name] = source
+	}
+	for _, p := range z.ZPolicies {
+		o["policy "+p.Name] = source
+	}
+	return o
+}
+
+// claim records field origin, error returns if field was already set in other file
+func (o origins) claim(field, source string) error {
+	if prev, ok := o[field]; ok {
+		return fmt.Errorf("Conflicting %s in %s and %s", field, path.Clean(prev), path.Clean(source))
+	}
+	o[field] = source
+	return nil
+}
+
+// fields returns which of the zone wide fields are set
+func (z *yamlZone) fields() map[string]bool {
+	return map[string]bool{
+		"description": z.ZDescription != "",
+		"timeout":     z.ZTimeout != nil,
+		"maxrefresh":  z.ZMaxRefresh != 0,
+		"hashing":     z.ZHashing != "",
+		"sign":        z.ZSign.Method != "",
+
+		"password_policy": z.ZPasswordPolicy != nil,
+	}
+}
+
+// merge adds included fragment into the zone
+func (z *yamlZone) merge(f *yamlZone, source string, o origins) error {
+	if f.ZName != "" && !strings.EqualFold(f.ZName, z.ZName) {
+		return fmt.Errorf("Included file %s belongs to zone %s, not %s", source, f.ZName, z.ZName)
+	} else if len(f.ZInclude) > 0 {
+		return fmt.Errorf("Nested includes are not supported: %s (zone %s)", source, z.ZName)
+	}
+
+	for field, set := range f.fields() {
+		if !set {
+			continue
+		}
+		if err := o.claim(field, source); err != nil {
+			return err
+		}
+
+		switch field {
+		case "description":
+			z.ZDescription = f.ZDescription
+		case "timeout":
+			z.ZTimeout = f.ZTimeout
+		case "maxrefresh":
+			z.ZMaxRefresh = f.ZMaxRefresh
+		case "hashing":
+			z.ZHashing = f.ZHashing
+		case "sign":
+			z.ZSign = f.ZSign
+		case "password_policy":
+			z.ZPasswordPolicy = f.ZPasswordPolicy
+		}
+	}
+
+	for _, u := range f.ZUsers {
+		if err := o.claim("user "+u.Name, source); err != nil {
+			return err
+		}
+		z.ZUsers = append(z.ZUsers, u)
+	}
+	for _, g := range f.ZGroups {
+		if err := o.claim("group "+g.Name, source); err != nil {
+			return err
+		}
+		z.ZGroups = append(z.ZGroups, g)
+	}
+	for _, p := range f.ZPolicies {
+		if err := o.claim("policy "+p.Name, source); err != nil {
+			return err
+		}
+		z.ZPolicies = append(z.ZPolicies, p)
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package zone
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/xphoenix/cerber/api"
+	"gopkg.in/yaml.v2"
+)
+
+// keyPolicy defines how generated keys of the zone are rotated
+type keyPolicy struct {
+	// Method keys are generated for
+	method string
+
+	// Subject of the self-signed certificates
+	zone string
+
+	// How long key signs tokens, rotation is disabled if 0
+	rotate time.Duration
+
+	// How long before rotation the next key is published as pending
+	publish time.Duration
+
+	// How long retired key verifies tokens issued before rotation
+	grace time.Duration
+}
+
+// storedKey is a generated key along with its self-signed certificate and rotation state
+type storedKey struct {
+	ID        string    `yaml:"id"`
+	State     string    `yaml:"state"`
+	Created   time.Time `yaml:"created"`
+	Activated time.Time `yaml:"activated,omitempty"`
+	Retired   time.Time `yaml:"retired,omitempty"`
+	Key       string    `yaml:"key"`
+	Crt       string    `yaml:"crt"`
+
+	cert *tls.Certificate
+}
+
+// keyStore persists generated keys of the zone in the yaml file readable by owner only. Store is shared
+// by all instances of the zone, so reloaded zone keeps its keys
+type keyStore struct {
+	path string
+
+	lock sync.Mutex
+	keys []*storedKey
+}
+
+var (
+	keyStoresLock sync.Mutex
+	keyStores     = make(map[string]*keyStore)
+)
+
+// openKeyStore returns store for the given file, keys are loaded on the first open
+func openKeyStore(path string) (*keyStore, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keyStoresLock.Lock()
+	defer keyStoresLock.Unlock()
+
+	if s, ok := keyStores[path]; ok {
+		return s, nil
+	}
+
+	s := &keyStore{path: path}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	keyStores[path] = s
+	return s, nil
+}
+
+// load reads keys persisted in the store file, missing file is an empty store
+func (s *keyStore) load() error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Failed to read key store %s: %s", s.path, err)
+	}
+
+	stored := struct {
+		Keys []*storedKey `yaml:"keys"`
+	}{}
+	if err := yaml.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("Failed to parse key store %s: %s", s.path, err)
+	}
+
+	for _, k := range stored.Keys {
+		cert, err := tls.X509KeyPair([]byte(k.Crt), []byte(k.Key))
+		if err != nil {
+			return fmt.Errorf("Broken key %s in store %s: %s", k.ID, s.path, err)
+		}
+		cert.Leaf, _ = x509.ParseCertificate(cert.Certificate[0])
+		k.cert = &cert
+	}
+	s.keys = stored.Keys
+	return nil
+}
+
+// save atomically replaces store file
+func (s *keyStore) save() error {
+	data, err := yaml.Marshal(map[string]interface{}{"keys": s.keys})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("Failed to create key store directory: %s", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("Failed to write key store %s: %s", s.path, err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// maintain generates the first key and rotates keys according to the policy. Changes are persisted
+// before they take effect
+func (s *keyStore) maintain(p keyPolicy, now time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keys := make([]*storedKey, 0, len(s.keys)+1)
+	var active, pending *storedKey
+	for _, k := range s.keys {
+		// Tokens signed by retired key are expired after grace period
+		if k.State == api.KeyRetired && now.Sub(k.Retired) >= p.grace {
+			continue
+		}
+		switch k.State {
+		case api.KeyActive:
+			active = k
+		case api.KeyPending:
+			pending = k
+		}
+		keys = append(keys, k)
+	}
+
+	var err error
+	var events []string
+	if active == nil {
+		// The first start or pending key activation failed previously
+		if pending == nil {
+			if pending, err = generateKey(p, now); err != nil {
+				return err
+			}
+			keys = append(keys, pending)
+		}
+		pending.State, pending.Activated, active, pending = api.KeyActive, now, pending, nil
+		events = append(events, "Activated key "+active.ID)
+	} else if p.rotate > 0 {
+		age := now.Sub(active.Activated)
+		if pending == nil && age >= p.rotate-p.publish {
+			if pending, err = generateKey(p, now); err != nil {
+				return err
+			}
+			keys = append(keys, pending)
+			events = append(events, "Published pending key "+pending.ID)
+		}
+
+		if age >= p.rotate {
+			active.State, active.Retired = api.KeyRetired, now
+			pending.State, pending.Activated = api.KeyActive, now
+			events = append(events, "Retired key "+active.ID, "Activated key "+pending.ID)
+		}
+	}
+
+	if len(events) == 0 && len(keys) == len(s.keys) {
+		return nil
+	}
+
+	s.keys = keys
+	if err := s.save(); err != nil {
+		// Drop changes which were not persisted
+		s.keys = nil
+		s.load()
+		return err
+	}
+
+	for _, e := range events {
+		log.WithFields(log.Fields{"zone": p.zone, "store": s.path}).Info(e)
+	}
+	return nil
+}
+
+// active returns certificate of the key currently signing tokens
+func (s *keyStore) active(p keyPolicy) (*tls.Certificate, error) {
+	if err := s.maintain(p, time.Now()); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, k := range s.keys {
+		if k.State == api.KeyActive {
+			return k.cert, nil
+		}
+	}
+	return nil, fmt.Errorf("Key store %s has no active key", s.path)
+}
+
+// ring returns all keys of the store
+func (s *keyStore) ring(p keyPolicy) ([]api.ZoneKey, error) {
+	if err := s.maintain(p, time.Now()); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keys := make([]api.ZoneKey, len(s.keys))
+	for i, k := range s.keys {
+		keys[i] = api.ZoneKey{ID: k.ID, State: k.State, Public: k.cert.Leaf.PublicKey, Chain: k.cert.Certificate}
+	}
+	return keys, nil
+}
+
+// generateKey creates pending key of the type signing method requires along with self-signed certificate
+// valid for the whole key life time
+func generateKey(p keyPolicy, now time.Time) (*storedKey, error) {
+	var key crypto.Signer
+	var err error
+	switch p.method {
+	case "ES256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		key, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "RS256", "RS384", "RS512":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, fmt.Errorf("Key generation is not supported for %s", p.method)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate key: %s", err)
+	}
+
+	id, err := api.KeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	// Without rotation key lives until replaced manually
+	lifetime := 10 * 365 * 24 * time.Hour
+	if p.rotate > 0 {
+		lifetime = p.publish + p.rotate + p.grace
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: p.zone + " token signing"},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create certificate: %s", err)
+	}
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &storedKey{
+		ID:      id,
+		State:   api.KeyPending,
+		Created: now,
+		Key:     string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})),
+		Crt:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		cert:    &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf},
+	}, nil
+}
@@ -0,0 +1,160 @@
+package zone
+
+import (
+	"crypto/ecdsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xphoenix/cerber/api"
+)
+
+// TestGeneratedKeys checks key is generated on the first load, persisted and used for tokens
+func TestGeneratedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cerber-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	zoneFile := filepath.Join(dir, "registry.yaml")
+	content := "name: registry\nsign:\n  method: ES256\n  generate: true\n  store: private/registry-keys.yaml\n"
+	if err := ioutil.WriteFile(zoneFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewProvider("directory://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := api.New("test")
+	defer c.Stop()
+	c.AddProvider(p)
+
+	signed, err := c.GenerateToken("registry", "admin", "", "", map[string]interface{}{})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %s", err)
+	}
+	token, err := c.ParseToken(*signed)
+	if err != nil {
+		t.Fatalf("Failed to parse token: %s", err)
+	}
+	if token.Header["alg"] != "ES256" || token.Header["x5c"] == nil {
+		t.Fatalf("Unexpected token header: %v", token.Header)
+	}
+
+	store := filepath.Join(dir, "private", "registry-keys.yaml")
+	if info, err := os.Stat(store); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Key store is not persisted privately: %v", err)
+	}
+
+	// Fresh process reuses persisted key
+	keyStoresLock.Lock()
+	delete(keyStores, store)
+	keyStoresLock.Unlock()
+
+	z, err := loadZoneFile(zoneFile)
+	if err != nil {
+		t.Fatalf("Failed to reload zone: %s", err)
+	}
+	cert, err := z.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := api.KeyID(cert.PrivateKey.(*ecdsa.PrivateKey).Public())
+	if token.Header["kid"] != id {
+		t.Fatalf("Expected key %v to be reused, got %s", token.Header["kid"], id)
+	}
+}
+
+// TestKeyRotation checks keys pass pending, active and retired states
+func TestKeyRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cerber-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := &keyStore{path: filepath.Join(dir, "keys.yaml")}
+	p := keyPolicy{method: "ES256", zone: "registry", rotate: time.Hour, publish: 10 * time.Minute, grace: 15 * time.Minute}
+
+	states := func() map[string]string {
+		m := make(map[string]string)
+		for _, k := range s.keys {
+			m[k.ID] = k.State
+		}
+		return m
+	}
+
+	start := time.Now()
+	steps := []struct {
+		at     time.Duration
+		states []string
+	}{
+		{0, []string{api.KeyActive}},
+		{45 * time.Minute, []string{api.KeyActive}},
+		{50 * time.Minute, []string{api.KeyActive, api.KeyPending}},
+		{60 * time.Minute, []string{api.KeyRetired, api.KeyActive}},
+		{75 * time.Minute, []string{api.KeyActive}},
+	}
+
+	var first string
+	for _, step := range steps {
+		if err := s.maintain(p, start.Add(step.at)); err != nil {
+			t.Fatalf("Failed to maintain keys at %s: %s", step.at, err)
+		}
+		if len(s.keys) != len(step.states) {
+			t.Fatalf("Expected %d keys at %s, got %v", len(step.states), step.at, states())
+		}
+		for i, state := range step.states {
+			if s.keys[i].State != state {
+				t.Fatalf("Expected states %v at %s, got %v", step.states, step.at, states())
+			}
+		}
+		if first == "" {
+			first = s.keys[0].ID
+		}
+	}
+
+	if s.keys[0].ID == first {
+		t.Fatalf("Key wasn't rotated: %s", first)
+	}
+
+	persisted := &keyStore{path: s.path}
+	if err := persisted.load(); err != nil {
+		t.Fatalf("Failed to load store: %s", err)
+	}
+	if len(persisted.keys) != 1 || persisted.keys[0].ID != s.keys[0].ID || persisted.keys[0].State != api.KeyActive {
+		t.Fatalf("Unexpected persisted keys: %v", persisted.keys)
+	}
+}
+
+// TestRemoteKeyStore checks zones of untrusted sources couldn't create or open key stores of the server
+func TestRemoteKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cerber-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := filepath.Join(dir, "registry-keys.yaml")
+	bundle := "zones:\n- name: registry\n  sign:\n    method: ES256\n    generate: true\n    store: " + store + "\n"
+	if _, err := parseBundle([]byte(bundle), "application/yaml"); err == nil {
+		t.Fatal("Expected bundle zone generating keys to be rejected")
+	}
+
+	content := "name: registry\nsign:\n  method: ES256\n  generate: true\n  store: " + store + "\n"
+	zones, err := parseZoneFile([]byte(content), "registry.yaml", &gitFiles{git: &GitProvider{}})
+	if err != nil {
+		t.Fatal(err)
+	} else if err := zones[0].openKeys("registry.yaml", &gitFiles{git: &GitProvider{}}, true); err == nil {
+		t.Fatal("Expected git zone generating keys to be rejected")
+	}
+
+	if _, err := os.Stat(store); !os.IsNotExist(err) {
+		t.Fatalf("Key store shouldn't be created: %v", err)
+	}
+}
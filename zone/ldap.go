@@ -0,0 +1,533 @@
+package zone
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/ldap.v2"
+	"gopkg.in/yaml.v2"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/xphoenix/cerber/api"
+	"github.com/xphoenix/cerber/config"
+)
+
+// LDAPProvider serves a single zone which users are authenticated by bind against LDAP
+// or Active Directory server. Zone description (name, signing, groups with actions) is
+// loaded from the yaml file given in 'zone' query parameter, the same way as for
+// DirectoryProvider, along with 'ldap' section describing how to find users and how to
+// map LDAP groups onto the zone groups:
+//
+//	ldap://ldap.example.org:389/dc=example,dc=org?zone=/etc/cerber/ldap/registry.yaml
+type LDAPProvider struct {
+	url  *url.URL
+	file string
+
+	lock sync.RWMutex
+	zone *ldapZone
+
+	lifecycle
+}
+
+// LDAPConfig defines how zone users are looked up in the LDAP directory
+type LDAPConfig struct {
+	// Service account used to search users and groups. If empty anonymous bind is used
+	BindDN       string `yaml:"bind_dn"`
+	BindPassword string `yaml:"bind_password"`
+
+	// Filter to find user entry, %s is replaced with escaped user name
+	UserFilter string `yaml:"user_filter"`
+
+	// Filter to find groups of the user, %s is replaced with escaped user DN. If empty
+	// groups are taken from GroupAttribute of the user entry
+	GroupFilter string `yaml:"group_filter"`
+
+	// User entry attribute which lists group DNs user is member of
+	GroupAttribute string `yaml:"group_attribute"`
+
+	// Connection options
+	StartTLS           bool          `yaml:"start_tls"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify"`
+	PoolSize           int           `yaml:"pool"`
+	Timeout            time.Duration `yaml:"timeout"`
+
+	// How long resolved user DN & groups are reused without querying server
+	CacheTTL time.Duration `yaml:"cache"`
+
+	// Mapping of LDAP group DN to zone group names
+	Groups map[string][]string `yaml:"groups"`
+}
+
+func init() {
+	RegisterProviderFactory("ldap", newLDAPProvider)
+	RegisterProviderFactory("ldaps", newLDAPProvider)
+}
+
+// newLDAPProvider checks URL has server address and zone description
+func newLDAPProvider(u *url.URL) (api.Provider, error) {
+	// Zone description is required, LDAP provides only users
+	file := u.Query().Get("zone")
+	if file == "" {
+		return nil, fmt.Errorf("LDAP URL must have 'zone' query parameter: %s", u.String())
+	} else if u.Host == "" {
+		return nil, fmt.Errorf("LDAP URL must have host: %s", u.String())
+	}
+
+	return &LDAPProvider{
+		url:  u,
+		file: file,
+	}, nil
+}
+
+// URL returns URI for the current Provider. Protocol must be 'ldap' or 'ldaps', path is a
+// base DN for all searches and 'zone' query parameter points to the zone yaml file
+func (l *LDAPProvider) URL() *url.URL {
+	return l.url
+}
+
+// Start loads zone description and checks that LDAP server is reachable with configured
+// service account.
+// Before was started Provider returns no any zones
+func (l *LDAPProvider) Start() error {
+	log.Infof("Starting LDAP zone provider: %s", l.url.Host)
+
+	z, err := loadLDAPZone(l.file)
+	if err != nil {
+		l.setState(false, err)
+		return err
+	}
+
+	z.base = strings.TrimPrefix(l.url.Path, "/")
+	z.pool = newLDAPPool(l.url, &z.cfg, &l.lifecycle)
+
+	// Check connection
+	conn, err := z.pool.get()
+	if err != nil {
+		z.pool.close()
+		err = fmt.Errorf("Failed to connect LDAP server %s: %s", l.url.Host, err)
+		l.setState(false, err)
+		return err
+	}
+	z.pool.put(conn, false)
+
+	l.lock.Lock()
+	l.zone = z
+	l.lock.Unlock()
+
+	l.setState(true, nil)
+	return nil
+}
+
+// Stop closes all pooled LDAP connections. If provider wasn't started
+// then method returns without any actual work
+// After was stopped Provider returns no zones
+func (l *LDAPProvider) Stop() error {
+	l.lock.Lock()
+	z := l.zone
+	l.zone = nil
+	l.lock.Unlock()
+
+	if z != nil {
+		z.pool.close()
+	}
+	l.setState(false, nil)
+	return nil
+}
+
+// FindZone returns zone served by the provider if it has the given name
+func (l *LDAPProvider) FindZone(name string) (api.Zone, error) {
+	l.lock.RLock()
+	z := l.zone
+	l.lock.RUnlock()
+
+	if z == nil || strings.ToUpper(z.Name()) != strings.ToUpper(name) {
+		return nil, api.NewNotFoundError("zone", name)
+	}
+	return z, nil
+}
+
+// Zones returns name of the served zone
+func (l *LDAPProvider) Zones() []string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if l.zone == nil {
+		return nil
+	}
+	return []string{l.zone.Name()}
+}
+
+// ldapZone is a yaml zone which users are stored in LDAP directory
+type ldapZone struct {
+	*yamlZone
+
+	cfg   LDAPConfig
+	base  string
+	pool  *ldapPool
+	cache *membershipCache
+}
+
+// loadLDAPZone reads zone description along with ldap section from the given file
+func loadLDAPZone(path string) (*ldapZone, error) {
+	yz, err := loadZoneFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if yz.ZHashing != "" && strings.ToUpper(yz.ZHashing) != "NONE" {
+		return nil, fmt.Errorf("LDAP zone requires plain passwords, but hashing is %s: %s", yz.ZHashing, path)
+	}
+
+	bytes, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err = config.Secrets.ResolveYAML(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resolve secrets: %s %s", path, err)
+	}
+
+	section := struct {
+		LDAP LDAPConfig `yaml:"ldap"`
+	}{}
+	if err := yaml.Unmarshal(bytes, &section); err != nil {
+		return nil, fmt.Errorf("Failed to parse file: %s %s", path, err)
+	}
+
+	cfg := section.LDAP
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(uid=%s)"
+	}
+	if cfg.GroupFilter == "" && cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = 4
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	// Group DNs are compared in normalized form
+	groups := make(map[string][]string, len(cfg.Groups))
+	for dn, names := range cfg.Groups {
+		groups[normalizeDN(dn)] = names
+	}
+	cfg.Groups = groups
+
+	return &ldapZone{
+		yamlZone: yz,
+		cfg:      cfg,
+		cache:    &membershipCache{ttl: cfg.CacheTTL, entries: make(map[string]membership)},
+	}, nil
+}
+
+// HashPassword returns password as it is - LDAP server needs plain password to bind
+func (z *ldapZone) HashPassword(passwd string) (string, error) {
+	return passwd, nil
+}
+
+// FindUser returns user with groups resolved from the LDAP directory
+func (z *ldapZone) FindUser(userID string) (*api.User, error) {
+	m, err := z.resolve(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &api.User{Name: userID, Groups: m.groups}, nil
+}
+
+// Authenticate binds to the LDAP server as the given user to verify password
+func (z *ldapZone) Authenticate(userID, passwd string) (*api.User, error) {
+	// Bind with empty password is an anonymous bind and always succeed
+	if passwd == "" {
+		return nil, errors.New("Wrong password")
+	}
+
+	m, err := z.resolve(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := z.pool.get()
+	if err != nil {
+		return nil, z.unavailable(err)
+	}
+
+	bindErr := conn.Bind(m.dn, passwd)
+
+	// Connection is bound as user now, return service identity before reuse
+	z.pool.put(conn, z.pool.rebind(conn) != nil)
+
+	if bindErr != nil {
+		if ldap.IsErrorWithCode(bindErr, ldap.LDAPResultInvalidCredentials) {
+			return nil, errors.New("Wrong password")
+		}
+		return nil, z.unavailable(fmt.Errorf("Failed to bind as %s: %s", m.dn, bindErr))
+	}
+	return &api.User{Name: userID, Groups: m.groups}, nil
+}
+
+// resolve finds user DN and zone groups either in cache or in LDAP directory
+func (z *ldapZone) resolve(userID string) (membership, error) {
+	if m, ok := z.cache.get(userID); ok {
+		return m, nil
+	}
+
+	conn, err := z.pool.get()
+	if err != nil {
+		return membership{}, z.unavailable(err)
+	}
+
+	m, err := z.lookup(conn, userID)
+	z.pool.put(conn, ldap.IsErrorWithCode(err, ldap.ErrorNetwork))
+	if err != nil {
+		if _, ok := err.(*ldap.Error); ok {
+			return membership{}, z.unavailable(fmt.Errorf("Failed to lookup user %s: %s", userID, err))
+		}
+		return membership{}, err
+	}
+
+	z.cache.put(userID, m)
+	return m, nil
+}
+
+func (z *ldapZone) lookup(conn *ldap.Conn, userID string) (membership, error) {
+	attrs := []string{"dn"}
+	if z.cfg.GroupAttribute != "" {
+		attrs = append(attrs, z.cfg.GroupAttribute)
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		z.base, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(z.cfg.Timeout.Seconds()), false,
+		expandFilter(z.cfg.UserFilter, userID), attrs, nil,
+	))
+	if err != nil {
+		return membership{}, err
+	}
+
+	switch len(res.Entries) {
+	case 0:
+		return membership{}, api.NewNotFoundError("user", userID)
+	case 1:
+	default:
+		return membership{}, fmt.Errorf("Ambiguous user: %s", userID)
+	}
+
+	entry := res.Entries[0]
+	dns := make([]string, 0)
+	if z.cfg.GroupAttribute != "" {
+		dns = append(dns, entry.GetAttributeValues(z.cfg.GroupAttribute)...)
+	}
+
+	if z.cfg.GroupFilter != "" {
+		res, err := conn.Search(ldap.NewSearchRequest(
+			z.base, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(z.cfg.Timeout.Seconds()), false,
+			expandFilter(z.cfg.GroupFilter, entry.DN), []string{"dn"}, nil,
+		))
+		if err != nil {
+			return membership{}, err
+		}
+
+		for _, g := range res.Entries {
+			dns = append(dns, g.DN)
+		}
+	}
+
+	return membership{dn: entry.DN, groups: z.mapGroups(dns)}, nil
+}
+
+// mapGroups converts LDAP group DNs into the unique list of zone group names
+func (z *ldapZone) mapGroups(dns []string) []string {
+	seen := make(map[string]bool)
+	groups := make([]string, 0, len(dns))
+	for _, dn := range dns {
+		for _, name := range z.cfg.Groups[normalizeDN(dn)] {
+			if !seen[name] {
+				seen[name] = true
+				groups = append(groups, name)
+			}
+		}
+	}
+	return groups
+}
+
+// unavailable reports LDAP server failure
+func (z *ldapZone) unavailable(err error) error {
+	return &api.UnavailableError{Source: "LDAP server " + z.pool.url.Host, Err: err}
+}
+
+// expandFilter substitutes escaped value into the filter template
+func expandFilter(filter, value string) string {
+	return strings.Replace(filter, "%s", ldap.EscapeFilter(value), -1)
+}
+
+// normalizeDN converts DN into form suitable for comparison
+func normalizeDN(dn string) string {
+	parts := strings.Split(dn, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	return strings.ToLower(strings.Join(parts, ","))
+}
+
+// membership is a user DN along with resolved zone groups
+type membership struct {
+	dn      string
+	groups  []string
+	expires time.Time
+}
+
+// membershipCache keeps resolved users for configured time to not query LDAP server
+// on every request
+type membershipCache struct {
+	ttl time.Duration
+
+	lock    sync.Mutex
+	entries map[string]membership
+}
+
+func (c *membershipCache) get(userID string) (membership, bool) {
+	if c.ttl <= 0 {
+		return membership{}, false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	m, ok := c.entries[userID]
+	if !ok || time.Now().After(m.expires) {
+		delete(c.entries, userID)
+		return membership{}, false
+	}
+	return m, true
+}
+
+func (c *membershipCache) put(userID string, m membership) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	m.expires = time.Now().Add(c.ttl)
+	c.entries[userID] = m
+}
+
+// ldapPool keeps a limited number of connections bound with service account
+type ldapPool struct {
+	url   *url.URL
+	cfg   *LDAPConfig
+	state *lifecycle
+
+	conns chan *ldap.Conn
+}
+
+func newLDAPPool(u *url.URL, cfg *LDAPConfig, state *lifecycle) *ldapPool {
+	return &ldapPool{
+		url:   u,
+		cfg:   cfg,
+		state: state,
+		conns: make(chan *ldap.Conn, cfg.PoolSize),
+	}
+}
+
+// get returns idle connection or dials a new one. Failure to connect server puts
+// provider offline
+func (p *ldapPool) get() (*ldap.Conn, error) {
+	select {
+	case c := <-p.conns:
+		return c, nil
+	default:
+	}
+
+	c, err := p.dial()
+	if err != nil {
+		p.state.setState(false, err)
+		return nil, err
+	}
+	return c, nil
+}
+
+// put returns connection to the pool. Connection is closed if the pool is full or if
+// it is broken and can't be reused
+func (p *ldapPool) put(c *ldap.Conn, broken bool) {
+	if broken {
+		c.Close()
+		return
+	}
+
+	select {
+	case p.conns <- c:
+	default:
+		c.Close()
+	}
+}
+
+// rebind restores service account identity on the connection. Empty bind DN
+// makes connection anonymous again
+func (p *ldapPool) rebind(c *ldap.Conn) error {
+	return c.Bind(p.cfg.BindDN, p.cfg.BindPassword)
+}
+
+// close closes all idle connections
+func (p *ldapPool) close() {
+	for {
+		select {
+		case c := <-p.conns:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (p *ldapPool) dial() (*ldap.Conn, error) {
+	host := p.url.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		if p.url.Scheme == "ldaps" {
+			host = net.JoinHostPort(host, "636")
+		} else {
+			host = net.JoinHostPort(host, "389")
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         p.url.Hostname(),
+		InsecureSkipVerify: p.cfg.InsecureSkipVerify,
+	}
+
+	var conn *ldap.Conn
+	var err error
+	if p.url.Scheme == "ldaps" {
+		conn, err = ldap.DialTLS("tcp", host, tlsConfig)
+	} else {
+		conn, err = ldap.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(p.cfg.Timeout)
+
+	if p.cfg.StartTLS && p.url.Scheme == "ldap" {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if p.cfg.BindDN != "" {
+		if err := conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,310 @@
+package zone
+
+import (
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/asn1-ber.v1"
+	"gopkg.in/ldap.v2"
+
+	"github.com/xphoenix/cerber/api"
+)
+
+// ldapTestServer is a minimal in-process LDAP server which supports simple bind
+// and search with and/or/not/equality/present filters
+type ldapTestServer struct {
+	listener  net.Listener
+	entries   []*ldap.Entry
+	passwords map[string]string
+
+	lock     sync.Mutex
+	searches int
+}
+
+func newLDAPTestServer(t *testing.T) *ldapTestServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+
+	s := &ldapTestServer{
+		listener: l,
+		entries: []*ldap.Entry{
+			ldap.NewEntry("cn=cerber,ou=services,dc=example,dc=org", map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"cerber"},
+			}),
+			ldap.NewEntry("uid=alice,ou=people,dc=example,dc=org", map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"alice"},
+				"memberOf":    {"cn=Admins, ou=groups,dc=example,dc=org", "cn=other,ou=groups,dc=example,dc=org"},
+			}),
+			ldap.NewEntry("uid=bob,ou=people,dc=example,dc=org", map[string][]string{
+				"objectClass": {"person"},
+				"uid":         {"bob"},
+			}),
+			ldap.NewEntry("cn=developers,ou=groups,dc=example,dc=org", map[string][]string{
+				"objectClass": {"groupOfNames"},
+				"member":      {"uid=bob,ou=people,dc=example,dc=org"},
+			}),
+		},
+		passwords: map[string]string{
+			"cn=cerber,ou=services,dc=example,dc=org": "service",
+			"uid=alice,ou=people,dc=example,dc=org":   "alice-secret",
+			"uid=bob,ou=people,dc=example,dc=org":     "bob-secret",
+		},
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *ldapTestServer) close() {
+	s.listener.Close()
+}
+
+func (s *ldapTestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		req, err := ber.ReadPacket(conn)
+		if err != nil || len(req.Children) < 2 {
+			return
+		}
+
+		id := req.Children[0].Value.(int64)
+		op := req.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name, passwd := op.Children[1].Data.String(), op.Children[2].Data.String()
+			code := ldap.LDAPResultInvalidCredentials
+			if (name == "" && passwd == "") || (passwd != "" && s.passwords[name] == passwd) {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationBindResponse, code).Bytes())
+
+		case ldap.ApplicationSearchRequest:
+			s.lock.Lock()
+			s.searches++
+			s.lock.Unlock()
+
+			base := strings.ToLower(op.Children[0].Data.String())
+			for _, e := range s.entries {
+				if !strings.HasSuffix(strings.ToLower(e.DN), base) || !matchFilter(e, op.Children[6]) {
+					continue
+				}
+				conn.Write(ldapEntry(id, e).Bytes())
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+
+		default:
+			return
+		}
+	}
+}
+
+func (s *ldapTestServer) searchCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.searches
+}
+
+func matchFilter(e *ldap.Entry, f *ber.Packet) bool {
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, c := range f.Children {
+			if !matchFilter(e, c) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, c := range f.Children {
+			if matchFilter(e, c) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matchFilter(e, f.Children[0])
+	case ldap.FilterEqualityMatch:
+		value := f.Children[1].Data.String()
+		for _, attr := range e.Attributes {
+			if strings.EqualFold(attr.Name, f.Children[0].Data.String()) {
+				for _, v := range attr.Values {
+					if strings.EqualFold(v, value) {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		return len(e.GetAttributeValues(f.Data.String())) > 0
+	}
+	return false
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	p.AppendChild(op)
+	return p
+}
+
+func ldapResult(id int64, tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "MatchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic"))
+	return ldapMessage(id, op)
+}
+
+func ldapEntry(id int64, e *ldap.Entry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "DN"))
+
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, a := range e.Attributes {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, a.Name, "Name"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range a.Values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(vals)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return ldapMessage(id, op)
+}
+
+// startLDAPProvider writes zone file with given ldap section and starts provider against test server
+func startLDAPProvider(t *testing.T, s *ldapTestServer, section string) api.Provider {
+	dir, err := ioutil.TempDir("", "cerber-ldap")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	zoneFile := filepath.Join(dir, "registry.yaml")
+	content := `
+name: registry
+hashing: none
+groups:
+- name: write
+  actions: ["team/app:push"]
+- name: read
+  actions: ["team/app:pull"]
+ldap:
+  bind_dn: cn=cerber,ou=services,dc=example,dc=org
+  bind_password: service
+  groups:
+    cn=admins,ou=groups,dc=example,dc=org: [read, write]
+    cn=developers,ou=groups,dc=example,dc=org: [read]
+` + section
+	if err := ioutil.WriteFile(zoneFile, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write zone file: %s", err)
+	}
+
+	loc := "ldap://" + s.listener.Addr().String() + "/dc=example,dc=org?zone=" + url.QueryEscape(zoneFile)
+	p, err := NewProvider(loc)
+	if err != nil {
+		t.Fatalf("Failed to create provider: %s", err)
+	}
+
+	if err := p.Start(); err != nil {
+		t.Fatalf("Failed to start provider: %s", err)
+	}
+	t.Cleanup(func() { p.Stop() })
+	return p
+}
+
+// TestLDAPAuthorize checks that users are authenticated with bind and
+// memberOf groups are mapped onto the zone actions
+func TestLDAPAuthorize(t *testing.T) {
+	s := newLDAPTestServer(t)
+	defer s.close()
+
+	p := startLDAPProvider(t, s, "")
+	z, err := p.FindZone("REGISTRY")
+	if err != nil {
+		t.Fatalf("Failed to find zone: %s", err)
+	}
+
+	c, _ := api.New("test")
+	actions, err := c.Authorize(z, "alice", "alice-secret", api.AccessContext{})
+	if err != nil {
+		t.Fatalf("Failed to authorize alice: %s", err)
+	}
+
+	if strings.Join(actions, ",") != "team/app:pull,team/app:push" {
+		t.Fatalf("Unexpected actions: %v", actions)
+	}
+
+	if _, err := c.Authorize(z, "alice", "wrong", api.AccessContext{}); err == nil {
+		t.Fatal("Expected wrong password to be rejected")
+	}
+
+	if _, err := c.Authorize(z, "alice", "", api.AccessContext{}); err == nil {
+		t.Fatal("Expected empty password to be rejected")
+	}
+
+	if _, err := c.Authorize(z, "mallory", "secret", api.AccessContext{}); err == nil {
+		t.Fatal("Expected unknown user to be rejected")
+	}
+}
+
+// TestLDAPGroupFilter checks that groups could be searched by member DN
+func TestLDAPGroupFilter(t *testing.T) {
+	s := newLDAPTestServer(t)
+	defer s.close()
+
+	p := startLDAPProvider(t, s, "  group_filter: (&(objectClass=groupOfNames)(member=%s))\n")
+	z, _ := p.FindZone("registry")
+
+	usr, err := z.FindUser("bob")
+	if err != nil {
+		t.Fatalf("Failed to find bob: %s", err)
+	}
+
+	if len(usr.Groups) != 1 || usr.Groups[0] != "read" {
+		t.Fatalf("Unexpected groups: %v", usr.Groups)
+	}
+}
+
+// TestLDAPMembershipCache checks that resolved users are not looked up again while cached
+func TestLDAPMembershipCache(t *testing.T) {
+	s := newLDAPTestServer(t)
+	defer s.close()
+
+	p := startLDAPProvider(t, s, "  cache: 1m\n")
+	z, _ := p.FindZone("registry")
+
+	if _, err := z.FindUser("alice"); err != nil {
+		t.Fatalf("Failed to find alice: %s", err)
+	}
+
+	count := s.searchCount()
+	c, _ := api.New("test")
+	if _, err := c.Authorize(z, "alice", "alice-secret", api.AccessContext{}); err != nil {
+		t.Fatalf("Failed to authorize alice: %s", err)
+	}
+
+	if s.searchCount() != count {
+		t.Fatalf("Expected cached user to be used, but server was queried %d times", s.searchCount()-count)
+	}
+}
@@ -0,0 +1,90 @@
+package zone
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/xphoenix/cerber/api"
+)
+
+// watchers keeps callbacks registered by Watch and notifies them about zone changes.
+// Providers reloading zones in background embed it to implement api.Watcher
+type watchers struct {
+	lock      sync.Mutex
+	callbacks []func(zone string)
+}
+
+// Watch registers callback which is called with zone name once zone was changed or removed
+func (w *watchers) Watch(callback func(zone string)) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.callbacks = append(w.callbacks, callback)
+}
+
+// notify calls all registered callbacks for every given zone name
+func (w *watchers) notify(names ...string) {
+	w.lock.Lock()
+	callbacks := w.callbacks
+	w.lock.Unlock()
+
+	for _, name := range names {
+		for _, c := range callbacks {
+			c(name)
+		}
+	}
+}
+
+// notifySwap reports all zones of the both old and new set as changed
+func (w *watchers) notifySwap(old, new map[string]api.Zone) {
+	names := make([]string, 0, len(old)+len(new))
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	w.notify(names...)
+}
+
+// lifecycle tracks if provider serves zones along with the last error it faced.
+// Providers embed it to implement IsOnline
+type lifecycle struct {
+	stateLock sync.RWMutex
+	online    bool
+	lastErr   error
+}
+
+// IsOnline returns true if provider was started successfully and serves zones. Error is
+// the last problem provider faced, it could be set for online provider too, for example
+// if background refresh failed and provider serves previously loaded zones
+func (l *lifecycle) IsOnline() (bool, error) {
+	l.stateLock.RLock()
+	defer l.stateLock.RUnlock()
+	return l.online, l.lastErr
+}
+
+// setState changes online flag and last error
+func (l *lifecycle) setState(online bool, err error) {
+	l.stateLock.Lock()
+	defer l.stateLock.Unlock()
+	l.online, l.lastErr = online, err
+}
+
+// setError keeps online flag and changes the last error only
+func (l *lifecycle) setError(err error) {
+	l.stateLock.Lock()
+	defer l.stateLock.Unlock()
+	l.lastErr = err
+}
+
+// zoneNames returns names of the given zones
+func zoneNames(zones map[string]api.Zone) []string {
+	names := make([]string, 0, len(zones))
+	for _, z := range zones {
+		names = append(names, z.Name())
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,523 @@
+package zone
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/xphoenix/cerber/api"
+	"gopkg.in/yaml.v2"
+)
+
+// localZone is a zone loaded from the file of the directory provider. Changes are written back into
+// the zone document of the file, so secret references, includes, unknown fields, other documents and
+// comments around the zone document are kept. Comments inside of the changed document are lost as
+// it is rewritten as a whole. Users and groups defined in included files couldn't be modified
+type localZone struct {
+	*yamlZone
+
+	source   string
+	provider *DirectoryProvider
+}
+
+// rawZone is a zone document as it is written in the file along with the zone loaded from it. Both
+// are read under the write lock, so checks against the zone see changes made concurrently
+type rawZone struct {
+	doc  yaml.MapSlice
+	zone *localZone
+}
+
+// Users returns all users of the zone including ones from included files
+func (z *localZone) Users() ([]api.User, error) {
+	return append([]api.User{}, z.ZUsers...), nil
+}
+
+// Groups returns all groups of the zone including ones from included files
+func (z *localZone) Groups() ([]api.Group, error) {
+	return append([]api.Group{}, z.ZGroups...), nil
+}
+
+// CreateUser appends user to the zone file
+func (z *localZone) CreateUser(usr api.User) error {
+	if usr.Name == "" {
+		return fmt.Errorf("User name is required")
+	}
+
+	return z.update(func(r *rawZone) error {
+		if _, err := r.zone.FindUser(usr.Name); err == nil {
+			return api.NewExistsError("user", usr.Name)
+		} else if err := r.zone.checkGroups(usr.Groups); err != nil {
+			return err
+		}
+
+		item := yaml.MapSlice{{Key: "name", Value: escapeRef(usr.Name)}, {Key: "passwd", Value: escapeRef(usr.Passwd)}}
+		if len(usr.Groups) > 0 {
+			item = append(item, yaml.MapItem{Key: "groups", Value: escapeRefs(usr.Groups)})
+		}
+		if len(usr.Until) > 0 {
+			item = append(item, yaml.MapItem{Key: "until", Value: usr.Until})
+		}
+		if usr.Passwd != "" {
+			item = append(item, yaml.MapItem{Key: "password_changed_at", Value: now()})
+		}
+		r.set("users", append(r.list("users"), setState(item, usr)))
+		return nil
+	})
+}
+
+// UpdateUser replaces user groups, membership expiries, state and password if set
+func (z *localZone) UpdateUser(usr api.User) error {
+	return z.updateItem("user", "users", usr.Name, func(r *rawZone, item yaml.MapSlice) (yaml.MapSlice, error) {
+		if err := r.zone.checkGroups(usr.Groups); err != nil {
+			return nil, err
+		}
+		if usr.Passwd != "" {
+			item = r.zone.changePassword(item, usr.Passwd)
+		}
+		item = setState(item, usr)
+		item = setField(item, "groups", escapeRefs(usr.Groups))
+		if len(usr.Until) == 0 {
+			return removeField(item, "until"), nil
+		}
+		return setField(item, "until", usr.Until), nil
+	})
+}
+
+// DeleteUser removes user from the zone file
+func (z *localZone) DeleteUser(userID string) error {
+	return z.updateItem("user", "users", userID, func(r *rawZone, item yaml.MapSlice) (yaml.MapSlice, error) {
+		return nil, nil
+	})
+}
+
+// SetPassword replaces password hash of the user, records change time and clears must change
+// password flag
+func (z *localZone) SetPassword(userID, passwd string) error {
+	return z.updateItem("user", "users", userID, func(r *rawZone, item yaml.MapSlice) (yaml.MapSlice, error) {
+		return removeField(r.zone.changePassword(item, passwd), "must_change_password"), nil
+	})
+}
+
+// changePassword replaces password hash and records change time, replaced hash is kept in the
+// password history as long as zone password policy requires
+func (z *localZone) changePassword(item yaml.MapSlice, passwd string) yaml.MapSlice {
+	history := api.ZonePasswordPolicy(z).History
+	previous := append([]interface{}{field(item, "passwd")}, sequence(field(item, "password_history"))...)
+	if len(previous) > history {
+		previous = previous[:history]
+	}
+
+	if len(previous) == 0 {
+		item = removeField(item, "password_history")
+	} else {
+		item = setField(item, "password_history", previous)
+	}
+	item = setField(item, "passwd", escapeRef(passwd))
+	return setField(item, "password_changed_at", now())
+}
+
+// AddMember adds group to the user groups and sets membership expiry, zero until makes membership
+// permanent
+func (z *localZone) AddMember(userID, groupID string, until time.Time) error {
+	return z.updateItem("user", "users", userID, func(r *rawZone, item yaml.MapSlice) (yaml.MapSlice, error) {
+		if err := r.zone.checkGroups([]string{groupID}); err != nil {
+			return nil, err
+		}
+
+		item = setUntil(item, groupID, until)
+		groups := sequence(field(item, "groups"))
+		for _, g := range groups {
+			if g == groupID {
+				return item, nil
+			}
+		}
+		return setField(item, "groups", append(groups, escapeRef(groupID))), nil
+	})
+}
+
+// RemoveMember removes group from the user groups along with membership expiry
+func (z *localZone) RemoveMember(userID, groupID string) error {
+	return z.updateItem("user", "users", userID, func(r *rawZone, item yaml.MapSlice) (yaml.MapSlice, error) {
+		groups := sequence(field(item, "groups"))
+		for i, g := range groups {
+			if g == groupID {
+				item = setUntil(item, groupID, time.Time{})
+				return setField(item, "groups", append(groups[:i], groups[i+1:]...)), nil
+			}
+		}
+		return nil, api.NewNotFoundError("membership", userID+" in "+groupID)
+	})
+}
+
+// CreateGroup appends group to the zone file
+func (z *localZone) CreateGroup(grp api.Group) error {
+	if grp.Name == "" {
+		return fmt.Errorf("Group name is required")
+	}
+
+	return z.update(func(r *rawZone) error {
+		if _, err := r.zone.FindGroup(grp.Name); err == nil {
+			return api.NewExistsError("group", grp.Name)
+		}
+
+		item := yaml.MapSlice{{Key: "name", Value: escapeRef(grp.Name)}, {Key: "actions", Value: escapeRefs(grp.Actions)}}
+		if grp.When != nil {
+			item = append(item, yaml.MapItem{Key: "when", Value: grp.When})
+		}
+		r.set("groups", append(r.list("groups"), item))
+		return nil
+	})
+}
+
+// UpdateGroup replaces group actions and conditions
+func (z *localZone) UpdateGroup(grp api.Group) error {
+	return z.updateItem("group", "groups", grp.Name, func(r *rawZone, item yaml.MapSlice) (yaml.MapSlice, error) {
+		item = setField(item, "actions", escapeRefs(grp.Actions))
+		if grp.When == nil {
+			return removeField(item, "when"), nil
+		}
+		return setField(item, "when", grp.When), nil
+	})
+}
+
+// DeleteGroup removes group which has no members
+func (z *localZone) DeleteGroup(groupID string) error {
+	return z.updateItem("group", "groups", groupID, func(r *rawZone, item yaml.MapSlice) (yaml.MapSlice, error) {
+		for _, u := range r.zone.ZUsers {
+			for _, g := range u.Groups {
+				if g == groupID {
+					return nil, fmt.Errorf("Group %s still has members, for example %s", groupID, u.Name)
+				}
+			}
+		}
+		return nil, nil
+	})
+}
+
+// checkGroups verifies all given groups exist in the zone
+func (z *localZone) checkGroups(groups []string) error {
+	for _, g := range groups {
+		if _, err := z.FindGroup(g); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateItem changes user or group defined in the zone file, nil item returned by change removes it
+func (z *localZone) updateItem(kind, key, name string, change func(r *rawZone, item yaml.MapSlice) (yaml.MapSlice, error)) error {
+	return z.update(func(r *rawZone) error {
+		items := r.list(key)
+		for i, v := range items {
+			item, ok := v.(yaml.MapSlice)
+			if !ok || field(item, "name") != name {
+				continue
+			}
+
+			changed, err := change(r, item)
+			if err != nil {
+				return err
+			} else if changed == nil {
+				items = append(items[:i], items[i+1:]...)
+			} else {
+				items[i] = changed
+			}
+			r.set(key, items)
+			return nil
+		}
+
+		// Entity could come from the included file
+		if _, err := r.zone.find(kind, name); err == nil {
+			return &api.ReadOnlyError{Zone: z.ZName, Reason: fmt.Sprintf("%s %s is defined in the included file", kind, name)}
+		}
+		return api.NewNotFoundError(kind, name)
+	})
+}
+
+func (z *localZone) find(kind, name string) (interface{}, error) {
+	if kind == "user" {
+		return z.FindUser(name)
+	}
+	return z.FindGroup(name)
+}
+
+// update applies change to the zone document and stores the file
+func (z *localZone) update(change func(r *rawZone) error) error {
+	ext := strings.ToLower(filepath.Ext(z.source))
+	if ext != ".yaml" && ext != ".yml" {
+		return &api.ReadOnlyError{Zone: z.ZName, Reason: "only yaml zone files could be modified"}
+	}
+	return z.provider.updateZone(z.source, z.ZName, change)
+}
+
+// list returns items of the list field
+func (r *rawZone) list(key string) []interface{} {
+	items, _ := field(r.doc, key).([]interface{})
+	return items
+}
+
+// set replaces field value, field is appended if missing
+func (r *rawZone) set(key string, value interface{}) {
+	r.doc = setField(r.doc, key, value)
+}
+
+// field returns value of the mapping key
+func field(m yaml.MapSlice, key string) interface{} {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// setField replaces value of the mapping key keeping keys order
+func setField(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+	for i, item := range m {
+		if item.Key == key {
+			m[i].Value = value
+			return m
+		}
+	}
+	return append(m, yaml.MapItem{Key: key, Value: value})
+}
+
+// removeField removes mapping key if present
+func removeField(m yaml.MapSlice, key string) yaml.MapSlice {
+	for i, item := range m {
+		if item.Key == key {
+			return append(m[:i], m[i+1:]...)
+		}
+	}
+	return m
+}
+
+// setState replaces user state fields, unset ones are removed. Password change time is kept as it is
+// maintained by password changes
+func setState(item yaml.MapSlice, usr api.User) yaml.MapSlice {
+	fields := []struct {
+		key   string
+		set   bool
+		value interface{}
+	}{
+		{"disabled", usr.Disabled, true},
+		{"expires_at", usr.ExpiresAt != "", escapeRef(usr.ExpiresAt)},
+		{"password_max_age", usr.PasswordMaxAge > 0, usr.PasswordMaxAge.String()},
+		{"must_change_password", usr.MustChangePassword, true},
+	}
+
+	for _, f := range fields {
+		if f.set {
+			item = setField(item, f.key, f.value)
+		} else {
+			item = removeField(item, f.key)
+		}
+	}
+	return item
+}
+
+// now returns current time as it is written into zone files, sub-second precision keeps password
+// change apart from tokens issued in the same second
+func now() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// setUntil sets expiry of the user membership in the group, zero time removes it
+func setUntil(item yaml.MapSlice, groupID string, until time.Time) yaml.MapSlice {
+	expiry, _ := field(item, "until").(yaml.MapSlice)
+	expiry = append(yaml.MapSlice{}, expiry...)
+	if until.IsZero() {
+		expiry = removeField(expiry, escapeRef(groupID))
+	} else {
+		expiry = setField(expiry, escapeRef(groupID), until.UTC().Format(time.RFC3339))
+	}
+
+	if len(expiry) == 0 {
+		return removeField(item, "until")
+	}
+	return setField(item, "until", expiry)
+}
+
+// sequence returns copy of the yaml sequence
+func sequence(v interface{}) []interface{} {
+	items, _ := v.([]interface{})
+	return append([]interface{}{}, items...)
+}
+
+// escapeRef protects written value from being resolved as a secret reference
+func escapeRef(s string) string {
+	return strings.Replace(s, "${", "$${", -1)
+}
+
+func escapeRefs(list []string) []string {
+	result := make([]string, len(list))
+	for i, s := range list {
+		result[i] = escapeRef(s)
+	}
+	return result
+}
+
+// updateZone applies change to the document of the named zone in the source file. Change sees the
+// zone as it is currently stored in the file. New content is loaded as a whole before it replaces the
+// file, so broken change never reaches the disk. Only the changed document is rewritten, other
+// documents and their separators are kept byte to byte. Zones of the file are replaced afterwards
+// and watchers are notified
+func (d *DirectoryProvider) updateZone(source, name string, change func(r *rawZone) error) error {
+	d.writeLock.Lock()
+	defer d.writeLock.Unlock()
+
+	data, err := ioutil.ReadFile(source)
+	if err != nil {
+		return &api.UnavailableError{Source: source, Err: err}
+	}
+
+	current := newZoneSet(osFiles{})
+	current.maintainKeys = false
+	current.add(source, data, d.wrapper(source))
+	if err := current.err(); err != nil {
+		return fmt.Errorf("Zone file %s couldn't be changed: %s", source, err)
+	}
+	z, ok := current.zones[strings.ToUpper(name)].(*localZone)
+	if !ok {
+		return api.NewNotFoundError("zone", name)
+	}
+
+	docs := splitYAML(data)
+	index := -1
+	raw := &rawZone{zone: z}
+	for i, doc := range docs {
+		var m yaml.MapSlice
+		if err := yaml.Unmarshal(doc, &m); err != nil {
+			return fmt.Errorf("Failed to parse zone file %s: %s", source, err)
+		}
+		if n, ok := field(m, "name").(string); ok && strings.EqualFold(n, name) {
+			index, raw.doc = i, m
+			break
+		}
+	}
+	if index == -1 {
+		return api.NewNotFoundError("zone", name)
+	}
+
+	if err := change(raw); err != nil {
+		return err
+	}
+
+	doc, err := yaml.Marshal(raw.doc)
+	if err != nil {
+		return err
+	}
+	span := documentSpans(data)[index]
+	doc = keepComments(docs[index], doc)
+	if span.inline {
+		doc = append([]byte("---\n"), doc...)
+	}
+	data = append(append(append([]byte{}, data[:span.start]...), doc...), data[span.end:]...)
+
+	set := newZoneSet(osFiles{})
+	set.maintainKeys = false
+	set.add(source, data, d.wrapper(source))
+	if err := set.err(); err != nil {
+		return fmt.Errorf("Zone change is rejected: %s", err)
+	}
+
+	if err := writeFile(source, data); err != nil {
+		return &api.UnavailableError{Source: source, Err: err}
+	}
+
+	d.lock.Lock()
+	old := make(map[string]api.Zone)
+	for key, z := range d.zones {
+		if lz, ok := z.(*localZone); ok && lz.source == source {
+			old[key] = z
+			delete(d.zones, key)
+		}
+	}
+	for key, z := range set.zones {
+		d.zones[key] = z
+	}
+	d.lock.Unlock()
+
+	d.notifySwap(old, set.zones)
+	log.WithField("file", source).Infof("Zone %s is updated", name)
+	return nil
+}
+
+// documentSpan is a byte range of the yaml document in the file. Inline document has its content on
+// the line of the start marker, so the marker is covered by the span
+type documentSpan struct {
+	start, end int
+	inline     bool
+}
+
+// documentSpans returns ranges of the same documents splitYAML returns
+func documentSpans(data []byte) []documentSpan {
+	spans := make([]documentSpan, 0, 1)
+	current := documentSpan{}
+	offset := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		trimmed := bytes.TrimRight(line, "\r\n")
+		if isMarker(trimmed, "---") || isMarker(trimmed, "...") {
+			current.end = offset
+			spans = append(spans, current)
+			current = documentSpan{start: offset + len(line)}
+			if isMarker(trimmed, "---") && len(trimmed) > 3 {
+				current = documentSpan{start: offset, inline: true}
+			}
+		}
+		offset += len(line)
+	}
+	current.end = offset
+	return append(spans, current)
+}
+
+// keepComments surrounds rewritten document with comments and blank lines found before and after the
+// content of the original one
+func keepComments(original, doc []byte) []byte {
+	lines := bytes.SplitAfter(original, []byte("\n"))
+	head, tail := 0, len(lines)
+	for head < tail && isComment(lines[head]) {
+		head++
+	}
+	for tail > head && isComment(lines[tail-1]) {
+		tail--
+	}
+
+	result := bytes.Join(lines[:head], nil)
+	result = append(result, doc...)
+	return append(result, bytes.Join(lines[tail:], nil)...)
+}
+
+func isComment(line []byte) bool {
+	trimmed := bytes.TrimSpace(line)
+	return len(trimmed) == 0 || trimmed[0] == '#'
+}
+
+// writeFile atomically replaces file keeping its permissions
+func writeFile(path string, data []byte) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	} else if err := tmp.Close(); err != nil {
+		return err
+	} else if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,244 @@
+package zone
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xphoenix/cerber/api"
+)
+
+// TestWritableZone checks changes are written into the zone document keeping secret references,
+// includes and other documents of the file
+func TestWritableZone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cerber-writable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("CERBER_TEST_ADMIN_HASH", "21232f297a57a5a743894a0e4a801fc3")
+	defer os.Unsetenv("CERBER_TEST_ADMIN_HASH")
+
+	files := map[string]string{
+		"zones.yaml": "name: mirror\n---\nname: registry\ninclude: [users.d/*.yaml]\nhashing: none\n" +
+			"password_policy:\n  min_length: 4\n  history: 2\n" +
+			"groups:\n- name: read\n  actions: ['*:pull']\n- name: write\n  actions: ['*:push']\n" +
+			"users:\n- name: admin\n  passwd: ${env:CERBER_TEST_ADMIN_HASH}\n  groups: [read]\n",
+		"users.d/ci.yaml": "users:\n- name: ci\n  passwd: x\n  groups: [read]\n",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0700)
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := NewProvider("directory://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	changed := make([]string, 0)
+	p.(api.Watcher).Watch(func(name string) { changed = append(changed, name) })
+
+	writable := func() api.WritableZone {
+		z, err := p.FindZone("registry")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, ok := api.Writable(z)
+		if !ok {
+			t.Fatal("Directory zone is not writable")
+		}
+		return w
+	}
+
+	if err := writable().CreateUser(api.User{Name: "deployer", Passwd: "hash", Groups: []string{"read"}}); err != nil {
+		t.Fatalf("Failed to create user: %s", err)
+	}
+	if err := writable().AddMember("deployer", "write", time.Time{}); err != nil {
+		t.Fatalf("Failed to add member: %s", err)
+	}
+	if err := writable().CreateUser(api.User{Name: "admin"}); !api.IsExists(err) {
+		t.Fatalf("Expected duplicated user error, found: %v", err)
+	}
+	if err := writable().AddMember("deployer", "missing", time.Time{}); !api.IsNotFound(err) {
+		t.Fatalf("Expected missing group error, found: %v", err)
+	}
+	if err := writable().SetPassword("ci", "hash"); !api.IsReadOnly(err) {
+		t.Fatalf("Expected included user to be read only, found: %v", err)
+	}
+	if err := writable().DeleteGroup("read"); err == nil {
+		t.Fatal("Expected group with members couldn't be deleted")
+	}
+
+	usr, err := writable().FindUser("deployer")
+	if err != nil || strings.Join(usr.Groups, ",") != "read,write" {
+		t.Fatalf("Zone wasn't reloaded: %v %v", usr, err)
+	}
+	if len(changed) == 0 {
+		t.Fatal("Watchers were not notified")
+	}
+
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "zones.yaml"))
+	content := string(data)
+	for _, s := range []string{"name: mirror\n---\n", "${env:CERBER_TEST_ADMIN_HASH}", "users.d/*.yaml", "name: deployer"} {
+		if !strings.Contains(content, s) {
+			t.Fatalf("Zone file lost %q:\n%s", s, content)
+		}
+	}
+
+	until := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := writable().AddMember("deployer", "read", until); err != nil {
+		t.Fatalf("Failed to set membership expiry: %s", err)
+	}
+	if usr, _ := writable().FindUser("deployer"); usr.Until["read"] != "2030-01-02T03:04:05Z" {
+		t.Fatalf("Membership expiry wasn't written: %v", usr.Until)
+	}
+	if err := writable().RemoveMember("deployer", "read"); err != nil {
+		t.Fatalf("Failed to remove member: %s", err)
+	}
+	if usr, _ := writable().FindUser("deployer"); len(usr.Until) != 0 || strings.Join(usr.Groups, ",") != "write" {
+		t.Fatalf("Membership expiry wasn't removed: %v", usr)
+	}
+
+	if err := writable().UpdateUser(api.User{Name: "deployer", Groups: []string{"write"}, Disabled: true, MustChangePassword: true}); err != nil {
+		t.Fatalf("Failed to update user state: %s", err)
+	}
+	if err := writable().SetPassword("deployer", "changed"); err != nil {
+		t.Fatalf("Failed to set password: %s", err)
+	}
+	if usr, _ := writable().FindUser("deployer"); !usr.Disabled || usr.MustChangePassword || usr.PasswordChangedAt == "" {
+		t.Fatalf("Unexpected user state after password change: %+v", usr)
+	}
+
+	cerber, _ := api.New("test")
+	if err := writable().UpdateUser(api.User{Name: "deployer", Groups: []string{"write"}}); err != nil {
+		t.Fatalf("Failed to enable user: %s", err)
+	}
+	for _, passwd := range []string{"abc", "hash", "changed"} {
+		if err, ok := cerber.ChangePassword(writable(), "deployer", "changed", passwd).(*api.PasswordPolicyError); !ok {
+			t.Fatalf("Expected password %s to be rejected by policy, found: %v", passwd, err)
+		}
+	}
+	if err := cerber.ChangePassword(writable(), "deployer", "wrong", "renewed"); err == nil {
+		t.Fatal("Expected password change with wrong current password to fail")
+	}
+	if err := cerber.ChangePassword(writable(), "deployer", "changed", "renewed"); err != nil {
+		t.Fatalf("Failed to change password: %s", err)
+	}
+	if usr, _ := writable().FindUser("deployer"); usr.Passwd != "renewed" || strings.Join(usr.PasswordHistory, ",") != "changed,hash" {
+		t.Fatalf("Password history wasn't recorded: %+v", usr)
+	}
+
+	if err := writable().DeleteUser("deployer"); err != nil {
+		t.Fatalf("Failed to delete user: %s", err)
+	}
+	if _, err := writable().FindUser("deployer"); !api.IsNotFound(err) {
+		t.Fatalf("User wasn't deleted: %v", err)
+	}
+}
+
+// TestWritableZoneLayout checks other documents, separators and comments around the changed zone are
+// kept and checks are done against the file content instead of the loaded snapshot
+func TestWritableZoneLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cerber-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := "# mirrors\n--- name: mirror # inline\n...\n---\n# registry zone\nname: registry\n" +
+		"hashing: none\ngroups:\n- name: read\n  actions: ['*:pull']\n# end of registry\n...\n"
+	source := filepath.Join(dir, "zones.yaml")
+	if err := ioutil.WriteFile(source, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewProvider("directory://" + dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	// Both creates start from the same snapshot, only one of them could win
+	z, _ := p.FindZone("registry")
+	w, _ := api.Writable(z)
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { results <- w.CreateUser(api.User{Name: "deployer", Groups: []string{"read"}}) }()
+	}
+	if first, second := <-results, <-results; (first == nil) == (second == nil) {
+		t.Fatalf("Expected exactly one create to succeed, found: %v and %v", first, second)
+	} else if first != nil && !api.IsExists(first) || second != nil && !api.IsExists(second) {
+		t.Fatalf("Expected duplicated user error, found: %v and %v", first, second)
+	}
+	if err := w.CreateGroup(api.Group{Name: "read"}); !api.IsExists(err) {
+		t.Fatalf("Expected duplicated group error, found: %v", err)
+	}
+	if err := w.DeleteGroup("read"); err == nil {
+		t.Fatal("Expected group with members created after snapshot couldn't be deleted")
+	}
+
+	data, _ := ioutil.ReadFile(source)
+	for _, s := range []string{"# mirrors\n--- name: mirror # inline\n...\n---\n# registry zone\nname: registry\n",
+		"name: deployer", "# end of registry\n...\n"} {
+		if !strings.Contains(string(data), s) {
+			t.Fatalf("Zone file lost %q:\n%s", s, data)
+		}
+	}
+	if strings.Count(string(data), "name: deployer") != 1 {
+		t.Fatalf("User is written twice:\n%s", data)
+	}
+}
+
+// TestWritableZoneKeys checks changes of users don't generate or rotate signing keys
+func TestWritableZoneKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cerber-writable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := "name: registry\nhashing: none\nsign:\n  method: ES256\n  generate: true\n  store: ../registry-keys.yaml\n"
+	os.Mkdir(filepath.Join(dir, "zones"), 0700)
+	if err := ioutil.WriteFile(filepath.Join(dir, "zones", "registry.yaml"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewProvider("directory://" + filepath.Join(dir, "zones"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	// Forget generated key, so maintenance would create and persist a new one
+	store := filepath.Join(dir, "registry-keys.yaml")
+	os.Remove(store)
+	keyStoresLock.Lock()
+	delete(keyStores, store)
+	keyStoresLock.Unlock()
+
+	z, _ := p.FindZone("registry")
+	w, _ := api.Writable(z)
+	if err := w.CreateUser(api.User{Name: "deployer", Passwd: "hash"}); err != nil {
+		t.Fatalf("Failed to create user: %s", err)
+	}
+	if _, err := os.Stat(store); !os.IsNotExist(err) {
+		t.Fatalf("Expected user change to leave key store alone: %v", err)
+	}
+}
@@ -0,0 +1,315 @@
+package zone
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/xphoenix/cerber/api"
+	"github.com/xphoenix/cerber/config"
+)
+
+// zoneFormats maps supported zone file extensions to decoders. Decoder returns raw documents,
+// one per zone described in the file
+var zoneFormats = map[string]func(data []byte) ([]interface{}, error){
+	".yaml": decodeYAML,
+	".yml":  decodeYAML,
+	".json": decodeJSON,
+	".toml": decodeTOML,
+}
+
+// defaultIgnore are patterns of files never treated as zone descriptions: hidden files, editor
+// backups and swap files
+var defaultIgnore = []string{".*", "*~", "#*#", "*.bak", "*.orig", "*.swp"}
+
+// isZoneFile checks if file extension belongs to the supported zone format
+func isZoneFile(name string) bool {
+	_, ok := zoneFormats[strings.ToLower(filepath.Ext(name))]
+	return ok
+}
+
+// fileFilter selects zone files by extension and skips files matching ignore patterns
+type fileFilter struct {
+	ignore []string
+}
+
+// newFileFilter creates filter with default ignore patterns extended by the comma separated list
+func newFileFilter(patterns string) (*fileFilter, error) {
+	f := &fileFilter{ignore: append([]string{}, defaultIgnore...)}
+	for _, p := range strings.Split(patterns, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("Invalid ignore pattern: %s", p)
+		}
+		f.ignore = append(f.ignore, p)
+	}
+	return f, nil
+}
+
+// accept returns true if file with the given base name should be loaded
+func (f *fileFilter) accept(name string) bool {
+	for _, p := range f.ignore {
+		if ok, _ := filepath.Match(p, name); ok {
+			return false
+		}
+	}
+	return isZoneFile(name)
+}
+
+// parseZoneFile decodes all zones described in the file, format is chosen by file extension.
+// Source is used to choose format and for error reporting. Every zone must have a name
+func parseZoneFile(data []byte, source string, files fileReader) ([]*yamlZone, error) {
+	zones, err := decodeZoneFile(data, source, files)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, z := range zones {
+		if z.ZName == "" {
+			return nil, fmt.Errorf("Zone #%d has no name: %s", i+1, source)
+		}
+	}
+	return zones, nil
+}
+
+// decodeZoneFile decodes all zone documents of the file, documents could be partial. Files the zone
+// file comes from decide which secret references and key sources it could use
+func decodeZoneFile(data []byte, source string, files fileReader) ([]*yamlZone, error) {
+	decode, ok := zoneFormats[strings.ToLower(filepath.Ext(source))]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported zone file format: %s", source)
+	}
+
+	docs, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse file: %s %s", source, err)
+	}
+
+	zones := make([]*yamlZone, 0, len(docs))
+	for i, doc := range docs {
+		z, err := decodeZone(doc, files)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse file: %s (zone #%d) %s", source, i+1, err)
+		}
+		zones = append(zones, z)
+	}
+	return zones, nil
+}
+
+// decodeZone resolves secret references of the yaml document or decoded value and decodes zone.
+// Zone of untrusted files must have signing keys inline
+func decodeZone(doc interface{}, files fileReader) (*yamlZone, error) {
+	secrets := secretsOf(files)
+
+	// Zone is always decoded from yaml, so custom unmarshalers work for all formats
+	raw, ok := doc.([]byte)
+	var err error
+	if ok {
+		raw, err = secrets.ResolveYAML(raw)
+	} else if doc, err = secrets.ResolveValue(doc); err == nil {
+		raw, err = yaml.Marshal(doc)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !files.trusted() {
+		probe := struct {
+			Sign struct {
+				Cert *config.KeySource `yaml:"cert"`
+			} `yaml:"sign"`
+		}{}
+		if err := yaml.Unmarshal(raw, &probe); err != nil {
+			return nil, err
+		} else if probe.Sign.Cert != nil {
+			if err := probe.Sign.Cert.CheckInline(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	z := yamlZone{}
+	if err := yaml.Unmarshal(raw, &z); err != nil {
+		return nil, err
+	}
+	return &z, nil
+}
+
+// decodeYAML splits multi-document yaml stream, empty documents are skipped
+func decodeYAML(data []byte) ([]interface{}, error) {
+	docs := make([]interface{}, 0, 1)
+	for _, doc := range splitYAML(data) {
+		var v interface{}
+		if err := yaml.Unmarshal(doc, &v); err != nil {
+			return nil, err
+		} else if v != nil {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+// splitYAML splits stream by document markers: '---' starts a new document and '...' ends
+// the current one. Markers are recognized at the beginning of line only
+func splitYAML(data []byte) [][]byte {
+	docs := make([][]byte, 0, 1)
+	current := make([]byte, 0, len(data))
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		trimmed := bytes.TrimRight(line, "\r\n")
+		if isMarker(trimmed, "---") || isMarker(trimmed, "...") {
+			docs = append(docs, current)
+			current = make([]byte, 0)
+
+			// Document start marker could be followed by the content
+			if isMarker(trimmed, "---") && len(trimmed) > 3 {
+				current = append(current, trimmed[3:]...)
+				current = append(current, '\n')
+			}
+			continue
+		}
+		current = append(current, line...)
+	}
+	return append(docs, current)
+}
+
+func isMarker(line []byte, marker string) bool {
+	if !bytes.HasPrefix(line, []byte(marker)) {
+		return false
+	}
+	return len(line) == len(marker) || line[len(marker)] == ' ' || line[len(marker)] == '\t'
+}
+
+// decodeJSON accepts either single zone object or array of zones
+func decodeJSON(data []byte) ([]interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	if list, ok := v.([]interface{}); ok {
+		return list, nil
+	}
+	return []interface{}{v}, nil
+}
+
+// decodeTOML decodes single zone per file
+func decodeTOML(data []byte) ([]interface{}, error) {
+	v := make(map[string]interface{})
+	if _, err := toml.Decode(string(data), &v); err != nil {
+		return nil, err
+	}
+	return []interface{}{v}, nil
+}
+
+// zoneSet collects zones from several files. Broken files and duplicated zones are reported
+// per file and do not prevent other zones from being loaded
+type zoneSet struct {
+	files  fileReader
+	zones  map[string]api.Zone
+	errors []string
+
+	// Generated keys are rotated and persisted once zone is loaded, otherwise it is done on first use
+	maintainKeys bool
+}
+
+// newZoneSet creates empty set, zone includes are read from the given files
+func newZoneSet(files fileReader) *zoneSet {
+	return &zoneSet{files: files, zones: make(map[string]api.Zone), maintainKeys: true}
+}
+
+// zoneSource is a content of the zone file, wrap allows provider to decorate zones of the file
+type zoneSource struct {
+	name string
+	data []byte
+	wrap func(z *yamlZone) api.Zone
+}
+
+// add parses file and stores all its zones, wrap allows provider to decorate zones
+func (s *zoneSet) add(source string, data []byte, wrap func(z *yamlZone) api.Zone) {
+	s.addAll([]zoneSource{{name: source, data: data, wrap: wrap}})
+}
+
+// addAll parses files and stores all their zones. Files included by zones of other files are
+// fragments rather than zones, so they are skipped
+func (s *zoneSet) addAll(sources []zoneSource) {
+	parsed := make([][]*yamlZone, len(sources))
+	errs := make([]error, len(sources))
+	included := make(map[string]bool)
+	for i, src := range sources {
+		parsed[i], errs[i] = parseZoneFile(src.data, src.name, s.files)
+		for _, z := range parsed[i] {
+			for _, pattern := range z.ZInclude {
+				names, _ := s.files.glob(includePattern(src.name, pattern))
+				for _, name := range names {
+					if name != src.name {
+						included[name] = true
+					}
+				}
+			}
+		}
+	}
+
+	for i, src := range sources {
+		if included[src.name] {
+			log.Debugf("Skip included file: %s", src.name)
+		} else if errs[i] != nil {
+			s.fail(src.name, errs[i])
+		} else {
+			s.store(src.name, parsed[i], src.wrap)
+		}
+	}
+}
+
+// store resolves includes, rules and keys of the file zones and stores them
+func (s *zoneSet) store(source string, zones []*yamlZone, wrap func(z *yamlZone) api.Zone) {
+	// Zone with all its includes is loaded as a unit
+	for _, z := range zones {
+		if err := resolveIncludes(z, source, s.files); err != nil {
+			s.fail(source, err)
+			return
+		} else if err := z.validateRules(); err != nil {
+			s.fail(source, err)
+			return
+		} else if err := z.openKeys(source, s.files, s.maintainKeys); err != nil {
+			s.fail(source, err)
+			return
+		}
+	}
+
+	for _, z := range zones {
+		key := strings.ToUpper(z.Name())
+		if i, ok := s.zones[key]; ok {
+			s.fail(source, fmt.Errorf("Found duplicated zone: %s (%s) in %s", i.Name(), i.Description(), source))
+			continue
+		}
+		s.zones[key] = wrap(z)
+	}
+}
+
+// fail records problem with the given file
+func (s *zoneSet) fail(source string, err error) {
+	log.WithField("file", source).Errorf("Failed to load zone file: %s", err)
+	s.errors = append(s.errors, err.Error())
+}
+
+// err returns all problems found as a single error or nil
+func (s *zoneSet) err() error {
+	if len(s.errors) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Failed to load %d zone file(s): %s", len(s.errors), strings.Join(s.errors, "; "))
+}
+
+// yamlZoneOf returns zone as is
+func yamlZoneOf(z *yamlZone) api.Zone {
+	return z
+}
@@ -0,0 +1,182 @@
+package zone
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParseZoneFile checks zones are decoded by file extension
+func TestParseZoneFile(t *testing.T) {
+	files := map[string]string{
+		"zones.yaml": "---\nname: registry\nhashing: md5\n---\n# comment only\n---\nname: mirror\ntimeout: 5m\n...\n",
+		"zones.json": `[{"name": "registry", "hashing": "md5"}, {"name": "mirror", "timeout": "5m"}]`,
+		"zone.toml":  "name = \"registry\"\nhashing = \"md5\"\n\n[[groups]]\nname = \"admins\"\nactions = [\"*:*:*\"]\n",
+	}
+
+	for name, content := range files {
+		zones, err := parseZoneFile([]byte(content), name, osFiles{})
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %s", name, err)
+		}
+		if zones[0].Name() != "registry" || zones[0].ZHashing != "md5" {
+			t.Fatalf("Unexpected first zone in %s: %+v", name, zones[0])
+		}
+
+		if strings.HasSuffix(name, ".toml") {
+			if len(zones) != 1 || len(zones[0].ZGroups) != 1 || zones[0].ZGroups[0].Actions[0] != "*:*:*" {
+				t.Fatalf("Unexpected zones in %s: %+v", name, zones)
+			}
+		} else if len(zones) != 2 || zones[1].Timeout().String() != "5m0s" {
+			t.Fatalf("Unexpected zones in %s: %+v", name, zones)
+		}
+	}
+
+	if _, err := parseZoneFile([]byte("name: x"), "README.md", osFiles{}); err == nil {
+		t.Fatal("Expected unsupported extension to be rejected")
+	}
+}
+
+// TestDirectoryProvider checks broken and ignored files do not prevent other zones from being loaded and
+// included files next to the zone file are not loaded as zones
+func TestDirectoryProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cerber-directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"registry.yaml":       "name: registry\ninclude: [registry-users.yaml]\n",
+		"registry-users.yaml": "users:\n- name: admin\n",
+		"registry.yaml~":      "name: [",
+		".registry.yaml.swp":  "name: [",
+		"README.md":           "# zones",
+		"draft.yml":           "name: draft\n",
+		"broken.json":         "{",
+		"zz-copy.yml":         "name: REGISTRY\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := NewProvider("directory://" + dir + "?ignore=draft.*")
+	if err != nil {
+		t.Fatalf("Failed to create provider: %s", err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatalf("Failed to start provider: %s", err)
+	}
+	defer p.Stop()
+
+	if zones := p.Zones(); len(zones) != 1 || zones[0] != "registry" {
+		t.Fatalf("Expected registry zone only, found: %v", zones)
+	}
+
+	online, err := p.IsOnline()
+	if !online || err == nil {
+		t.Fatalf("Expected provider to be online and report broken files: %v %v", online, err)
+	}
+	if !strings.Contains(err.Error(), "broken.json") || !strings.Contains(err.Error(), "zz-copy.yml") {
+		t.Fatalf("Expected broken and duplicated files to be reported: %s", err)
+	} else if strings.Contains(err.Error(), "registry-users.yaml") {
+		t.Fatalf("Included file must not be loaded as zone: %s", err)
+	}
+
+	z, _ := p.FindZone("registry")
+	if _, err := z.FindUser("admin"); err != nil {
+		t.Fatalf("Expected included user: %s", err)
+	}
+}
+
+// TestZoneIncludes checks included files are merged into the zone and conflicts fail the zone
+func TestZoneIncludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cerber-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"registry.yaml":          "name: registry\ninclude: [users.d/*.yaml, groups/registry.json]\n",
+		"users.d/admins.yaml":    "users:\n- name: admin\n  groups: [write]\n",
+		"users.d/readers.yaml":   "users:\n- name: reader\n  groups: [read]\n",
+		"groups/registry.json":   `{"groups": [{"name": "write"}, {"name": "read"}], "hashing": "md5"}`,
+		"mirror.yaml":            "name: mirror\nhashing: none\ninclude: [mirror.d/*.yaml]\n",
+		"mirror.d/hashing.yaml":  "hashing: md5\n",
+		"broken.yaml":            "name: broken\ninclude: [missing.yaml]\n",
+		"nested.yaml":            "name: nested\ninclude: [nested.d/*.yaml]\n",
+		"nested.d/include.yaml":  "include: [other.yaml]\n",
+		"shared.yaml":            "name: shared\ninclude: [shared.d/*.yaml]\nusers:\n- name: admin\n",
+		"shared.d/admin.yaml":    "users:\n- name: admin\n",
+		"shared.d/unrelated.txt": "not a zone",
+	}
+	for name, content := range files {
+		full := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(full), 0700)
+		if err := ioutil.WriteFile(full, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, _ := NewProvider("directory://" + dir)
+	if err := p.Start(); err != nil {
+		t.Fatalf("Failed to start provider: %s", err)
+	}
+	defer p.Stop()
+
+	z, err := p.FindZone("registry")
+	if err != nil {
+		t.Fatalf("Failed to find zone: %s", err)
+	}
+	if _, err := z.FindUser("reader"); err != nil {
+		t.Fatalf("Expected included user: %s", err)
+	}
+	if _, err := z.FindGroup("write"); err != nil {
+		t.Fatalf("Expected included group: %s", err)
+	}
+	if h, _ := z.HashPassword("admin"); h != "21232f297a57a5a743894a0e4a801fc3" {
+		t.Fatalf("Expected included hashing to be used: %s", h)
+	}
+
+	_, err = p.IsOnline()
+	for _, zone := range []string{"mirror", "broken", "nested", "shared"} {
+		if _, found := p.FindZone(zone); found == nil {
+			t.Fatalf("Expected zone %s to fail", zone)
+		}
+		if !strings.Contains(err.Error(), zone) {
+			t.Fatalf("Expected zone %s to be reported: %s", zone, err)
+		}
+	}
+}
+
+// TestZoneSecrets checks secret references are resolved in all zone formats
+func TestZoneSecrets(t *testing.T) {
+	os.Setenv("CERBER_TEST_PASSWD", "21232f297a57a5a743894a0e4a801fc3")
+	defer os.Unsetenv("CERBER_TEST_PASSWD")
+
+	files := map[string]string{
+		"zone.yaml": "name: registry\nusers:\n- name: admin\n  passwd: ${env:CERBER_TEST_PASSWD}\n",
+		"zone.json": `{"name": "registry", "users": [{"name": "admin", "passwd": "${env:CERBER_TEST_PASSWD}"}]}`,
+		"zone.toml": "name = \"registry\"\n[[users]]\nname = \"admin\"\npasswd = \"${env:CERBER_TEST_PASSWD}\"\n",
+	}
+	for name, content := range files {
+		zones, err := parseZoneFile([]byte(content), name, osFiles{})
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %s", name, err)
+		}
+
+		usr, err := zones[0].FindUser("admin")
+		if err != nil || usr.Passwd != "21232f297a57a5a743894a0e4a801fc3" {
+			t.Fatalf("Expected password to be resolved in %s: %v %v", name, usr, err)
+		}
+	}
+
+	if _, err := parseZoneFile([]byte("name: x\nhashing: ${env:CERBER_TEST_MISSING}\n"), "zone.yaml", osFiles{}); err == nil {
+		t.Fatal("Expected unresolved reference to fail the zone")
+	}
+}
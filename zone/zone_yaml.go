@@ -4,6 +4,9 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/xphoenix/cerber/api"
@@ -16,14 +19,26 @@ type yamlZone struct {
 	ZName        string `yaml:"name"`
 	ZDescription string `yaml:"description,omitempty"`
 
+	// Files or globs merged into the zone, relative to the zone file
+	ZInclude []string `yaml:"include,omitempty"`
+
 	ZTimeout    *time.Duration `yaml:"timeout"`
 	ZMaxRefresh time.Duration  `yaml:"maxrefresh"`
 
 	ZGroups []api.Group `yaml:"groups"`
 	ZUsers  []api.User  `yaml:"users"`
 
+	// Expression policies applied on top of group actions, see api.PolicyZone
+	ZPolicies []api.Policy `yaml:"policies,omitempty"`
+
+	// Restrictions of passwords users change by themselves, see api.PasswordPolicyZone
+	ZPasswordPolicy *api.PasswordPolicy `yaml:"password_policy,omitempty"`
+
 	ZSign    SignInfo `yaml:"sign"`
 	ZHashing string   `yaml:"hashing"`
+
+	// Generated signing keys, set if sign.generate is true
+	keys *keyStore
 }
 
 // SignInfo defines signing mechnism along with parameters needed to actually
@@ -32,6 +47,17 @@ type SignInfo struct {
 	// Certificate used to validate keys signed by the current method
 	Method string             `yaml:"method"`
 	Cert   config.Certificate `yaml:"cert"`
+
+	// Generate keypair with self-signed certificate instead of cert and persist it in Store file
+	Generate bool   `yaml:"generate,omitempty"`
+	Store    string `yaml:"store,omitempty"`
+
+	// How long generated key signs tokens before it is replaced, 0 disables rotation. Next key
+	// is published as pending Publish before rotation, previous key verifies tokens during Grace
+	// after rotation. Both default to the zone timeout
+	Rotate  time.Duration `yaml:"rotate,omitempty"`
+	Publish time.Duration `yaml:"publish,omitempty"`
+	Grace   time.Duration `yaml:"grace,omitempty"`
 }
 
 // Name returns current zone name. That value will be used by Cerber
@@ -60,15 +86,101 @@ func (z *yamlZone) MaxRefresh() time.Duration {
 	return z.ZMaxRefresh
 }
 
+// SigningMethod returns name of the method tokens are signed with
+func (z *yamlZone) SigningMethod() string {
+	return z.ZSign.Method
+}
+
 // Certificate provides information enought to sign token issued for the users in
 // the current Zone
 func (z *yamlZone) Certificate() (*tls.Certificate, error) {
-	if z.ZSign.Method != "RS256" {
-		return nil, fmt.Errorf("Zone sign method '%s' doesn't require certificate", z.ZSign.Method)
+	if _, err := api.GetSigningMethod(z.ZSign.Method); err != nil {
+		return nil, fmt.Errorf("Zone sign method '%s' is not supported", z.ZSign.Method)
+	}
+	if z.keys != nil {
+		return z.keys.active(z.keyPolicy())
 	}
 	return &z.ZSign.Cert.Certificate, nil
 }
 
+// Keys returns generated keys in all rotation states, zone with configured certificate has the
+// only active key
+func (z *yamlZone) Keys() ([]api.ZoneKey, error) {
+	if z.keys != nil {
+		return z.keys.ring(z.keyPolicy())
+	}
+
+	cert, err := z.Certificate()
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := api.PublicKey(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := api.KeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+	return []api.ZoneKey{{ID: id, State: api.KeyActive, Public: pub, Chain: cert.Certificate}}, nil
+}
+
+// keyPolicy returns rotation settings of the generated keys
+func (z *yamlZone) keyPolicy() keyPolicy {
+	p := keyPolicy{
+		method:  z.ZSign.Method,
+		zone:    z.ZName,
+		rotate:  z.ZSign.Rotate,
+		publish: z.ZSign.Publish,
+		grace:   z.ZSign.Grace,
+	}
+	if p.publish == 0 {
+		p.publish = z.Timeout()
+	}
+	if p.grace == 0 {
+		p.grace = z.Timeout()
+	}
+	return p
+}
+
+// openKeys opens store of generated keys, maintain rotates keys and creates the first one if store is
+// empty. Relative store path is resolved against the local zone file, trusted remote zones must use
+// absolute path. Zones of untrusted sources couldn't generate keys as store is a file of the server
+func (z *yamlZone) openKeys(source string, files fileReader, maintain bool) error {
+	if !z.ZSign.Generate {
+		return nil
+	} else if !files.trusted() {
+		return fmt.Errorf("Zone %s couldn't generate signing keys, its source isn't trusted with server files", z.ZName)
+	} else if z.ZSign.Store == "" {
+		return fmt.Errorf("Zone %s generates signing keys, but has no store configured", z.ZName)
+	} else if len(z.ZSign.Cert.Certificate.Certificate) > 0 || z.ZSign.Cert.PrivateKey != nil {
+		return fmt.Errorf("Zone %s generates signing keys, cert couldn't be set", z.ZName)
+	}
+
+	store := z.ZSign.Store
+	if !filepath.IsAbs(store) {
+		if _, ok := files.(osFiles); !ok {
+			return fmt.Errorf("Key store of zone %s must be absolute path: %s", z.ZName, store)
+		}
+		store = filepath.Join(filepath.Dir(source), store)
+	}
+
+	keys, err := openKeyStore(store)
+	if err != nil {
+		return err
+	}
+
+	if maintain {
+		if err := keys.maintain(z.keyPolicy(), time.Now()); err != nil {
+			return fmt.Errorf("Failed to prepare signing keys of zone %s: %s", z.ZName, err)
+		}
+	}
+	z.keys = keys
+	return nil
+}
+
 // HashPassword crypts given password into the zone specific way
 func (z *yamlZone) HashPassword(passwd string) (string, error) {
 	h, err := ResolveHashAlgorithm(z.ZHashing)
@@ -85,7 +197,7 @@ func (z *yamlZone) FindUser(userID string) (usr *api.User, err error) {
 			return &usr, nil
 		}
 	}
-	return nil, fmt.Errorf("Unknown user: %s", userID)
+	return nil, api.NewNotFoundError("user", userID)
 }
 
 // FindGroup performs lookup of the group by the given name
@@ -95,5 +207,108 @@ func (z *yamlZone) FindGroup(groupID string) (*api.Group, error) {
 			return &grp, nil
 		}
 	}
-	return nil, fmt.Errorf("Unknown group: %s", groupID)
+	return nil, api.NewNotFoundError("group", groupID)
+}
+
+// Policies returns expression policies of the zone
+func (z *yamlZone) Policies() []api.Policy {
+	return z.ZPolicies
+}
+
+// PasswordPolicy returns password policy of the zone
+func (z *yamlZone) PasswordPolicy() *api.PasswordPolicy {
+	return z.ZPasswordPolicy
+}
+
+// validateRules checks conditions of all zone groups, user states, membership expiries, zone
+// policies and password policy are well formed
+func (z *yamlZone) validateRules() error {
+	if z.ZPasswordPolicy != nil {
+		if err := z.ZPasswordPolicy.Validate(); err != nil {
+			return fmt.Errorf("Zone %s: %s", z.ZName, err)
+		}
+	}
+	for _, u := range z.ZUsers {
+		if err := u.ValidateMemberships(); err != nil {
+			return fmt.Errorf("Zone %s: %s", z.ZName, err)
+		} else if err := u.ValidateState(); err != nil {
+			return fmt.Errorf("Zone %s: %s", z.ZName, err)
+		}
+	}
+	for _, g := range z.ZGroups {
+		if err := g.When.Validate(); err != nil {
+			return fmt.Errorf("Zone %s group %s has invalid condition: %s", z.ZName, g.Name, err)
+		}
+	}
+	for i := range z.ZPolicies {
+		if err := z.ZPolicies[i].Validate(); err != nil {
+			return fmt.Errorf("Zone %s: %s", z.ZName, err)
+		}
+	}
+	return nil
+}
+
+// loadZoneFile reads zone description along with its includes from the given file, file must describe
+// exactly one zone
+func loadZoneFile(path string) (*yamlZone, error) {
+	bytes, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	zones, err := parseZoneFile(bytes, path, osFiles{})
+	if err != nil {
+		return nil, err
+	} else if len(zones) != 1 {
+		return nil, fmt.Errorf("Expected single zone but found %d: %s", len(zones), path)
+	}
+
+	if err := resolveIncludes(zones[0], path, osFiles{}); err != nil {
+		return nil, err
+	} else if err := zones[0].validateRules(); err != nil {
+		return nil, err
+	} else if err := zones[0].openKeys(path, osFiles{}, true); err != nil {
+		return nil, err
+	}
+	return zones[0], nil
+}
+
+// LoadFile loads all zones of the file along with their includes without starting a provider, for
+// example to test zone policies before file is deployed. Signing keys are not opened
+func LoadFile(path string) ([]api.Zone, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	zones, err := parseZoneFile(data, path, osFiles{})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]api.Zone, len(zones))
+	for i, z := range zones {
+		if err := resolveIncludes(z, path, osFiles{}); err != nil {
+			return nil, err
+		} else if err := z.validateRules(); err != nil {
+			return nil, err
+		}
+		result[i] = z
+	}
+	return result, nil
+}
+
+// readFile loads whole content of the given file
+func readFile(path string) ([]byte, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open file: %s", path)
+	}
+	defer fd.Close()
+
+	bytes, err := ioutil.ReadAll(fd)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read file: %s", path)
+	}
+	return bytes, nil
 }